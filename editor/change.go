@@ -0,0 +1,190 @@
+package editor
+
+import (
+	"fmt"
+	"github.com/gdamore/tcell/v2"
+)
+
+// insertKey is a key typed in insert mode
+type insertKey struct {
+	key tcell.Key
+	r   rune
+}
+
+// change is a view mode command that changed the text, kept to be repeated by .
+type change struct {
+	keys     string
+	count    int
+	register rune
+	// insert holds the keys typed in the insert session started by the command
+	insert []insertKey
+	// repeatInsert is true when the count repeats the typed text, like for 3i
+	repeatInsert bool
+	// block is the block where the typed text is inserted on every line, after I, A or c
+	// in visual block mode
+	block *blockInsert
+}
+
+// executeNormal executes the view mode command keys on tab, count is 0 when none was
+// typed and register 0 when none was given. It returns the change to repeat with . when
+// the command changes the text.
+func (s *Window) executeNormal(tab *Tab, keys string, count int, register rune) *change {
+	var err error
+	switch keys {
+	case "u":
+		for i := 0; i < max(count, 1) && err == nil; i++ {
+			err = tab.Undo()
+		}
+	case "<C-r>":
+		for i := 0; i < max(count, 1) && err == nil; i++ {
+			err = tab.Redo()
+		}
+	case "<Esc>":
+		tab.ClearCursors()
+	case "<C-n>":
+		if !tab.AddNextMatch() {
+			GlobalState.ToastMessage("no other match")
+		}
+	case "<C-Up>", "<C-Down>":
+		dy := 1
+		if keys == "<C-Up>" {
+			dy = -1
+		}
+		tab.AddCursorLine(dy)
+	case "g-":
+		err = tab.Earlier(max(count, 1))
+	case "g+":
+		err = tab.Later(max(count, 1))
+	case "i":
+		c := &change{keys: keys, count: count, repeatInsert: true}
+		GlobalState.SetMode(ModeInsert)
+		s.insertChange = c
+		return c
+	case "x", "X":
+		// x and X are short for dl and dh
+		cmdKeys := "dl"
+		if keys == "X" {
+			cmdKeys = "dh"
+		}
+		cmd, _ := parseOperator(cmdKeys)
+		cmd.register = register
+		applied := false
+		tab.eachCursor(func() { applied = tab.applyOperator(cmd, count) || applied })
+		if applied {
+			return &change{keys: keys, count: count, register: register}
+		}
+	case "p", "P":
+		reg, ok := getRegister(register)
+		if !ok {
+			name := register
+			if name == 0 {
+				name = '"'
+			}
+			GlobalState.ToastMessage(fmt.Sprintf("nothing in register %c", name))
+			return nil
+		}
+		tab.eachCursor(func() { tab.put(reg, keys == "P", count) })
+		return &change{keys: keys, count: count, register: register}
+	default:
+		if runes := []rune(keys); len(runes) == 2 && runes[0] == 'm' {
+			tab.setMark(runes[1], tab.GetCursor())
+		} else if m, arg, status := parseMotion(keys); status == keysComplete {
+			moved := false
+			tab.eachCursor(func() { moved = tab.applyMotion(m, count, arg) || moved })
+			if isSearchMotion(keys) {
+				showSearchResult(tab, moved)
+			}
+		} else if cmd, status := parseOperator(keys); status == keysComplete {
+			cmd.register = register
+			return s.applyOperator(tab, cmd, keys, count)
+		}
+	}
+	if err != nil {
+		GlobalState.ToastMessage(err.Error())
+	}
+	return nil
+}
+
+// applyOperator applies an operator command to tab. After c, the deleted text and the
+// text typed in insert mode are undone as a single step.
+func (s *Window) applyOperator(tab *Tab, cmd operatorCommand, keys string, count int) *change {
+	tab.BeginChange()
+	defer tab.EndChange()
+	applied := false
+	tab.eachCursor(func() { applied = tab.applyOperator(cmd, count) || applied })
+	if !applied || cmd.op == 'y' {
+		return nil
+	}
+	c := &change{keys: keys, count: count, register: cmd.register}
+	if cmd.op == 'c' {
+		GlobalState.SetMode(ModeInsert)
+		s.insertChange = c
+	}
+	return c
+}
+
+// repeatChange repeats the last change on tab, a count replaces the count of the change
+func (s *Window) repeatChange(tab *Tab, count int) {
+	last := s.lastChange
+	if last == nil {
+		return
+	}
+	if count == 0 {
+		count = last.count
+	}
+	c := s.executeNormal(tab, last.keys, count, last.register)
+	if c == nil {
+		return
+	}
+	c.insert = last.insert
+	if c == s.insertChange {
+		for _, k := range c.insert {
+			applyInsertKey(tab, k)
+		}
+		GlobalState.SetMode(ModeView)
+	}
+	s.lastChange = c
+}
+
+// finishInsert ends the insert session of the current change. The typed text is
+// repeated when the command that started the session had a count, or inserted on the
+// other lines of a visual block.
+func (s *Window) finishInsert() {
+	c := s.insertChange
+	s.insertChange = nil
+	if c != nil && c.block != nil {
+		finishBlockInsert(s.insertTab, c)
+		return
+	}
+	if c == nil || !c.repeatInsert {
+		return
+	}
+	for i := 1; i < c.count; i++ {
+		for _, k := range c.insert {
+			applyInsertKey(s.insertTab, k)
+		}
+	}
+}
+
+// typeKey applies a key typed in insert mode to tab and records it in the change that
+// started the insert session
+func (s *Window) typeKey(tab *Tab, k insertKey) {
+	applyInsertKey(tab, k)
+	if s.insertChange != nil {
+		s.insertChange.insert = append(s.insertChange.insert, k)
+	}
+}
+
+// applyInsertKey applies a key typed in insert mode to tab
+func applyInsertKey(tab *Tab, k insertKey) {
+	switch k.key {
+	case tcell.KeyEnter:
+		tab.InsertNewline()
+	case tcell.KeyBackspace, tcell.KeyBackspace2:
+		tab.Backspace()
+	case tcell.KeyDelete:
+		tab.Delete()
+	default:
+		tab.InsertRune(k.r)
+	}
+}
@@ -0,0 +1,85 @@
+package editor
+
+import (
+	"github.com/dangdungcntt/ndditor/editor/layout"
+	"github.com/gdamore/tcell/v2"
+	"github.com/test-go/testify/require"
+	"strings"
+	"testing"
+)
+
+// newTestWindow creates the state and a window with a tab of text, listening to new events
+func newTestWindow(text string) (*Window, *Tab) {
+	InitEventEmitter()
+	GlobalState = NewState()
+	w := NewWindow()
+	tab := NewTab("", NewPieceTable([]byte(text)))
+	w.AddTab(tab)
+	w.Focus()
+	return w, tab
+}
+
+// typeKeys emits the keys like the editor does. <Esc>, <CR>, <BS>, <C-v>, <C-n>, <C-Up> and
+// <C-Down> stand for these keys.
+func typeKeys(w *Window, keys string) {
+	specialKeys := map[string]tcell.Key{"<Esc>": tcell.KeyEscape, "<CR>": tcell.KeyEnter, "<BS>": tcell.KeyBackspace2, "<C-v>": tcell.KeyCtrlV, "<C-n>": tcell.KeyCtrlN, "<C-Up>": tcell.KeyUp, "<C-Down>": tcell.KeyDown}
+	for keys != "" {
+		ev := tcell.NewEventKey(tcell.KeyRune, []rune(keys)[0], tcell.ModNone)
+		size := len(string([]rune(keys)[0]))
+		for name, key := range specialKeys {
+			if strings.HasPrefix(keys, name) {
+				ev = tcell.NewEventKey(key, 0, tcell.ModNone)
+				size = len(name)
+			}
+		}
+		keys = keys[size:]
+		var target layout.Element = w
+		if GlobalState.IsNormalMode() {
+			target = nil
+		}
+		EmitEvent(KeyEvent{Target: target, Ev: ev})
+	}
+}
+
+func tabText(tab *Tab) string {
+	return string(tab.doc.Slice(0, tab.doc.Len()))
+}
+
+func TestCountAndRepeat(t *testing.T) {
+	w, tab := newTestWindow("one two three four five six seven")
+	typeKeys(w, "2d2w")
+	require.Equal(t, "five six seven", tabText(tab))
+	typeKeys(w, ".")
+	require.Equal(t, "", tabText(tab))
+	require.NoError(t, tab.Undo())
+	require.Equal(t, "five six seven", tabText(tab))
+
+	typeKeys(w, "3x")
+	require.Equal(t, "e six seven", tabText(tab))
+	typeKeys(w, "w10.")
+	require.Equal(t, "e ", tabText(tab))
+
+	w, tab = newTestWindow("a\nb\nc\nd")
+	typeKeys(w, "2jk")
+	require.Equal(t, Position{Line: 1}, tab.GetCursor())
+	typeKeys(w, "3G")
+	require.Equal(t, Position{Line: 2}, tab.GetCursor())
+}
+
+func TestRepeatInsert(t *testing.T) {
+	w, tab := newTestWindow("foo bar baz")
+	typeKeys(w, "cwx<BS>new<Esc>")
+	require.Equal(t, "new bar baz", tabText(tab))
+	typeKeys(w, "w.")
+	require.Equal(t, "new new baz", tabText(tab))
+	require.NoError(t, tab.Undo())
+	require.Equal(t, "new bar baz", tabText(tab))
+
+	w, tab = newTestWindow("")
+	typeKeys(w, "3iab<CR><Esc>")
+	require.Equal(t, "ab\nab\nab\n", tabText(tab))
+	typeKeys(w, "2.")
+	require.Equal(t, "ab\nab\nab\nab\nab\n", tabText(tab))
+	require.NoError(t, tab.Undo())
+	require.Equal(t, "ab\nab\nab\n", tabText(tab))
+}
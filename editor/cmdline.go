@@ -0,0 +1,245 @@
+package editor
+
+import (
+	"github.com/dangdungcntt/ndditor/editor/layout"
+	"github.com/gdamore/tcell/v2"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// completion is the completion of the word before the cursor in the command line, tab
+// and shift-tab cycle through the candidates and back to the word as typed
+type completion struct {
+	// start is the column where the completed word starts, original the word as typed
+	start      int
+	original   string
+	candidates []string
+	// selected is the candidate in the command line, -1 for the word as typed
+	selected int
+}
+
+// handleCommandLineKey handles the keys that edit the command line, it returns false
+// for the other keys
+func (s *State) handleCommandLineKey(ev *tcell.EventKey) bool {
+	if ev.Key() != tcell.KeyTab && ev.Key() != tcell.KeyBacktab {
+		s.completion = nil
+	}
+	s.historyIndex = -1
+	if s.insertRegister {
+		s.insertRegister = false
+		if ev.Key() == tcell.KeyRune {
+			s.putRegister(ev.Rune())
+			return true
+		}
+	}
+	switch ev.Key() {
+	case tcell.KeyCtrlW:
+		s.deleteWordBeforeCursor()
+	case tcell.KeyCtrlU:
+		s.deleteBeforeCursor(0)
+	case tcell.KeyCtrlA:
+		s.setCommandCursor(0)
+	case tcell.KeyCtrlE:
+		s.setCommandCursor(s.pendingCommand.Len())
+	case tcell.KeyCtrlR:
+		s.insertRegister = true
+	case tcell.KeyTab, tcell.KeyBacktab:
+		if !isSearchPrompt(s.prompt) {
+			s.complete(ev.Key() == tcell.KeyTab)
+		}
+	default:
+		return false
+	}
+	s.previewSearch()
+	return true
+}
+
+// setCommandCursor moves the cursor of the command line to col
+func (s *State) setCommandCursor(col int) {
+	s.cursorX = col
+	s.pendingCommand.moveCursorTo(col)
+}
+
+// setCommand replaces the command line with text, the cursor goes to its end
+func (s *State) setCommand(text string) {
+	s.pendingCommand = NewEmptyLine(64)
+	s.cursorX = 0
+	s.WriteToCommand(text)
+}
+
+// deleteBeforeCursor deletes the text of the command line from col to the cursor
+func (s *State) deleteBeforeCursor(col int) {
+	for s.cursorX > col {
+		s.pendingCommand.DeleteBeforeCursor()
+		s.cursorX--
+	}
+}
+
+// deleteWordBeforeCursor executes ctrl-w, which deletes the blanks before the cursor and
+// then the word, or the run of other characters, before them
+func (s *State) deleteWordBeforeCursor() {
+	runes := []rune(s.GetCommand())
+	col := s.cursorX
+	for col > 0 && unicode.IsSpace(runes[col-1]) {
+		col--
+	}
+	if col > 0 {
+		word := isWordRune(runes[col-1])
+		for col > 0 && !unicode.IsSpace(runes[col-1]) && isWordRune(runes[col-1]) == word {
+			col--
+		}
+	}
+	s.deleteBeforeCursor(col)
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// putRegister executes ctrl-r {reg}, which inserts the text of the register at the cursor.
+// The line breaks are inserted as \n, the last one is dropped.
+func (s *State) putRegister(name rune) {
+	if !isRegisterName(name) {
+		return
+	}
+	reg, ok := getRegister(name)
+	if !ok {
+		return
+	}
+	text := strings.TrimSuffix(string(reg.text), "\n")
+	s.WriteToCommand(strings.ReplaceAll(text, "\n", `\n`))
+}
+
+// browseHistory shows the entry dy entries older, or newer when dy is positive, that
+// starts with the text typed before browsing. After the newest entry the typed text is
+// shown again.
+func (s *State) browseHistory(dy int) {
+	entries := s.history.get(s.prompt)
+	if s.historyIndex < 0 {
+		s.historyIndex = len(entries)
+		s.historyPrefix = s.GetCommand()
+	}
+	for i := s.historyIndex + dy; i >= 0 && i <= len(entries); i += dy {
+		if i == len(entries) {
+			s.historyIndex = i
+			s.setCommand(s.historyPrefix)
+			break
+		}
+		if strings.HasPrefix(entries[i], s.historyPrefix) {
+			s.historyIndex = i
+			s.setCommand(entries[i])
+			break
+		}
+	}
+	s.previewSearch()
+}
+
+// complete completes the word before the cursor, or goes to the next candidate, or to the
+// previous one when forward is false, when the word is being completed
+func (s *State) complete(forward bool) {
+	if s.completion == nil {
+		before := string([]rune(s.GetCommand())[:s.cursorX])
+		EmitEvent(CompletionEvent{Line: before})
+		return
+	}
+	c := s.completion
+	if forward {
+		c.selected++
+		if c.selected == len(c.candidates) {
+			c.selected = -1
+		}
+	} else {
+		c.selected--
+		if c.selected < -1 {
+			c.selected = len(c.candidates) - 1
+		}
+	}
+	text := c.original
+	if c.selected >= 0 {
+		text = c.candidates[c.selected]
+	}
+	s.deleteBeforeCursor(c.start)
+	s.WriteToCommand(text)
+}
+
+// SetCompletion sets the candidates completing the word that starts at the byte offset
+// start of the command line, the first one replaces the word. The candidates are listed
+// in the wildmenu when there are several of them.
+func (s *State) SetCompletion(start int, candidates []string) {
+	if len(candidates) == 0 || !s.IsMode(ModeCommand) {
+		return
+	}
+	col := utf8.RuneCountInString(s.GetCommand()[:start])
+	c := &completion{
+		start:      col,
+		original:   string([]rune(s.GetCommand())[col:s.cursorX]),
+		candidates: candidates,
+		selected:   -1,
+	}
+	s.completion = c
+	s.complete(true)
+	if len(candidates) == 1 {
+		s.completion = nil
+	}
+}
+
+// wildmenuName returns the name of a candidate shown in the wildmenu, the last element of
+// a path, split at the separators of the paths completed by completeFiles
+func wildmenuName(candidate string) string {
+	name := strings.TrimSuffix(candidate, string(filepath.Separator))
+	if i := strings.LastIndexFunc(name, func(r rune) bool {
+		return r < utf8.RuneSelf && os.IsPathSeparator(uint8(r))
+	}); i >= 0 {
+		return candidate[i+1:]
+	}
+	return candidate
+}
+
+// renderWildmenu draws the candidates of the completion on the row at point, the selected
+// one in green. The row scrolls to show the selected candidate, < and > mark the
+// candidates that do not fit.
+func (s *State) renderWildmenu(screen tcell.Screen, point layout.Point, width int) {
+	c := s.completion
+	names := make([]string, len(c.candidates))
+	for i, candidate := range c.candidates {
+		names[i] = wildmenuName(candidate)
+	}
+	rowWidth := func(names []string) int {
+		w := 4
+		for _, name := range names {
+			w += layout.StringWidth(name) + 2
+		}
+		return w
+	}
+	first := 0
+	for first < c.selected && rowWidth(names[first:c.selected+1]) > width {
+		first++
+	}
+	end := layout.Point{X: point.X + width, Y: point.Y}
+	x := point.X
+	if first > 0 {
+		layout.DrawText(screen, point, end, "<")
+		x += 2
+	}
+	for i := first; i < len(names); i++ {
+		w := layout.StringWidth(names[i])
+		room := point.X + width
+		if i < len(names)-1 {
+			// leaves room for the >
+			room -= 2
+		}
+		if x+w > room {
+			layout.DrawText(screen, layout.Point{X: point.X + width - 1, Y: point.Y}, end, ">")
+			return
+		}
+		var colors []tcell.Color
+		if i == c.selected {
+			colors = append(colors, tcell.ColorGreen)
+		}
+		layout.DrawText(screen, layout.Point{X: x, Y: point.Y}, end, names[i], colors...)
+		x += w + 2
+	}
+}
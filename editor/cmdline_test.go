@@ -0,0 +1,134 @@
+package editor
+
+import (
+	"github.com/dangdungcntt/ndditor/editor/layout"
+	"github.com/gdamore/tcell/v2"
+	"github.com/test-go/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// typeKeyNames types keys written like in :normal into the editor
+func typeKeyNames(e *Editor, keys string) {
+	for _, k := range parseKeyNames(keys) {
+		e.handleTypedKey(tcell.NewEventKey(k.Key, k.Rune, k.Mod))
+	}
+}
+
+func TestCommandLineEditing(t *testing.T) {
+	registers = map[rune]register{}
+	e := newTestEditor(t, "one two\nthree")
+	tests := []struct {
+		keys, want string
+		cursor     int
+	}{
+		{":open some/path.go<C-w>", "open some/path.", 15},
+		{":open some/path<C-w><C-w>", "open some", 9},
+		{":set ts=4  <C-w>", "set ts=", 7},
+		{":s/a/b<C-a>%<C-e>/g", "%s/a/b/g", 8},
+		{":abc<Left><C-u>x", "xc", 1},
+		{":yw<Left><C-r>a", "yonew", 4},
+		{":<C-r>b", "one two\\nthree", 14},
+		{":a<C-r><Esc>", "", 0},
+	}
+	typeEditorKeys(e, `"ayiw"byG`)
+	for _, test := range tests {
+		t.Run(test.keys, func(t *testing.T) {
+			typeKeyNames(e, test.keys)
+			require.Equal(t, test.want, GlobalState.GetCommand())
+			require.Equal(t, test.cursor, GlobalState.cursorX)
+			typeEditorKeys(e, "\x1b")
+		})
+	}
+}
+
+func TestCommandLineHistory(t *testing.T) {
+	e := newTestEditor(t, "a\nb")
+	typeKeyNames(e, ":set ts?<CR>:set sw?<CR>:noh<CR>/b<CR>")
+	require.Equal(t, []string{"set ts?", "set sw?", "noh"}, GlobalState.history.get(':'))
+	require.Equal(t, []string{"b"}, GlobalState.history.get('?'))
+
+	typeKeyNames(e, ":<Up>")
+	require.Equal(t, "noh", GlobalState.GetCommand())
+	typeKeyNames(e, "<Up><Up><Up>")
+	require.Equal(t, "set ts?", GlobalState.GetCommand())
+	typeKeyNames(e, "<Down><Down><Down>")
+	require.Equal(t, "", GlobalState.GetCommand())
+
+	// the typed text filters the entries
+	typeKeyNames(e, "<Esc>:se<Up>")
+	require.Equal(t, "set sw?", GlobalState.GetCommand())
+	typeKeyNames(e, "<Up><Up>")
+	require.Equal(t, "set ts?", GlobalState.GetCommand())
+	typeKeyNames(e, "<Down><Down>")
+	require.Equal(t, "se", GlobalState.GetCommand())
+
+	// a submitted line moves to the end, and the history is saved for the next session
+	typeKeyNames(e, "<Esc>:<Up><Up><Up><CR>")
+	require.Equal(t, []string{"set sw?", "noh", "set ts?"}, GlobalState.history.get(':'))
+	h, err := loadHistory()
+	require.NoError(t, err)
+	require.Equal(t, []string{"set sw?", "noh", "set ts?"}, h.get(':'))
+	require.Equal(t, []string{"b"}, h.get('/'))
+	// the command lines may hold text that other users should not read
+	info, err := os.Stat(h.path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	// the command lines typed by :normal and by macros are left out
+	typeEditorKeys(e, "\x1b:normal :set ts?<CR>/a<CR>\r")
+	require.Equal(t, []string{"set sw?", "noh", "set ts?", "normal :set ts?<CR>/a<CR>"}, GlobalState.history.get(':'))
+	require.Equal(t, []string{"b"}, GlobalState.history.get('/'))
+	typeEditorKeys(e, "qa:set sw?\rq:noh\r@a")
+	require.Equal(t, []string{"set ts?", "normal :set ts?<CR>/a<CR>", "set sw?", "noh"}, GlobalState.history.get(':'))
+}
+
+func TestCommandLineCompletion(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.go"), nil, 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main_test.go"), nil, 0o644))
+	e := newTestEditor(t, "a")
+
+	typeKeyNames(e, ":tabp<Tab>")
+	require.Equal(t, "tabprevious", GlobalState.GetCommand())
+	require.Nil(t, GlobalState.completion)
+
+	typeKeyNames(e, "<Esc>:open "+dir+"/ma<Tab>")
+	require.Equal(t, "open "+dir+"/main.go", GlobalState.GetCommand())
+	require.Equal(t, []string{dir + "/main.go", dir + "/main_test.go"}, GlobalState.completion.candidates)
+	typeKeyNames(e, "<Tab>")
+	require.Equal(t, "open "+dir+"/main_test.go", GlobalState.GetCommand())
+	typeKeyNames(e, "<Tab>")
+	require.Equal(t, "open "+dir+"/ma", GlobalState.GetCommand())
+	typeKeyNames(e, "<S-Tab>")
+	require.Equal(t, "open "+dir+"/main_test.go", GlobalState.GetCommand())
+	typeKeyNames(e, "x")
+	require.Nil(t, GlobalState.completion)
+	require.Equal(t, "open "+dir+"/main_test.gox", GlobalState.GetCommand())
+
+	typeKeyNames(e, "<Esc>:set hls<Tab>")
+	require.Equal(t, "set hlsearch", GlobalState.GetCommand())
+	typeKeyNames(e, "<Esc>:1s<Tab>")
+	require.Equal(t, "1substitute", GlobalState.GetCommand())
+	typeKeyNames(e, "<Tab>")
+	require.Equal(t, "1set", GlobalState.GetCommand())
+}
+
+func TestWildmenu(t *testing.T) {
+	screen := tcell.NewSimulationScreen("")
+	require.NoError(t, screen.Init())
+	defer screen.Fini()
+	screen.SetSize(20, 3)
+	s := NewState()
+	s.completion = &completion{candidates: []string{"alpha", "beta", filepath.Join("dir", "gamma") + string(filepath.Separator), "delta"}, selected: 2}
+	s.renderWildmenu(screen, layout.Point{}, 20)
+	screen.Show()
+	require.Equal(t, "< beta  gamma"+string(filepath.Separator)+"     >", screenText(screen, 0, 20))
+
+	screen.Clear()
+	s.completion.selected = 0
+	s.renderWildmenu(screen, layout.Point{}, 20)
+	screen.Show()
+	require.Equal(t, "alpha  beta        >", screenText(screen, 0, 20))
+}
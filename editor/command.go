@@ -0,0 +1,414 @@
+package editor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// The kinds of arguments of an ex command, they tell the command line what to complete
+const (
+	argNone = iota
+	// argText is any text, like the count of :d, its trailing blanks are left out
+	argText
+	// argRaw is text kept as typed with its trailing blanks, like the keys of :normal
+	argRaw
+	argFile
+	// argBuffer is the number or the name of a tab
+	argBuffer
+	argOption
+	argCommand
+)
+
+var argKindNames = []string{"none", "text", "raw", "file", "buffer", "option", "command"}
+
+// command is an ex command. It is found by its name, by one of its aliases, or by an
+// abbreviation of its name at least as long as abbrev, like :tabn for :tabnext.
+type command struct {
+	name    string
+	abbrev  string
+	aliases []string
+	args    int
+	// usage describes the arguments in the help
+	usage string
+	// ranged commands accept a range, bang ones a ! after their name
+	ranged bool
+	bang   bool
+	help   string
+	run    func(e *Editor, cmd exCommand) error
+}
+
+// commands is the registry of the ex commands, set in init because some commands list it
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{name: "delete", abbrev: "d", args: argText, usage: "[x] [count]", ranged: true, help: "delete the lines into register x", run: lineCommand(deleteLines('d'))},
+		{name: "yank", abbrev: "y", args: argText, usage: "[x] [count]", ranged: true, help: "yank the lines into register x", run: lineCommand(deleteLines('y'))},
+		{name: "move", abbrev: "m", args: argText, usage: "{address}", ranged: true, help: "move the lines below the address, 0 is above the first line", run: lineCommand(moveLines(false))},
+		{name: "copy", abbrev: "co", aliases: []string{"t"}, args: argText, usage: "{address}", ranged: true, help: "copy the lines below the address, 0 is above the first line", run: lineCommand(moveLines(true))},
+		{name: ">", abbrev: ">", args: argText, usage: "[count]", ranged: true, help: "shift the lines right, once for every >", run: lineCommand(shiftLines)},
+		{name: "<", abbrev: "<", args: argText, usage: "[count]", ranged: true, help: "shift the lines left, once for every <", run: lineCommand(shiftLines)},
+		{name: "substitute", abbrev: "s", args: argRaw, usage: "/{pattern}/{string}/[flags]", ranged: true, help: "replace the matches of a pattern, the flags are g, c, i and n", run: func(e *Editor, cmd exCommand) error {
+			return e.substitute(max(cmd.first, 0), cmd.last, cmd.args)
+		}},
+		{name: "global", abbrev: "g", args: argRaw, usage: "/{pattern}/[command]", ranged: true, bang: true, help: "run a command on every line matching the pattern, on the other lines with !", run: func(e *Editor, cmd exCommand) error {
+			return e.global(cmd, cmd.bang)
+		}},
+		{name: "vglobal", abbrev: "v", args: argRaw, usage: "/{pattern}/[command]", ranged: true, help: "run a command on every line not matching the pattern", run: func(e *Editor, cmd exCommand) error {
+			return e.global(cmd, true)
+		}},
+		{name: "normal", abbrev: "norm", args: argRaw, usage: "{keys}", ranged: true, bang: true, help: "type view mode keys on every line, special keys are written like <Esc> or <CR>", run: func(e *Editor, cmd exCommand) error {
+			return e.normal(cmd)
+		}},
+		{name: "write", abbrev: "w", bang: true, help: "write the file of the tab, ! writes a file that was not decoded exactly", run: func(e *Editor, cmd exCommand) error {
+			return e.getActiveTab().Write(cmd.bang)
+		}},
+		{name: "wq", abbrev: "wq", bang: true, help: "write the file of the tab and quit", run: writeQuit},
+		{name: "xit", abbrev: "x", bang: true, help: "write the file of the tab and quit", run: writeQuit},
+		{name: "quit", abbrev: "q", bang: true, help: "quit, ! drops the changes that were not written", run: func(e *Editor, cmd exCommand) error {
+			return e.quit(cmd.bang)
+		}},
+		{name: "edit", abbrev: "e", bang: true, args: argFile, usage: "[++enc={encoding}] [path]", help: "open a file, or read the file of the tab again, ! drops its changes", run: func(e *Editor, cmd exCommand) error {
+			return e.edit(cmd.args, cmd.bang)
+		}},
+		{name: "open", abbrev: "o", args: argFile, usage: "{path}", help: "open a file in a new tab", run: func(e *Editor, cmd exCommand) error {
+			if cmd.args == "" {
+				return &exError{err: errNoArgument, text: cmd.name}
+			}
+			tab, err := NewTabFromPath(cmd.args)
+			if err != nil {
+				return err
+			}
+			e.window.AddTab(tab)
+			return nil
+		}},
+		{name: "path", abbrev: "path", args: argFile, usage: "{path}", help: "set the path the tab is written to", run: func(e *Editor, cmd exCommand) error {
+			if cmd.args == "" {
+				return &exError{err: errNoArgument, text: cmd.name}
+			}
+			e.getActiveTab().SetPath(cmd.args)
+			return nil
+		}},
+		{name: "tabnew", abbrev: "tabnew", args: argFile, usage: "[path]", help: "open a new tab, with a file", run: func(e *Editor, cmd exCommand) error {
+			if cmd.args == "" {
+				e.window.AddTab(NewTab("new tab", nil))
+				return nil
+			}
+			tab, err := NewTabFromPath(cmd.args)
+			if err != nil {
+				return err
+			}
+			e.window.AddTab(tab)
+			return nil
+		}},
+		{name: "tabnext", abbrev: "tabn", help: "go to the next tab", run: func(e *Editor, _ exCommand) error {
+			e.window.NextTab()
+			return nil
+		}},
+		{name: "tabprevious", abbrev: "tabp", help: "go to the previous tab", run: func(e *Editor, _ exCommand) error {
+			e.window.PreviousTab()
+			return nil
+		}},
+		{name: "tabclose", abbrev: "tabc", bang: true, help: "close the tab, ! drops its changes that were not written", run: func(e *Editor, cmd exCommand) error {
+			return e.window.CloseTab(cmd.bang)
+		}},
+		{name: "buffer", abbrev: "b", args: argBuffer, usage: "{N|name}", help: "go to the tab with the number N or whose name contains name", run: func(e *Editor, cmd exCommand) error {
+			return e.gotoTab(cmd.args)
+		}},
+		{name: "registers", abbrev: "reg", help: "list the registers", run: func(e *Editor, _ exCommand) error {
+			e.showRegisters()
+			return nil
+		}},
+		{name: "nohlsearch", abbrev: "noh", help: "hide the highlighted matches until the next search", run: func(_ *Editor, _ exCommand) error {
+			lastSearch.hidden = true
+			return nil
+		}},
+		{name: "hex", abbrev: "hex", help: "toggle the hex view", run: func(e *Editor, _ exCommand) error {
+			return e.getActiveTab().ToggleHex()
+		}},
+		{name: "set", abbrev: "se", args: argOption, usage: "{option}...", help: "change or show options", run: func(e *Editor, cmd exCommand) error {
+			msg, err := setOptions(e.getActiveTab(), cmd.args)
+			if err != nil {
+				return err
+			}
+			if msg != "" {
+				GlobalState.InfoMessage(msg)
+			}
+			return nil
+		}},
+		{name: "earlier", abbrev: "ea", args: argText, usage: "[N|Ns|Nm|Nh|Nd]", help: "move N text states, or a duration, back in time", run: func(e *Editor, cmd exCommand) error {
+			return e.travelHistory(true, cmd.args)
+		}},
+		{name: "later", abbrev: "lat", args: argText, usage: "[N|Ns|Nm|Nh|Nd]", help: "move N text states, or a duration, forward in time", run: func(e *Editor, cmd exCommand) error {
+			return e.travelHistory(false, cmd.args)
+		}},
+		{name: "commands", abbrev: "comm", help: "list the commands", run: func(_ *Editor, _ exCommand) error {
+			GlobalState.ShowLines(commandList())
+			return nil
+		}},
+		{name: "help", abbrev: "h", args: argCommand, usage: "[command]", help: "show the help of a command", run: func(_ *Editor, cmd exCommand) error {
+			return showHelp(cmd.args)
+		}},
+	}
+}
+
+// errNotWritten is returned when a command would drop changes that were not written
+var errNotWritten = errors.New("no write since last change (add ! to override)")
+
+// quit executes :q[!], the editor only quits with ! when a tab has changes that were not
+// written
+func (s *Editor) quit(bang bool) error {
+	if !bang && slices.ContainsFunc(s.window.tabs, (*Tab).Modified) {
+		return errNotWritten
+	}
+	GlobalState.SetFinished()
+	return nil
+}
+
+// writeQuit executes :wq[!] and :x[!], it writes the active tab and quits like :q[!], so
+// the changes of the other tabs are only dropped with !
+func writeQuit(e *Editor, cmd exCommand) error {
+	if !cmd.bang && slices.ContainsFunc(e.window.tabs, func(tab *Tab) bool {
+		return tab != e.getActiveTab() && tab.Modified()
+	}) {
+		return errNotWritten
+	}
+	if err := e.getActiveTab().Write(cmd.bang); err != nil {
+		return err
+	}
+	return e.quit(cmd.bang)
+}
+
+// findCommand returns the command named name, nil when there is none. A run of > or <
+// is the command > or <.
+func findCommand(name string) *command {
+	if name != "" && (strings.Trim(name, ">") == "" || strings.Trim(name, "<") == "") {
+		name = name[:1]
+	}
+	for _, c := range commands {
+		if c.name == name || slices.Contains(c.aliases, name) {
+			return c
+		}
+	}
+	for _, c := range commands {
+		if len(name) >= len(c.abbrev) && strings.HasPrefix(c.name, name) && strings.HasPrefix(name, c.abbrev) {
+			return c
+		}
+	}
+	return nil
+}
+
+// check returns an error when cmd, parsed from line, has a range, a ! or arguments that
+// c does not accept
+func (c *command) check(cmd exCommand, line string) error {
+	switch {
+	case cmd.addresses > 0 && !c.ranged:
+		return &exError{err: errNoRange, text: line}
+	case cmd.bang && !c.bang:
+		return &exError{err: errNoBang, text: line}
+	case cmd.args != "" && c.args == argNone:
+		return &exError{err: errTrailing, text: cmd.args}
+	}
+	return nil
+}
+
+// syntax returns how the command is typed, like :[range]d[elete] [x] [count]
+func (c *command) syntax() string {
+	var b strings.Builder
+	b.WriteString(":")
+	if c.ranged {
+		b.WriteString("[range]")
+	}
+	b.WriteString(c.abbrev)
+	if len(c.name) > len(c.abbrev) {
+		b.WriteString("[" + c.name[len(c.abbrev):] + "]")
+	}
+	if c.bang {
+		b.WriteString("[!]")
+	}
+	if c.usage != "" {
+		b.WriteString(" " + c.usage)
+	}
+	return b.String()
+}
+
+// commandList returns the lines listed by :commands
+func commandList() []string {
+	lines := []string{"--- Commands ---", fmt.Sprintf("%-24s %-5s %-4s %-7s %s", "Name", "Range", "Bang", "Args", "Help")}
+	for _, c := range commands {
+		name := c.abbrev
+		if len(c.name) > len(c.abbrev) {
+			name += "[" + c.name[len(c.abbrev):] + "]"
+		}
+		if len(c.aliases) > 0 {
+			name += ", " + strings.Join(c.aliases, ", ")
+		}
+		lines = append(lines, fmt.Sprintf("%-24s %-5s %-4s %-7s %s", name, yesNo(c.ranged), yesNo(c.bang), argKindNames[c.args], c.help))
+	}
+	return lines
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// showHelp executes :help, the help of the command name or of :help itself
+func showHelp(name string) error {
+	if name == "" {
+		name = "help"
+	}
+	c := findCommand(strings.TrimPrefix(name, ":"))
+	if c == nil {
+		return fmt.Errorf("no help for %s", name)
+	}
+	lines := []string{c.syntax(), "    " + c.help}
+	if len(c.aliases) > 0 {
+		lines = append(lines, "    also :"+strings.Join(c.aliases, ", :"))
+	}
+	if c.name == "help" {
+		lines = append(lines, "    :commands lists the commands")
+	}
+	GlobalState.ShowLines(lines)
+	return nil
+}
+
+// gotoTab executes :b, arg is the number of a tab or a part of its name
+func (s *Editor) gotoTab(arg string) error {
+	if arg == "" {
+		return &exError{err: errNoArgument, text: "buffer"}
+	}
+	if n, err := strconv.Atoi(arg); err == nil {
+		if n < 1 || n > len(s.window.tabs) {
+			return &exError{err: errArgument, text: arg}
+		}
+		s.window.SetActiveTab(n - 1)
+		return nil
+	}
+	found := -1
+	for i, tab := range s.window.tabs {
+		if strings.Contains(tab.name, arg) {
+			if found >= 0 {
+				return fmt.Errorf("more than one match for %s", arg)
+			}
+			found = i
+		}
+	}
+	if found < 0 {
+		return fmt.Errorf("no matching buffer for %s", arg)
+	}
+	s.window.SetActiveTab(found)
+	return nil
+}
+
+// complete returns the completions of the word being typed at the end of an ex command
+// line and the offset where the word starts. The name of the command is completed first,
+// then its argument according to its kind.
+func (s *Editor) complete(line string) (int, []string) {
+	p := &exParser{tab: s.getActiveTab(), line: line}
+	if _, err := p.parseRange(); err != nil {
+		return 0, nil
+	}
+	p.skipBlanks()
+	start := p.pos
+	name := p.parseName()
+	if p.pos == len(line) {
+		return start, completeCommands(name)
+	}
+	c := findCommand(name)
+	if c == nil {
+		return 0, nil
+	}
+	if strings.HasPrefix(p.rest(), "!") && c.bang {
+		p.pos++
+	}
+	if !strings.HasPrefix(p.rest(), " ") {
+		return 0, nil
+	}
+	// the last word is completed, the whole argument for a path with blanks
+	args := strings.TrimLeft(p.rest(), " ")
+	start = len(line) - len(args)
+	if c.args != argFile && c.args != argBuffer {
+		if i := strings.LastIndex(args, " "); i >= 0 {
+			start += i + 1
+			args = args[i+1:]
+		}
+	}
+	switch c.args {
+	case argFile:
+		return start, completeFiles(args)
+	case argBuffer:
+		var names []string
+		for _, tab := range s.window.tabs {
+			if strings.HasPrefix(tab.name, args) {
+				names = append(names, tab.name)
+			}
+		}
+		return start, names
+	case argOption:
+		return start, completeOptions(args)
+	case argCommand:
+		return start, completeCommands(args)
+	}
+	return 0, nil
+}
+
+// completeCommands returns the names of the commands starting with prefix
+func completeCommands(prefix string) []string {
+	var names []string
+	for _, c := range commands {
+		if strings.HasPrefix(c.name, prefix) {
+			names = append(names, c.name)
+		}
+	}
+	return names
+}
+
+// completeOptions returns the names of the options starting with prefix, and the names
+// of the boolean options after no
+func completeOptions(prefix string) []string {
+	var names []string
+	for _, o := range options {
+		if strings.HasPrefix(o.name, prefix) {
+			names = append(names, o.name)
+		}
+		if o.kind == optionBool && strings.HasPrefix("no"+o.name, prefix) && len(prefix) >= 2 {
+			names = append(names, "no"+o.name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// completeFiles returns the paths starting with prefix, directories end with a slash.
+// Hidden files are only completed when prefix names one.
+func completeFiles(prefix string) []string {
+	dir, base := filepath.Split(prefix)
+	readDir := dir
+	if readDir == "" {
+		readDir = "."
+	}
+	entries, err := os.ReadDir(readDir)
+	if err != nil {
+		return nil
+	}
+	var paths []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, base) || (strings.HasPrefix(name, ".") && !strings.HasPrefix(base, ".")) {
+			continue
+		}
+		if entry.IsDir() {
+			name += string(filepath.Separator)
+		}
+		paths = append(paths, dir+name)
+	}
+	return paths
+}
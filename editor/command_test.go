@@ -0,0 +1,187 @@
+package editor
+
+import (
+	"github.com/gdamore/tcell/v2"
+	"github.com/test-go/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindCommand(t *testing.T) {
+	for name, want := range map[string]string{
+		"d":      "delete",
+		"del":    "delete",
+		"t":      "copy",
+		"co":     "copy",
+		">>>":    ">",
+		"s":      "substitute",
+		"se":     "set",
+		"tabn":   "tabnext",
+		"tabnex": "tabnext",
+		"wq":     "wq",
+		"x":      "xit",
+		"h":      "help",
+		"hex":    "hex",
+		"ea":     "earlier",
+	} {
+		c := findCommand(name)
+		require.NotNil(t, c, name)
+		require.Equal(t, want, c.name, name)
+	}
+	for _, name := range []string{"tab", "c", "deletes", "la", "<>"} {
+		require.Nil(t, findCommand(name), name)
+	}
+}
+
+func TestCommandChecks(t *testing.T) {
+	e := newTestEditor(t, "1\n2")
+	for line, msg := range map[string]string{
+		":2tabn\r":    "err: no range allowed: 2tabn",
+		":tabn!\r":    "err: no ! allowed: tabn!",
+		":noh x\r":    "err: trailing characters: x",
+		":tab\r":      "err: not an editor command: tab",
+		":b 3\r":      "err: invalid argument: 3",
+		":help qwe\r": "err: no help for qwe",
+	} {
+		typeEditorKeys(e, line)
+		require.Equal(t, msg, GlobalState.getInfoLine(), line)
+	}
+}
+
+func TestTabCommands(t *testing.T) {
+	e := newTestEditor(t, "1")
+	typeEditorKeys(e, ":tabnew\r")
+	require.Equal(t, 2, len(e.window.tabs))
+	require.Equal(t, 1, e.window.activeTab)
+	typeEditorKeys(e, ":tabp\r")
+	require.Equal(t, 0, e.window.activeTab)
+	typeEditorKeys(e, ":b new\r")
+	require.Equal(t, 1, e.window.activeTab)
+	typeEditorKeys(e, ":b1\r")
+	require.Equal(t, 0, e.window.activeTab)
+	typeEditorKeys(e, ":tabnext\r:tabc\r")
+	require.Equal(t, 1, len(e.window.tabs))
+}
+
+func TestHelp(t *testing.T) {
+	e := newTestEditor(t, "1")
+	typeEditorKeys(e, ":h d\r")
+	require.Equal(t, []string{":[range]d[elete] [x] [count]", "    delete the lines into register x"}, GlobalState.messageLines)
+	typeEditorKeys(e, "\x1b:help :t\r")
+	require.Equal(t, []string{
+		":[range]co[py] {address}",
+		"    copy the lines below the address, 0 is above the first line",
+		"    also :t",
+	}, GlobalState.messageLines)
+
+	typeEditorKeys(e, "\x1b:commands\r")
+	lines := GlobalState.messageLines
+	require.Equal(t, len(commands)+2, len(lines))
+	require.Equal(t, "--- Commands ---", lines[0])
+	require.Contains(t, lines, "tabn[ext]                no    no   none    go to the next tab")
+}
+
+func TestComplete(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.go"), nil, 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main_test.go"), nil, 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".hidden"), nil, 0o644))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "mod"), 0o755))
+
+	e := newTestEditor(t, "1")
+	tests := []struct {
+		line  string
+		start int
+		want  []string
+	}{
+		{"tab", 0, []string{"tabnew", "tabnext", "tabprevious", "tabclose"}},
+		{"%s", 1, []string{"substitute", "set"}},
+		{"open " + dir + "/ma", 5, []string{dir + "/main.go", dir + "/main_test.go"}},
+		{"e " + dir + "/m", 2, []string{dir + "/main.go", dir + "/main_test.go", dir + "/mod/"}},
+		{"e " + dir + "/.", 2, []string{dir + "/.hidden"}},
+		{"set ts=2 hls", 9, []string{"hlsearch"}},
+		{"set noi", 4, []string{"noignorecase"}},
+		{"h tabp", 2, []string{"tabprevious"}},
+		{"d x", 0, nil},
+		{"qwe ", 0, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.line, func(t *testing.T) {
+			start, candidates := e.complete(test.line)
+			require.Equal(t, test.start, start)
+			require.Equal(t, test.want, candidates)
+		})
+	}
+}
+
+func TestEditModified(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.txt")
+	require.NoError(t, os.WriteFile(path, []byte("abc\n"), 0o644))
+	e := newTestEditor(t, "")
+	tab, err := NewTabFromPath(path)
+	require.NoError(t, err)
+	e.window.ReplaceActiveTab(tab)
+
+	typeEditorKeys(e, ":s/a/x/\r:e\r")
+	require.Equal(t, "err: no write since last change (add ! to override)", GlobalState.getInfoLine())
+	require.True(t, tab == e.getActiveTab())
+	require.Equal(t, "xbc", tabText(tab))
+	require.True(t, tab.Modified())
+
+	// undoing back to the text of the file leaves nothing to lose
+	typeEditorKeys(e, "u")
+	require.False(t, tab.Modified())
+	typeEditorKeys(e, ":s/a/x/\r:w\r")
+	require.False(t, tab.Modified())
+	typeEditorKeys(e, ":s/b/y/\r:e!\r")
+	require.True(t, tab != e.getActiveTab())
+	require.Equal(t, "xbc", tabText(e.getActiveTab()))
+	require.False(t, e.getActiveTab().Modified())
+}
+
+func TestQuitModified(t *testing.T) {
+	e := newTestEditor(t, "abc")
+	typeEditorKeys(e, "x:q\r")
+	require.Equal(t, "err: no write since last change (add ! to override)", GlobalState.getInfoLine())
+	require.False(t, GlobalState.IsFinished())
+
+	// the changes of every tab are checked
+	typeEditorKeys(e, ":tabnew\r:q\r")
+	require.Equal(t, "err: no write since last change (add ! to override)", GlobalState.getInfoLine())
+	require.False(t, GlobalState.IsFinished())
+	typeEditorKeys(e, ":q!\r")
+	require.True(t, GlobalState.IsFinished())
+}
+
+func TestWriteQuitModified(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.txt")
+	require.NoError(t, os.WriteFile(path, []byte("abc\n"), 0o644))
+	e := newTestEditor(t, "abc")
+	typeEditorKeys(e, "x:tabnew "+path+"\rx:wq\r")
+	require.Equal(t, "err: no write since last change (add ! to override)", GlobalState.getInfoLine())
+	require.False(t, GlobalState.IsFinished())
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "abc\n", string(data))
+
+	typeEditorKeys(e, ":x!\r")
+	require.True(t, GlobalState.IsFinished())
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "bc\n", string(data))
+}
+
+func TestCloseTabModified(t *testing.T) {
+	e := newTestEditor(t, "abc")
+	typeEditorKeys(e, ":tabnew\r:tabp\rx:tabc\r")
+	require.Equal(t, "err: no write since last change (add ! to override)", GlobalState.getInfoLine())
+	require.Len(t, e.window.tabs, 2)
+	require.Equal(t, "bc", tabText(e.getActiveTab()))
+
+	e.handleTypedKey(tcell.NewEventKey(tcell.KeyCtrlW, 0, tcell.ModNone))
+	require.Len(t, e.window.tabs, 2)
+	typeEditorKeys(e, ":tabc!\r")
+	require.Len(t, e.window.tabs, 1)
+	require.Equal(t, "", tabText(e.getActiveTab()))
+}
@@ -0,0 +1,194 @@
+package editor
+
+import (
+	"github.com/dangdungcntt/ndditor/editor/layout"
+	"github.com/gdamore/tcell/v2"
+	"iter"
+	"slices"
+)
+
+// controlStyle is the style of control characters, which are displayed as ^X
+var controlStyle = tcell.StyleDefault.Foreground(tcell.ColorBlue)
+
+// cluster is a user-perceived character of a line: a grapheme cluster, or a single
+// invalid byte or control character, both of which are displayed with several cells.
+// The logical column of a cluster is the rune index of its first rune in the line,
+// its screen column is the sum of the widths of the clusters before it.
+type cluster struct {
+	col   int
+	runes []rune
+	width int
+}
+
+// end returns the logical column right after the cluster
+func (c cluster) end() int {
+	return c.col + len(c.runes)
+}
+
+// draw draws the cluster at (x, y), the cells of a tab stop before maxX. base is combined
+// with the special styles of invalid bytes and control characters.
+func (c cluster) draw(screen tcell.Screen, x, y, maxX int, base tcell.Style) {
+	r := c.runes[0]
+	if r == '\t' {
+		for i := x; i < min(x+c.width, maxX); i++ {
+			screen.SetContent(i, y, ' ', nil, base)
+		}
+		return
+	}
+	if b, ok := invalidByte(r); ok {
+		drawCells(screen, x, y, invalidByteText(b), mergeStyle(invalidByteStyle, base))
+		return
+	}
+	if isControl(r) {
+		drawCells(screen, x, y, controlText(r), mergeStyle(controlStyle, base))
+		return
+	}
+	screen.SetContent(x, y, r, c.runes[1:], base)
+}
+
+func drawCells(screen tcell.Screen, x, y int, text string, style tcell.Style) {
+	for i, r := range []rune(text) {
+		screen.SetContent(x+i, y, r, nil, style)
+	}
+}
+
+// mergeStyle returns special with the attributes of base applied on top of it
+func mergeStyle(special, base tcell.Style) tcell.Style {
+	_, _, attrs := base.Decompose()
+	return special.Attributes(attrs)
+}
+
+// isControl returns true for the control characters displayed as ^X, tabs are expanded instead
+func isControl(r rune) bool {
+	return (r < 0x20 && r != '\t') || r == 0x7f
+}
+
+// isPrintableASCII returns true for the ASCII characters that are not control characters
+func isPrintableASCII(r rune) bool {
+	return r >= 0x20 && r < 0x7f
+}
+
+// tabWidth returns the number of cells of a tab drawn at screen column x
+func tabWidth(x int) int {
+	tabStop := max(globalOptions.tabStop, 1)
+	return tabStop - x%tabStop
+}
+
+// controlText returns how a control character is displayed, e.g. ^M
+func controlText(r rune) string {
+	return string([]rune{'^', r ^ 0x40})
+}
+
+// maxClusterBatch is the number of runes segmented at once by streamClusters
+const maxClusterBatch = 256
+
+// streamClusters lazily splits runes into clusters, so that only the beginning of
+// a very long line has to be decoded when only the beginning is needed.
+// Tabs are expanded up to the next multiple of the tabstop option.
+func streamClusters(runes iter.Seq[rune]) iter.Seq[cluster] {
+	return streamClustersAt(runes, 0, 0)
+}
+
+// streamClustersAt splits runes that start at the logical column col and the screen
+// column screenX of their line, runes must start at the beginning of a cluster
+func streamClustersAt(runes iter.Seq[rune], col, screenX int) iter.Seq[cluster] {
+	return func(yield func(cluster) bool) {
+		col, screenX := col, screenX
+		var batch []rune
+		// flush yields the clusters of batch. Unless final, the last cluster is kept
+		// in batch because the next runes may still belong to it.
+		flush := func(final bool) bool {
+			var clusters [][]rune
+			if len(batch) == 1 {
+				// plain text is flushed one character at a time, without segmenting it
+				clusters = [][]rune{{batch[0]}}
+			} else {
+				clusters = slices.Collect(layout.Graphemes(string(batch)))
+			}
+			batch = batch[:0]
+			if n := len(clusters); !final && n > 0 && len(clusters[n-1]) < maxClusterBatch {
+				batch = append(batch, clusters[n-1]...)
+				clusters = clusters[:n-1]
+			}
+			for _, g := range clusters {
+				c := cluster{col: col, runes: g, width: layout.ClusterWidth(g)}
+				if !yield(c) {
+					return false
+				}
+				col += len(g)
+				screenX += c.width
+			}
+			return true
+		}
+		for r := range runes {
+			var width int
+			if _, ok := invalidByte(r); ok {
+				width = len(invalidByteText(0))
+			} else if isControl(r) {
+				width = 2
+			} else if r != '\t' {
+				// a cluster always ends between two printable ASCII characters
+				if isPrintableASCII(r) && len(batch) > 0 && isPrintableASCII(batch[len(batch)-1]) && !flush(true) {
+					return
+				}
+				batch = append(batch, r)
+				if len(batch) >= maxClusterBatch && !flush(false) {
+					return
+				}
+				continue
+			}
+			if !flush(true) {
+				return
+			}
+			if r == '\t' {
+				// the batch is flushed, so screenX is now the column of the tab
+				width = tabWidth(screenX)
+			}
+			if !yield(cluster{col: col, runes: []rune{r}, width: width}) {
+				return
+			}
+			col++
+			screenX += width
+		}
+		flush(true)
+	}
+}
+
+// lineClusters splits runes into clusters
+func lineClusters(runes []rune) []cluster {
+	return slices.Collect(streamClusters(slices.Values(runes)))
+}
+
+// clusterIndex returns the index of the cluster containing the logical column col,
+// or len(clusters) if col is at the end of the line
+func clusterIndex(clusters []cluster, col int) int {
+	for i, c := range clusters {
+		if col < c.end() {
+			return i
+		}
+	}
+	return len(clusters)
+}
+
+// clusterCol returns the logical column of the cluster at index i
+func clusterCol(clusters []cluster, i int) int {
+	if i >= len(clusters) {
+		if len(clusters) == 0 {
+			return 0
+		}
+		return clusters[len(clusters)-1].end()
+	}
+	return clusters[max(i, 0)].col
+}
+
+// screenColumn converts a logical column into a screen column
+func screenColumn(clusters []cluster, col int) int {
+	screenX := 0
+	for _, c := range clusters {
+		if c.col >= col {
+			break
+		}
+		screenX += c.width
+	}
+	return screenX
+}
@@ -0,0 +1,73 @@
+package editor
+
+import (
+	"github.com/test-go/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestLineClusters(t *testing.T) {
+	line := "a世e\u0301\xff\rb"
+	runes := decodeRunes([]byte(line))
+	clusters := lineClusters(runes)
+	require.Len(t, clusters, 6)
+	require.Equal(t, []int{0, 1, 2, 4, 5, 6}, []int{
+		clusters[0].col, clusters[1].col, clusters[2].col, clusters[3].col, clusters[4].col, clusters[5].col,
+	})
+	require.Equal(t, []int{1, 2, 1, 4, 2, 1}, []int{
+		clusters[0].width, clusters[1].width, clusters[2].width, clusters[3].width, clusters[4].width, clusters[5].width,
+	})
+	require.Equal(t, 3, screenColumn(clusters, 2))
+	require.Equal(t, 2, clusterIndex(clusters, 3))
+
+	tab := NewTab("", NewPieceTable([]byte("first\n"+line)))
+	require.Equal(t, 4, tab.screenCol(1, 4))
+	require.Equal(t, 11, tab.screenCol(1, len(runes)))
+	require.Equal(t, 1, tab.colAtScreen(1, 2))
+	require.Equal(t, 4, tab.colAtScreen(1, 6))
+	require.Equal(t, len(runes), tab.colAtScreen(1, 100))
+	require.Equal(t, 4, tab.moveCol(1, 2, 1))
+	require.Equal(t, 2, tab.moveCol(1, 3, 0))
+	require.Equal(t, 1, tab.moveCol(1, 4, -2))
+	require.Equal(t, 0, tab.moveCol(1, 4, -10))
+	require.Equal(t, len(runes), tab.moveCol(1, 0, 10))
+	require.Equal(t, len(runes), tab.lineLen(1))
+}
+
+func TestStreamClustersLongLine(t *testing.T) {
+	// combining marks around every batch boundary must stay in their cluster
+	line := strings.Repeat("e\u0301", 1000)
+	tab := NewTab("", NewPieceTable([]byte(line)))
+	count := 0
+	for c := range tab.lineClusters(0) {
+		require.Equal(t, 2*count, c.col)
+		require.Len(t, c.runes, 2)
+		count++
+	}
+	require.Equal(t, 1000, count)
+	require.Equal(t, 1000, tab.screenCol(0, 2000))
+	require.Equal(t, 2*500, tab.colAtScreen(0, 500))
+}
+
+func TestLongLineEnd(t *testing.T) {
+	for _, line := range []string{
+		strings.Repeat("a€\xff", 3000) + "xe\u0301",
+		// without two ASCII characters near the end the line is walked from its start
+		strings.Repeat("€", 3000) + "e\u0301",
+	} {
+		tab := NewTab("", NewPieceTable([]byte("first\n"+line)))
+		tab.doc.Insert(tab.doc.LineStart(1)+3, []byte("é"))
+		runes := 0
+		for range tab.lineRunes(1) {
+			runes++
+		}
+		require.Equal(t, runes, tab.lineLen(1))
+		require.Equal(t, runes-2, tab.lastCol(1))
+		require.Equal(t, runes-2, tab.moveCol(1, runes-1, 0))
+		require.Equal(t, runes, tab.moveCol(1, runes-2, 1))
+		require.Equal(t, runes-2, tab.moveCol(1, runes, -1))
+	}
+	tab := NewTab("", NewPieceTable([]byte("\nab")))
+	require.Equal(t, 0, tab.lastCol(0))
+	require.Equal(t, 1, tab.lastCol(1))
+}
@@ -0,0 +1,44 @@
+package editor
+
+import (
+	"io"
+	"iter"
+)
+
+// Document is the text storage behind a Tab.
+// All offsets are byte offsets into the document, and lines are separated by '\n'.
+// A document always has at least one line, an empty document has a single empty line.
+type Document interface {
+	io.WriterTo
+
+	// Len returns the size of the document in bytes
+	Len() int
+	// LineCount returns the number of lines in the document
+	LineCount() int
+	// LineStart returns the offset of the first byte of line n
+	LineStart(n int) int
+	// LineEnd returns the offset of the newline that terminates line n,
+	// or Len() for the last line
+	LineEnd(n int) int
+	// LineAt returns the index of the line containing offset
+	LineAt(offset int) int
+	// Line returns a copy of line n without its trailing newline
+	Line(n int) []byte
+	// Slice returns a copy of length bytes starting at offset
+	Slice(offset, length int) []byte
+	// Chunks iterates over the bytes in [from, to) without copying them.
+	// The yielded slices must not be modified or retained.
+	Chunks(from, to int) iter.Seq[[]byte]
+	// Lines iterates over the lines in [from, to), yielding the line index and its content
+	Lines(from, to int) iter.Seq2[int, []byte]
+	// Insert inserts text at offset
+	Insert(offset int, text []byte)
+	// Delete removes length bytes starting at offset
+	Delete(offset, length int)
+}
+
+// Position is a location in a Document, given as a line index and a logical column (a rune index)
+type Position struct {
+	Line int
+	Col  int
+}
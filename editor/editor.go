@@ -2,13 +2,15 @@
 package editor
 
 import (
+	"errors"
 	"fmt"
 	"github.com/dangdungcntt/ndditor/editor/layout"
 	"github.com/dangdungcntt/ndditor/editor/logger"
 	"github.com/gdamore/tcell/v2"
 	"log"
-	"regexp"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // GlobalState is the global state of the editor
@@ -21,22 +23,53 @@ type Editor struct {
 	root           layout.Element
 	window         *Window
 	focusedElement CursorEventListener
+	macros         *macros
+	// substitution is the :s waiting for the answers to its confirm prompt, nil when none
+	substitution *substitution
+	// inGlobal is true while :g runs its command, which cannot be :g again
+	inGlobal bool
+	// normalDepth is the nesting of the running :normal commands
+	normalDepth int
 }
 
 // NewEditor creates a new editor
 func NewEditor(screen tcell.Screen) *Editor {
 	GlobalState = NewState()
+	systemClipboard = screen
+	m, err := loadMacros()
+	if err != nil {
+		GlobalState.ToastMessage(fmt.Sprintf("err: %v", err))
+	}
+	h, err := loadHistory()
+	if err != nil {
+		GlobalState.ToastMessage(fmt.Sprintf("err: %v", err))
+	}
+	GlobalState.history = h
 	return &Editor{
 		screen: screen,
 		events: make(chan tcell.Event),
+		macros: m,
 	}
 }
 
 // Run starts the editor
 func (s *Editor) Run(args []string) {
-	s.initEventListeners()
+	s.init(args)
 	go s.eventLoop()
+	s.eventConsumer()
+	// only the macros recorded with q are saved for the next session, not the yanked or
+	// deleted text
+	if err := s.macros.save(); err != nil {
+		logger.WriteLog("err:", err)
+	}
+}
 
+func (s *Editor) init(args []string) {
+	s.initEventListeners()
+	// the + and * registers are read from the terminal when the editor starts and
+	// every time it gets the focus back, as the clipboard may have changed meanwhile
+	s.screen.EnableFocus()
+	s.screen.GetClipboard()
 	s.window = s.initWindow(args)
 	s.focusedElement = s.window
 	s.root = &layout.Column{
@@ -45,15 +78,13 @@ func (s *Editor) Run(args []string) {
 			GlobalState,
 		},
 	}
-
-	s.eventConsumer()
 }
 
 func (s *Editor) initWindow(args []string) *Window {
 	window := NewWindow()
 
 	if len(args) == 0 {
-		tab := NewTab("new tab", NewEmptyLine(64))
+		tab := NewTab("new tab", nil)
 		window.AddTab(tab)
 		return window
 	}
@@ -88,31 +119,18 @@ func (s *Editor) eventConsumer() {
 		case *tcell.EventResize:
 			s.screen.Sync()
 			s.render()
+		case *tcell.EventFocus:
+			if ev.Focused {
+				s.screen.GetClipboard()
+			}
+		case *tcell.EventClipboard:
+			setClipboardRegisters(ev.Data())
 		case *tcell.EventKey:
 			logger.WriteLog(ev.Modifiers(), ev.Name(), ev.Key(), ev.Rune())
-			switch ev.Key() {
-			case tcell.KeyCtrlC:
+			if ev.Key() == tcell.KeyCtrlC {
 				return
-			case tcell.KeyLeft:
-				s.moveCursor(-1, 0)
-			case tcell.KeyRight:
-				s.moveCursor(1, 0)
-			case tcell.KeyUp:
-				s.moveCursor(0, -1)
-			case tcell.KeyDown:
-				s.moveCursor(0, 1)
-			default:
-				if GlobalState.IsMode(ModeView) {
-					EmitEvent(KeyEvent{
-						Ev: ev,
-					})
-				} else {
-					EmitEvent(KeyEvent{
-						Target: s.focusedElement,
-						Ev:     ev,
-					})
-				}
 			}
+			s.handleTypedKey(ev)
 			s.render()
 		}
 		if GlobalState.IsFinished() {
@@ -122,10 +140,54 @@ func (s *Editor) eventConsumer() {
 	}
 }
 
+// handleTypedKey handles a key typed by the user, and records it into the register being
+// recorded. The q that stops the recording is not recorded, and a replayed macro is
+// recorded as its @ command, not as the keys it replays.
+func (s *Editor) handleTypedKey(ev *tcell.EventKey) {
+	recording := s.macros.recording
+	s.handleKey(ev)
+	if recording != 0 && s.macros.recording == recording {
+		s.macros.record(ev)
+	}
+}
+
+// handleKey dispatches a typed or replayed key
+func (s *Editor) handleKey(ev *tcell.EventKey) {
+	switch ev.Key() {
+	case tcell.KeyLeft:
+		s.moveCursor(-1, 0)
+	case tcell.KeyRight:
+		s.moveCursor(1, 0)
+	case tcell.KeyUp, tcell.KeyDown:
+		// ctrl-up and ctrl-down add a cursor above or below in view mode
+		if ev.Modifiers()&tcell.ModCtrl != 0 && GlobalState.IsMode(ModeView) {
+			EmitEvent(KeyEvent{Ev: ev})
+			return
+		}
+		if ev.Key() == tcell.KeyUp {
+			s.moveCursor(0, -1)
+		} else {
+			s.moveCursor(0, 1)
+		}
+	default:
+		if GlobalState.IsNormalMode() {
+			EmitEvent(KeyEvent{
+				Ev: ev,
+			})
+		} else {
+			EmitEvent(KeyEvent{
+				Target: s.focusedElement,
+				Ev:     ev,
+			})
+		}
+	}
+}
+
 func (s *Editor) render() {
 	// TODO: can I only redraw the changed lines?
 	s.screen.Clear()
 	s.screen.HideCursor()
+	GlobalState.SetTabInfo(s.getActiveTab().StatusInfo())
 	screenW, screenH := s.screen.Size()
 	s.root.SetRenderSize(layout.Size{
 		Width:  screenW,
@@ -143,35 +205,102 @@ func (s *Editor) moveCursor(dx, dy int) {
 	s.focusedElement.MoveCursor(dx, dy)
 }
 
-var cmdRegex = regexp.MustCompile("[wq]")
+// executeCommand executes an ex command line, the errors are shown in the status line
+func (s *Editor) executeCommand(line string) {
+	if err := s.runCommand(line); err != nil {
+		GlobalState.ToastMessage(fmt.Sprintf("err: %v", err))
+	}
+}
 
-func (s *Editor) executeCommand(cmd string) {
-	switch {
-	case strings.HasPrefix(cmd, "path"):
-		s.getActiveTab().SetPath(cmd[5:])
-	case strings.HasPrefix(cmd, "open"):
-		filePath := cmd[5:]
-		tab, err := NewTabFromPath(filePath)
-		if err != nil {
-			GlobalState.ToastMessage(fmt.Sprintf("err: %v", err))
-			return
+func (s *Editor) runCommand(line string) error {
+	tab := s.getActiveTab()
+	cmd, err := parseExCommand(tab, line)
+	if err != nil {
+		return err
+	}
+	if cmd.name == "" {
+		return lineCommand(jumpToLine)(s, cmd)
+	}
+	c := findCommand(cmd.name)
+	if c == nil {
+		return &exError{err: errUnknownCommand, text: cmd.name}
+	}
+	if c.args != argRaw {
+		cmd.args = strings.TrimRight(cmd.args, " \t")
+	}
+	if err := c.check(cmd, line); err != nil {
+		return err
+	}
+	return c.run(s, cmd)
+}
+
+// edit executes :e[!] [++enc={encoding}] [path]. Without a path the file of the active tab
+// is read again, which is how a file is reopened with another encoding. A modified tab is
+// only read again with !.
+func (s *Editor) edit(args string, bang bool) error {
+	var encoding []string
+	var paths []string
+	for _, arg := range strings.Fields(args) {
+		if value, ok := strings.CutPrefix(arg, "++enc="); ok {
+			encoding = append(encoding, value)
+			continue
 		}
+		paths = append(paths, arg)
+	}
+
+	filePath := strings.Join(paths, " ")
+	reload := filePath == "" || filePath == s.getActiveTab().GetPath()
+	if filePath == "" {
+		filePath = s.getActiveTab().GetPath()
+	}
+	if filePath == "" {
+		return errors.New("no file name")
+	}
+	// reading the file again drops the changes and their undo history
+	if reload && !bang && s.getActiveTab().Modified() {
+		return errNotWritten
+	}
+
+	tab, err := NewTabFromPath(filePath, encoding...)
+	if err != nil {
+		return err
+	}
+	if reload {
+		s.window.ReplaceActiveTab(tab)
+	} else {
 		s.window.AddTab(tab)
-	case cmdRegex.MatchString(cmd):
-		if strings.Contains(cmd, "w") {
-			err := s.getActiveTab().Save()
-			if err != nil {
-				GlobalState.ToastMessage(fmt.Sprintf("err: %v", err))
-				return
-			}
-		}
+	}
+	return nil
+}
+
+// travelHistory executes :earlier and :later. The argument is either a count of
+// changes or a duration such as 10s, 5m, 1h or 2d.
+func (s *Editor) travelHistory(earlier bool, arg string) error {
+	tab := s.getActiveTab()
 
-		if strings.Contains(cmd, "q") {
-			GlobalState.SetFinished()
+	if arg == "" {
+		arg = "1"
+	}
+	units := map[byte]time.Duration{'s': time.Second, 'm': time.Minute, 'h': time.Hour, 'd': 24 * time.Hour}
+	if unit, ok := units[arg[len(arg)-1]]; ok {
+		n, err := strconv.Atoi(arg[:len(arg)-1])
+		if err != nil {
+			return fmt.Errorf("invalid argument: %s", arg)
 		}
-	default:
-		GlobalState.ToastMessage(fmt.Sprintf("unknown command: %s", cmd))
+		if earlier {
+			return tab.EarlierTime(time.Duration(n) * unit)
+		}
+		return tab.LaterTime(time.Duration(n) * unit)
 	}
+
+	n, err := strconv.Atoi(arg)
+	if err != nil {
+		return fmt.Errorf("invalid argument: %s", arg)
+	}
+	if earlier {
+		return tab.Earlier(n)
+	}
+	return tab.Later(n)
 }
 
 func (s *Editor) getActiveTab() *Tab {
@@ -193,7 +322,16 @@ func (s *Editor) initEventListeners() {
 	OnEvent(func(_ StateChangedEvent) {
 		s.render()
 	})
+	OnEvent(func(e MacroEvent) {
+		s.executeMacro(e.Keys, e.Count)
+	})
 	OnEvent(func(e SubmittedCommandEvent) {
 		s.executeCommand(e.Command)
 	})
+	OnEvent(func(e ConfirmEvent) {
+		s.answerSubstitution(e.Answer)
+	})
+	OnEvent(func(e CompletionEvent) {
+		GlobalState.SetCompletion(s.complete(e.Line))
+	})
 }
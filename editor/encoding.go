@@ -0,0 +1,168 @@
+package editor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// EncodingUTF8 is the encoding of the documents, files in any other encoding are
+	// transcoded to it on load and back on save
+	EncodingUTF8 = "utf-8"
+	// EncodingBinary marks binary files, their bytes are kept exactly as they are
+	EncodingBinary = "binary"
+)
+
+// encodingAliases maps the vim names of encodings to their WHATWG names
+var encodingAliases = map[string]string{
+	"utf8":    "utf-8",
+	"utf16le": "utf-16le",
+	"utf16be": "utf-16be",
+	"utf-16":  "utf-16be",
+	"ucs-2le": "utf-16le",
+	"ucs-2":   "utf-16be",
+	"latin1":  "iso-8859-1",
+	"cp1252":  "windows-1252",
+	"sjis":    "shift_jis",
+	"cp932":   "shift_jis",
+	"euc-cn":  "gbk",
+	"cp936":   "gbk",
+	"cp949":   "euc-kr",
+	"cp950":   "big5",
+}
+
+// byteOrderMarks holds the byte order mark written for the encodings that have one
+var byteOrderMarks = map[string][]byte{
+	"utf-8":    utf8BOM,
+	"utf-16le": {0xFF, 0xFE},
+	"utf-16be": {0xFE, 0xFF},
+}
+
+// lookupEncoding returns the canonical name and the codec of an encoding.
+// The codec of utf-8 and binary is nil, the bytes are kept as they are so that invalid bytes survive.
+func lookupEncoding(name string) (string, encoding.Encoding, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if alias, ok := encodingAliases[name]; ok {
+		name = alias
+	}
+	if name == EncodingUTF8 || name == EncodingBinary {
+		return name, nil, nil
+	}
+	enc, err := htmlindex.Get(name)
+	if err != nil {
+		return "", nil, fmt.Errorf("unknown encoding: %s", name)
+	}
+	canonical, err := htmlindex.Name(enc)
+	if err != nil {
+		return "", nil, err
+	}
+	return canonical, enc, nil
+}
+
+// detectEncoding detects the encoding of content from its byte order mark, from the
+// layout of zero bytes for UTF-16 text, from other zero bytes for binary data, and from
+// UTF-8 validity. Content that is none of them is read with fallback. It returns content
+// without its byte order mark.
+func detectEncoding(content []byte, fallback string) (name string, bom bool, rest []byte) {
+	for name, mark := range byteOrderMarks {
+		if bytes.HasPrefix(content, mark) {
+			return name, true, content[len(mark):]
+		}
+	}
+	if name, ok := detectUTF16(content); ok {
+		return name, false, content
+	}
+	if isBinary(content) {
+		return EncodingBinary, false, content
+	}
+	if utf8.Valid(content) {
+		return EncodingUTF8, false, content
+	}
+	return fallback, false, content
+}
+
+// detectUTF16 guesses UTF-16 without byte order mark: text that is mostly ASCII or Latin
+// has a zero byte in every other position. Binary data such as tables of small integers
+// has the same layout, so the sample must also decode to text.
+func detectUTF16(content []byte) (string, bool) {
+	sample := content[:min(len(content), 4096)]
+	pairs := len(sample) / 2
+	if pairs < 2 {
+		return "", false
+	}
+	zeroEven, zeroOdd := 0, 0
+	for i := 0; i+1 < len(sample); i += 2 {
+		if sample[i] == 0 && sample[i+1] != 0 {
+			zeroEven++
+		}
+		if sample[i] != 0 && sample[i+1] == 0 {
+			zeroOdd++
+		}
+	}
+	truncated := len(sample) < len(content)
+	switch {
+	case zeroOdd*3 > pairs && zeroEven*20 < pairs && isUTF16Text(sample, binary.LittleEndian, truncated):
+		return "utf-16le", true
+	case zeroEven*3 > pairs && zeroOdd*20 < pairs && isUTF16Text(sample, binary.BigEndian, truncated):
+		return "utf-16be", true
+	}
+	return "", false
+}
+
+// isUTF16Text returns true when sample is UTF-16 text without unpaired surrogates and
+// without control characters other than white space. A surrogate pair cut at the end of a
+// truncated sample is accepted.
+func isUTF16Text(sample []byte, order binary.ByteOrder, truncated bool) bool {
+	for i := 0; i+1 < len(sample); i += 2 {
+		u := rune(order.Uint16(sample[i:]))
+		switch {
+		case u >= 0xD800 && u < 0xDC00:
+			if i+3 >= len(sample) {
+				return truncated
+			}
+			if next := rune(order.Uint16(sample[i+2:])); next < 0xDC00 || next >= 0xE000 {
+				return false
+			}
+			i += 2
+		case u >= 0xDC00 && u < 0xE000:
+			return false
+		case u < 0x20 && u != '\t' && u != '\n' && u != '\r' && u != '\f':
+			return false
+		case u >= 0x7F && u < 0xA0:
+			return false
+		}
+	}
+	return true
+}
+
+// decodeContent transcodes content from the encoding name to UTF-8. It reports the decode
+// as lossy when the text does not encode back to the same bytes, for instance when invalid
+// sequences were replaced.
+func decodeContent(content []byte, name string) (text []byte, lossy bool, err error) {
+	_, enc, err := lookupEncoding(name)
+	if err != nil || enc == nil {
+		return content, false, err
+	}
+	text, err = enc.NewDecoder().Bytes(content)
+	if err != nil {
+		return nil, false, err
+	}
+	back, err := enc.NewEncoder().Bytes(text)
+	return text, err != nil || !bytes.Equal(back, content), nil
+}
+
+// decodeText decodes the content of a file without its byte order mark to the text of
+// a document, and returns its file format and whether the decode was lossy
+func decodeText(content []byte, name string) (FileFormat, []byte, bool, error) {
+	content, lossy, err := decodeContent(content, name)
+	if err != nil {
+		return FileFormat{}, nil, false, err
+	}
+	format, content := detectFileFormat(content)
+	return format, content, lossy, nil
+}
@@ -0,0 +1,93 @@
+package editor
+
+import (
+	"bytes"
+	"github.com/test-go/testify/require"
+	"golang.org/x/text/encoding/unicode"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncodingRoundTrip(t *testing.T) {
+	cases := []struct {
+		content  []byte
+		encoding []string
+		name     string
+		text     string
+	}{
+		{[]byte("\xff\xfeh\x00i\x00\r\x00\n\x00"), nil, "utf-16le", "hi"},
+		{[]byte("\x00h\x00e\x00l\x00l\x00o\x00\n"), nil, "utf-16be", "hello"},
+		{[]byte("caf\xe9\n"), []string{"cp1252"}, "windows-1252", "café"},
+		{[]byte("\x93\xfa\x96\x7b\n"), []string{"sjis"}, "shift_jis", "日本"},
+		{[]byte("\xef\xbb\xbfok\n"), nil, "utf-8", "ok"},
+		{[]byte("bad \xff\n"), nil, "utf-8", "bad \xff"},
+	}
+	dir := t.TempDir()
+	for _, c := range cases {
+		filePath := filepath.Join(dir, c.name)
+		require.NoError(t, os.WriteFile(filePath, c.content, 0644))
+		tab, err := NewTabFromPath(filePath, c.encoding...)
+		require.NoError(t, err)
+		require.Equal(t, c.name, tab.encoding)
+		require.Equal(t, c.text, string(tab.doc.Line(0)))
+
+		buf := bytes.Buffer{}
+		require.NoError(t, tab.writeTo(&buf))
+		require.Equal(t, c.content, buf.Bytes())
+	}
+
+	tab := NewTab("", NewPieceTable([]byte("日本")))
+	_, err := setOptions(tab, "fenc=latin1")
+	require.NoError(t, err)
+	require.Equal(t, "windows-1252", tab.encoding)
+	require.Error(t, tab.writeTo(&bytes.Buffer{}))
+
+	// a failed save leaves the file as it was and removes the temporary file
+	tab.path = filepath.Join(dir, "latin1")
+	require.NoError(t, os.WriteFile(tab.path, []byte("old\n"), 0644))
+	require.Error(t, tab.Save())
+	content, err := os.ReadFile(tab.path)
+	require.NoError(t, err)
+	require.Equal(t, "old\n", string(content))
+	_, err = os.Stat(tab.path + ".tmp")
+	require.True(t, os.IsNotExist(err))
+	_, err = setOptions(tab, "fenc=klingon")
+	require.Error(t, err)
+}
+
+func TestDetectBinaryOrUTF16(t *testing.T) {
+	// little-endian integers have the zero bytes of UTF-16 text
+	table := []byte{1, 0, 2, 0, 3, 0, 0x41, 0, 0x42, 0, 0xFF, 0}
+	name, _, _ := detectEncoding(table, EncodingUTF8)
+	require.Equal(t, EncodingBinary, name)
+	text, err := unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM).NewEncoder().Bytes([]byte("a few words of text\r\n😀"))
+	require.NoError(t, err)
+	name, _, _ = detectEncoding(text, EncodingUTF8)
+	require.Equal(t, "utf-16le", name)
+	name, _, _ = detectEncoding([]byte("h\x00i\x00\x00\xdc!\x00"), EncodingUTF8)
+	require.Equal(t, EncodingBinary, name)
+}
+
+func TestLossyDecode(t *testing.T) {
+	// an unpaired surrogate is replaced when the file is decoded
+	content := []byte("\xff\xfeh\x00\x00\xd8i\x00")
+	filePath := filepath.Join(t.TempDir(), "lossy")
+	require.NoError(t, os.WriteFile(filePath, content, 0644))
+	tab, err := NewTabFromPath(filePath)
+	require.NoError(t, err)
+	require.Equal(t, "utf-16le", tab.encoding)
+	require.True(t, tab.lossy)
+	require.Contains(t, tab.StatusInfo(), "[lossy]")
+
+	require.Error(t, tab.Save())
+	written, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+	require.Equal(t, content, written)
+
+	require.NoError(t, tab.Write(true))
+	require.False(t, tab.lossy)
+	written, err = os.ReadFile(filePath)
+	require.NoError(t, err)
+	require.Equal(t, []byte("\xff\xfeh\x00\xfd\xffi\x00"), written)
+}
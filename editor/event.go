@@ -54,3 +54,52 @@ type KeyEvent struct {
 type SubmittedCommandEvent struct {
 	Command string
 }
+
+// NormalCommandEvent emits when a view mode command that acts on the active tab is typed.
+// Count is the count typed before the command, 0 when none was typed. Register is the
+// register given with "{reg}, 0 when none was given.
+type NormalCommandEvent struct {
+	Keys     string
+	Count    int
+	Register rune
+}
+
+// MacroEvent emits when q{reg} or q is typed to start or stop recording a macro, or
+// @{reg} or @@ to replay one. Count is the count typed before @, 0 when none was typed.
+type MacroEvent struct {
+	Keys  string
+	Count int
+}
+
+// VisualCommandEvent emits when a command that acts on the selection is typed in visual
+// mode. Count and Register are like in NormalCommandEvent.
+type VisualCommandEvent struct {
+	Keys     string
+	Count    int
+	Register rune
+}
+
+// SearchEvent emits when a search typed after / or ? is submitted, an empty Pattern
+// searches the last pattern again
+type SearchEvent struct {
+	Pattern  string
+	Backward bool
+}
+
+// SearchPreviewEvent emits when the pattern typed after / or ? changes, to show the
+// first match before the search is submitted
+type SearchPreviewEvent struct {
+	Pattern  string
+	Backward bool
+}
+
+// ConfirmEvent emits when a key is typed at a confirm prompt, Answer is q for escape
+type ConfirmEvent struct {
+	Answer rune
+}
+
+// CompletionEvent emits when tab is typed in the command line to complete the word before
+// the cursor, Line is the text before the cursor
+type CompletionEvent struct {
+	Line string
+}
@@ -0,0 +1,439 @@
+package editor
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The kinds of errors of an ex command line, wrapped in an exError
+var (
+	errInvalidAddress = errors.New("invalid address")
+	errInvalidRange   = errors.New("invalid range")
+	errMarkNotSet     = errors.New("mark not set")
+	errNotFound       = errors.New("pattern not found")
+	errUnknownCommand = errors.New("not an editor command")
+	errNoRange        = errors.New("no range allowed")
+	errNoBang         = errors.New("no ! allowed")
+	errArgument       = errors.New("invalid argument")
+	errNoArgument     = errors.New("argument required")
+	errTrailing       = errors.New("trailing characters")
+	errHexView        = errors.New("not available in the hex view")
+)
+
+// exError is an error in an ex command line, text is the part of the line it is about
+type exError struct {
+	err  error
+	text string
+}
+
+func (e *exError) Error() string {
+	if e.text == "" {
+		return e.err.Error()
+	}
+	return fmt.Sprintf("%v: %s", e.err, e.text)
+}
+
+func (e *exError) Unwrap() error {
+	return e.err
+}
+
+// exCommand is a parsed ex command line like :2,5d x. The lines of the range start at
+// 0, a line of -1 is the line 0 of :m0 or :t0, before the first line.
+type exCommand struct {
+	// addresses is the number of addresses given, 0 when the range is the current line
+	addresses   int
+	first, last int
+	name        string
+	bang        bool
+	args        string
+}
+
+// exParser reads an ex command line
+type exParser struct {
+	tab  *Tab
+	line string
+	pos  int
+}
+
+// parseExCommand parses an ex command line typed on tab: a range, the name of the
+// command, ! and the arguments
+func parseExCommand(tab *Tab, line string) (exCommand, error) {
+	p := &exParser{tab: tab, line: line}
+	cmd, err := p.parseRange()
+	if err != nil {
+		return cmd, err
+	}
+	p.skipBlanks()
+	cmd.name = p.parseName()
+	if strings.HasPrefix(p.rest(), "!") && cmd.name != "" {
+		cmd.bang = true
+		p.pos++
+	}
+	// the arguments of :s/a/b / start right after the name, they are kept as typed but
+	// for the blanks that separate them from the name
+	cmd.args = strings.TrimLeft(p.rest(), " \t")
+	return cmd, nil
+}
+
+func (p *exParser) rest() string {
+	return p.line[p.pos:]
+}
+
+func (p *exParser) peek() rune {
+	r, _ := utf8.DecodeRuneInString(p.rest())
+	return r
+}
+
+func (p *exParser) skipBlanks() {
+	p.pos += len(p.rest()) - len(strings.TrimLeft(p.rest(), " \t"))
+}
+
+// parseName reads the name of a command: letters, or a run of > or <, or nothing before
+// the arguments of a line number like :5
+func (p *exParser) parseName() string {
+	start := p.pos
+	switch r := p.peek(); {
+	case r == '>' || r == '<':
+		for p.peek() == r {
+			p.pos++
+		}
+	default:
+		for unicode.IsLetter(p.peek()) {
+			p.pos += utf8.RuneLen(p.peek())
+		}
+	}
+	return p.line[start:p.pos]
+}
+
+// parseRange reads the addresses before the name of the command. Addresses separated by
+// ; are read from the line of the previous one, the last two are the range.
+func (p *exParser) parseRange() (exCommand, error) {
+	cursor := p.tab.GetCursor().Line
+	cmd := exCommand{first: cursor, last: cursor}
+	p.skipBlanks()
+	if p.peek() == '%' {
+		p.pos++
+		cmd.addresses, cmd.first, cmd.last = 2, 0, p.tab.doc.LineCount()-1
+		return cmd, nil
+	}
+	current := cursor
+	for {
+		start := p.pos
+		line, ok, err := p.parseAddress(current)
+		if err != nil {
+			return cmd, err
+		}
+		p.skipBlanks()
+		sep := p.peek()
+		if !ok && sep != ',' && sep != ';' {
+			break
+		}
+		if !ok {
+			// a missing address next to a separator is the current line
+			line = current
+		}
+		if line < -1 {
+			return cmd, &exError{err: errInvalidRange, text: p.line[start:p.pos]}
+		}
+		// like vim, a line past the end is the last line
+		line = min(line, p.tab.doc.LineCount()-1)
+		cmd.addresses++
+		cmd.first, cmd.last = cmd.last, line
+		if cmd.addresses == 1 {
+			cmd.first = line
+		}
+		if sep != ',' && sep != ';' {
+			break
+		}
+		p.pos++
+		if sep == ';' {
+			current = max(line, 0)
+		}
+	}
+	if cmd.first > cmd.last {
+		cmd.first, cmd.last = cmd.last, cmd.first
+	}
+	return cmd, nil
+}
+
+// parseAddress reads one address: a line number, ., $, 'a, /pat/ or ?pat?, followed by
+// offsets like +2 or -. ok is false when there is no address.
+func (p *exParser) parseAddress(current int) (line int, ok bool, err error) {
+	p.skipBlanks()
+	start := p.pos
+	line, ok = current, true
+	switch r := p.peek(); {
+	case r >= '0' && r <= '9':
+		n := p.parseNumber()
+		line = n - 1
+	case r == '.':
+		p.pos++
+	case r == '$':
+		p.pos++
+		line = p.tab.doc.LineCount() - 1
+	case r == '\'':
+		p.pos++
+		name, size := utf8.DecodeRuneInString(p.rest())
+		p.pos += size
+		pos, set := p.tab.getMark(name)
+		if !set {
+			return 0, false, &exError{err: errMarkNotSet, text: p.line[start:p.pos]}
+		}
+		line = pos.Line
+	case r == '/' || r == '?':
+		if line, err = p.parseSearch(r, current); err != nil {
+			return 0, false, err
+		}
+	case r == '+' || r == '-':
+	default:
+		return current, false, nil
+	}
+	for {
+		r := p.peek()
+		if r != '+' && r != '-' {
+			break
+		}
+		p.pos++
+		n := 1
+		if c := p.peek(); c >= '0' && c <= '9' {
+			n = p.parseNumber()
+		}
+		if r == '-' {
+			n = -n
+		}
+		line += n
+	}
+	if line < -1 {
+		return 0, false, &exError{err: errInvalidRange, text: p.line[start:p.pos]}
+	}
+	return line, true, nil
+}
+
+func (p *exParser) parseNumber() int {
+	start := p.pos
+	for c := p.peek(); c >= '0' && c <= '9'; c = p.peek() {
+		p.pos++
+	}
+	n, _ := strconv.Atoi(p.line[start:p.pos])
+	return n
+}
+
+// parseSearch reads /pat/ or ?pat? and returns the next line after current matching pat,
+// or the previous one for ?pat?. An empty pattern is the last search pattern.
+func (p *exParser) parseSearch(delim rune, current int) (int, error) {
+	start := p.pos
+	p.pos++
+	pattern := ""
+	escaped := false
+	for p.pos < len(p.line) {
+		r := p.peek()
+		p.pos += utf8.RuneLen(r)
+		if !escaped && r == delim {
+			break
+		}
+		if escaped && r != delim {
+			pattern += `\`
+		}
+		escaped = !escaped && r == '\\'
+		if !escaped {
+			pattern += string(r)
+		}
+	}
+	if pattern == "" {
+		pattern = lastSearch.pattern
+	}
+	if pattern == "" {
+		return 0, &exError{err: errors.New("no previous search pattern")}
+	}
+	re, err := compileSearch(pattern)
+	if err != nil {
+		return 0, &exError{err: errInvalidAddress, text: p.line[start:p.pos]}
+	}
+	lastSearch.pattern, lastSearch.backward, lastSearch.hidden = pattern, delim == '?', false
+	// the search starts at the end of the current line, or at its start backward
+	from := Position{Line: current, Col: p.tab.lineLen(current)}
+	if delim == '?' {
+		from.Col = 0
+	}
+	pos, ok := p.tab.searchFrom(re, from, delim == '?', 1)
+	if !ok {
+		return 0, &exError{err: errNotFound, text: pattern}
+	}
+	return pos.Line, nil
+}
+
+// count returns the count given as the argument of commands like :d 3, 0 for none
+func (c *exCommand) count() (int, error) {
+	if c.args == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(c.args)
+	if err != nil || n <= 0 {
+		return 0, &exError{err: errArgument, text: c.args}
+	}
+	return n, nil
+}
+
+// registerAndCount reads the arguments of :d and :y, an optional register followed by
+// an optional count. A count makes the range start at its last line.
+func (c *exCommand) registerAndCount() (rune, error) {
+	var name rune
+	if r, size := utf8.DecodeRuneInString(c.args); size > 0 && !unicode.IsDigit(r) {
+		if !isRegisterName(r) {
+			return 0, &exError{err: errArgument, text: c.args}
+		}
+		name = r
+		c.args = strings.TrimSpace(c.args[size:])
+	}
+	count, err := c.count()
+	if err != nil {
+		return 0, err
+	}
+	if count > 0 {
+		c.first = c.last
+		c.last += count - 1
+	}
+	return name, nil
+}
+
+// lineCommand runs fn on the active tab, for the commands that act on the lines of
+// their range
+func lineCommand(fn func(tab *Tab, cmd exCommand) error) func(e *Editor, cmd exCommand) error {
+	return func(e *Editor, cmd exCommand) error {
+		tab := e.getActiveTab()
+		if tab.hexMode {
+			return errHexView
+		}
+		return fn(tab, cmd)
+	}
+}
+
+// jumpToLine executes :N, which moves to the first non-blank character of the last line of
+// the range
+func jumpToLine(tab *Tab, cmd exCommand) error {
+	if cmd.args != "" {
+		return &exError{err: errUnknownCommand, text: cmd.args}
+	}
+	if cmd.addresses > 0 {
+		line := max(cmd.last, 0)
+		tab.SetCursor(Position{Line: line, Col: tab.indentCol(line)})
+	}
+	return nil
+}
+
+// deleteLines executes :d and :y, op is d or y
+func deleteLines(op rune) func(tab *Tab, cmd exCommand) error {
+	return func(tab *Tab, cmd exCommand) error {
+		name, err := cmd.registerAndCount()
+		if err != nil {
+			return err
+		}
+		first, last := max(cmd.first, 0), min(max(cmd.last, 0), tab.doc.LineCount()-1)
+		lines := textRange{start: Position{Line: first}, end: Position{Line: last}, linewise: true}
+		// unlike y in view mode, :y does not move the cursor
+		cursor := tab.GetCursor()
+		tab.applyRange(op, name, lines)
+		if op == 'y' {
+			tab.SetCursor(cursor)
+		}
+		return nil
+	}
+}
+
+// moveLines executes :m, or :t when keep is true
+func moveLines(keep bool) func(tab *Tab, cmd exCommand) error {
+	return func(tab *Tab, cmd exCommand) error {
+		dest, err := parseDestination(tab, cmd.args)
+		if err != nil {
+			return err
+		}
+		if keep {
+			tab.CopyLines(max(cmd.first, 0), max(cmd.last, 0), dest)
+			return nil
+		}
+		return tab.MoveLines(max(cmd.first, 0), max(cmd.last, 0), dest)
+	}
+}
+
+// shiftLines executes :> and :<, the lines are shifted once for every > or < of the name
+func shiftLines(tab *Tab, cmd exCommand) error {
+	count, err := cmd.count()
+	if err != nil {
+		return err
+	}
+	first, last := max(cmd.first, 0), max(cmd.last, 0)
+	if count > 0 {
+		first, last = last, last+count-1
+	}
+	last = min(last, tab.doc.LineCount()-1)
+	shift := len(cmd.name)
+	if cmd.name[0] == '<' {
+		shift = -shift
+	}
+	tab.ShiftLines(first, last, shift)
+	tab.SetCursor(Position{Line: last, Col: tab.indentCol(last)})
+	return nil
+}
+
+// parseDestination parses the address after :m and :t, 0 is above the first line
+func parseDestination(tab *Tab, args string) (int, error) {
+	p := &exParser{tab: tab, line: args}
+	line, ok, err := p.parseAddress(tab.GetCursor().Line)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, &exError{err: errNoArgument}
+	}
+	if p.skipBlanks(); p.rest() != "" || line < -1 {
+		return 0, &exError{err: errInvalidAddress, text: args}
+	}
+	return min(line, tab.doc.LineCount()-1), nil
+}
+
+// MoveLines moves the lines from first to last below the line dest as a single change,
+// above the first line when dest is -1. The cursor ends on the last moved line.
+func (s *Tab) MoveLines(first, last, dest int) error {
+	if dest >= first && dest < last {
+		return errors.New("cannot move a range of lines into itself")
+	}
+	if dest == first-1 || dest == last {
+		s.SetCursor(Position{Line: last, Col: s.indentCol(last)})
+		return nil
+	}
+	s.BeginChange()
+	defer s.EndChange()
+	lines := textRange{start: Position{Line: first}, end: Position{Line: last}, linewise: true}
+	reg := s.rangeText(lines)
+	s.deleteRange(lines)
+	if dest > last {
+		dest -= last - first + 1
+	}
+	s.putLines(reg, dest)
+	return nil
+}
+
+// CopyLines copies the lines from first to last below the line dest as a single change,
+// above the first line when dest is -1. The cursor ends on the last copied line.
+func (s *Tab) CopyLines(first, last, dest int) {
+	lines := textRange{start: Position{Line: first}, end: Position{Line: last}, linewise: true}
+	s.putLines(s.rangeText(lines), dest)
+}
+
+// putLines puts the linewise text of reg below the line dest, or above the first line
+// when dest is -1, and moves the cursor to the last put line
+func (s *Tab) putLines(reg register, dest int) {
+	if dest < 0 {
+		s.SetCursor(Position{})
+		s.put(reg, true, 1)
+	} else {
+		s.SetCursor(Position{Line: dest})
+		s.put(reg, false, 1)
+	}
+	line := dest + strings.Count(string(reg.text), "\n")
+	s.SetCursor(Position{Line: line, Col: s.indentCol(line)})
+}
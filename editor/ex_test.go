@@ -0,0 +1,159 @@
+package editor
+
+import (
+	"errors"
+	"github.com/test-go/testify/require"
+	"testing"
+)
+
+func TestParseExCommand(t *testing.T) {
+	tests := []struct {
+		line        string
+		first, last int
+		name, args  string
+		bang        bool
+		addresses   int
+	}{
+		{line: "w", first: 2, last: 2, name: "w"},
+		{line: "q!", first: 2, last: 2, name: "q", bang: true},
+		{line: "5", first: 4, last: 4, addresses: 1},
+		{line: ".", first: 2, last: 2, addresses: 1},
+		{line: "$", first: 5, last: 5, addresses: 1},
+		{line: "%d", first: 0, last: 5, name: "d", addresses: 2},
+		{line: "2,4y a", first: 1, last: 3, name: "y", args: "a", addresses: 2},
+		{line: "4,2d", first: 1, last: 3, name: "d", addresses: 2},
+		{line: ".+1,$-1>>", first: 3, last: 4, name: ">>", addresses: 2},
+		{line: "-,+", first: 1, last: 3, addresses: 2},
+		{line: "'a,'b", first: 1, last: 4, addresses: 2},
+		{line: "/four/", first: 3, last: 3, addresses: 1},
+		{line: "?two?+1", first: 2, last: 2, addresses: 1},
+		{line: "/f/;/f/", first: 3, last: 4, addresses: 2},
+		{line: "1,3s/a/b/g", first: 0, last: 2, name: "s", args: "/a/b/g", addresses: 2},
+		{line: "  open  some file ", first: 2, last: 2, name: "open", args: "some file "},
+		{line: "normal A  ", first: 2, last: 2, name: "normal", args: "A  "},
+		{line: "m0", first: 2, last: 2, name: "m", args: "0"},
+		{line: "999", first: 5, last: 5, addresses: 1},
+		{line: "2,999d", first: 1, last: 5, name: "d", addresses: 2},
+	}
+	for _, test := range tests {
+		t.Run(test.line, func(t *testing.T) {
+			resetSearch(t)
+			_, tab := newTestWindow("one\ntwo\nthree\nfour\nfive\nsix")
+			tab.SetCursor(Position{Line: 2})
+			tab.setMark('a', Position{Line: 1})
+			tab.setMark('b', Position{Line: 4})
+			cmd, err := parseExCommand(tab, test.line)
+			require.NoError(t, err)
+			require.Equal(t, exCommand{
+				addresses: test.addresses,
+				first:     test.first,
+				last:      test.last,
+				name:      test.name,
+				bang:      test.bang,
+				args:      test.args,
+			}, cmd)
+		})
+	}
+}
+
+func TestParseExCommandErrors(t *testing.T) {
+	tests := []struct {
+		line string
+		err  error
+	}{
+		{".-4d", errInvalidRange},
+		{"1,-3d", errInvalidRange},
+		{"'x", errMarkNotSet},
+		{"/nothing/d", errNotFound},
+		{"/(/d", errInvalidAddress},
+	}
+	for _, test := range tests {
+		t.Run(test.line, func(t *testing.T) {
+			resetSearch(t)
+			_, tab := newTestWindow("one\ntwo\nthree\nfour\nfive\nsix")
+			_, err := parseExCommand(tab, test.line)
+			require.True(t, errors.Is(err, test.err), err)
+		})
+	}
+}
+
+func TestLineCommands(t *testing.T) {
+	tests := []struct {
+		cmd, want string
+		cursor    Position
+	}{
+		{":2,3d\r", "1\n4\n5", Position{Line: 1}},
+		{":d 2\r", "1\n4\n5", Position{Line: 1}},
+		{":$d\r", "1\n2\n3\n4", Position{Line: 3}},
+		{":1,2m$\r", "3\n4\n5\n1\n2", Position{Line: 4}},
+		{":4m0\r", "4\n1\n2\n3\n5", Position{Line: 0}},
+		{":3m1\r", "1\n3\n2\n4\n5", Position{Line: 1}},
+		{":1t.\r", "1\n2\n1\n3\n4\n5", Position{Line: 2}},
+		{":2,3co0\r", "2\n3\n1\n2\n3\n4\n5", Position{Line: 1}},
+		{":2,3>>\r", "1\n\t\t2\n\t\t3\n4\n5", Position{Line: 2, Col: 2}},
+		{":4\r", "1\n2\n3\n4\n5", Position{Line: 3}},
+		{":+2\r", "1\n2\n3\n4\n5", Position{Line: 3}},
+		{":4,9d\r", "1\n2\n3", Position{Line: 2}},
+		{":1m9\r", "2\n3\n4\n5\n1", Position{Line: 4}},
+		{":99\r", "1\n2\n3\n4\n5", Position{Line: 4}},
+	}
+	for _, test := range tests {
+		t.Run(test.cmd, func(t *testing.T) {
+			e := newTestEditor(t, "1\n2\n3\n4\n5")
+			tab := e.getActiveTab()
+			tab.SetCursor(Position{Line: 1})
+			typeEditorKeys(e, test.cmd)
+			require.Equal(t, test.want, tabText(tab))
+			require.Equal(t, test.cursor, tab.GetCursor())
+			if test.want != "1\n2\n3\n4\n5" {
+				require.NoError(t, tab.Undo())
+				require.Equal(t, "1\n2\n3\n4\n5", tabText(tab))
+			}
+		})
+	}
+}
+
+func TestLineCommandRegisters(t *testing.T) {
+	registers = map[rune]register{}
+	e := newTestEditor(t, "1\n2\n3\n4\n5")
+	tab := e.getActiveTab()
+	typeEditorKeys(e, ":2,3y a\r")
+	require.Equal(t, register{text: []byte("2\n3\n"), linewise: true}, registers['a'])
+	require.Equal(t, Position{}, tab.GetCursor())
+	typeEditorKeys(e, ":$d\r")
+	require.Equal(t, register{text: []byte("5\n"), linewise: true}, registers['1'])
+}
+
+func TestExCommandErrors(t *testing.T) {
+	e := newTestEditor(t, "1\n2\n3")
+	tab := e.getActiveTab()
+	for line, msg := range map[string]string{
+		":open\r":  "err: argument required: open",
+		":path\r":  "err: argument required: path",
+		":qwe\r":   "err: not an editor command: qwe",
+		":2hex\r":  "err: no range allowed: 2hex",
+		":d!\r":    "err: no ! allowed: d!",
+		":2,1m1\r": "err: cannot move a range of lines into itself",
+		":m\r":     "err: argument required",
+		":m -5\r":  "err: invalid range: -5",
+	} {
+		typeEditorKeys(e, line)
+		require.Equal(t, msg, GlobalState.getInfoLine(), line)
+	}
+	require.Equal(t, "1\n2\n3", tabText(tab))
+	require.False(t, GlobalState.IsFinished())
+}
+
+func TestMarks(t *testing.T) {
+	w, tab := newTestWindow("one\ntwo\nthree\nfour")
+	typeKeys(w, "jllmajjma")
+	require.Equal(t, Position{Line: 3, Col: 2}, tab.marks['a'])
+	typeKeys(w, "mbgg`b")
+	require.Equal(t, Position{Line: 3, Col: 2}, tab.GetCursor())
+	typeKeys(w, "ggdd'b")
+	require.Equal(t, Position{Line: 2}, tab.GetCursor())
+
+	typeKeys(w, "ggVj<Esc>")
+	require.Equal(t, Position{Line: 0}, tab.marks['<'])
+	require.Equal(t, Position{Line: 1}, tab.marks['>'])
+}
@@ -0,0 +1,105 @@
+package editor
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// File formats, named after the line ending they use like in vim
+const (
+	// FileFormatUnix ends lines with LF
+	FileFormatUnix = "unix"
+	// FileFormatDos ends lines with CRLF
+	FileFormatDos = "dos"
+	// FileFormatMac ends lines with CR
+	FileFormatMac = "mac"
+)
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// fileFormats lists the accepted values of the fileformat option
+var fileFormats = []string{FileFormatUnix, FileFormatDos, FileFormatMac}
+
+// FileFormat describes how a file stores the end of its lines
+type FileFormat struct {
+	// Format is the line ending written on save, one of FileFormatUnix, FileFormatDos or FileFormatMac
+	Format string
+	// Mixed is true when the file was read with more than one kind of line ending
+	Mixed bool
+	// BOM is true when the file starts with the byte order mark of its encoding
+	BOM bool
+	// EOL is true when the last line ends with a line ending
+	EOL bool
+	// EmptyLine is true when the file held a single empty line, so that the empty
+	// document is written as a line ending rather than as an empty file
+	EmptyLine bool
+}
+
+// DefaultFileFormat is the format of new files
+func DefaultFileFormat() FileFormat {
+	return FileFormat{Format: FileFormatUnix, EOL: true}
+}
+
+// lineEnding returns the bytes written at the end of every line
+func (f FileFormat) lineEnding() []byte {
+	switch f.Format {
+	case FileFormatDos:
+		return []byte("\r\n")
+	case FileFormatMac:
+		return []byte("\r")
+	default:
+		return []byte("\n")
+	}
+}
+
+// String returns a short description of the format for the status line
+func (f FileFormat) String() string {
+	res := f.Format
+	if f.Mixed {
+		res = fmt.Sprintf("%s (mixed)", res)
+	}
+	if f.BOM {
+		res += " bom"
+	}
+	if !f.EOL {
+		res += " noeol"
+	}
+	return res
+}
+
+// detectFileFormat detects the line endings and final newline of UTF-8 content and returns
+// the content normalized to LF line endings and without the final newline.
+// Like vim, a file is dos only when every line ends with CRLF and mac only when every line
+// ends with CR. Other files are unix and keep their stray CRs in the text, so that saving
+// writes back exactly the bytes that were read.
+func detectFileFormat(content []byte) (FileFormat, []byte) {
+	format := DefaultFileFormat()
+
+	crlf := bytes.Count(content, []byte("\r\n"))
+	lf := bytes.Count(content, []byte("\n")) - crlf
+	cr := bytes.Count(content, []byte("\r")) - crlf
+	switch {
+	case crlf > 0 && lf == 0:
+		format.Format = FileFormatDos
+		content = bytes.ReplaceAll(content, []byte("\r\n"), []byte("\n"))
+	case cr > 0 && lf == 0 && crlf == 0:
+		format.Format = FileFormatMac
+		content = bytes.ReplaceAll(content, []byte("\r"), []byte("\n"))
+	}
+	kinds := 0
+	for _, n := range []int{crlf, lf, cr} {
+		if n > 0 {
+			kinds++
+		}
+	}
+	format.Mixed = kinds > 1
+
+	if len(content) > 0 {
+		format.EOL = content[len(content)-1] == '\n'
+		if format.EOL {
+			content = content[:len(content)-1]
+			format.EmptyLine = len(content) == 0
+		}
+	}
+	return format, content
+}
@@ -0,0 +1,47 @@
+package editor
+
+import (
+	"bytes"
+	"github.com/test-go/testify/require"
+	"testing"
+)
+
+func TestFileFormatRoundTrip(t *testing.T) {
+	cases := []struct {
+		content string
+		format  FileFormat
+		lines   string
+	}{
+		{"a\nb\n", FileFormat{Format: FileFormatUnix, EOL: true}, "a\nb"},
+		{"a\nb", FileFormat{Format: FileFormatUnix}, "a\nb"},
+		{"a\r\nb\r\n", FileFormat{Format: FileFormatDos, EOL: true}, "a\nb"},
+		{"a\rb", FileFormat{Format: FileFormatMac}, "a\nb"},
+		{"a\r\nb\n", FileFormat{Format: FileFormatUnix, Mixed: true, EOL: true}, "a\r\nb"},
+		{"", FileFormat{Format: FileFormatUnix, EOL: true}, ""},
+		{"\n", FileFormat{Format: FileFormatUnix, EOL: true, EmptyLine: true}, ""},
+		{"\r\n", FileFormat{Format: FileFormatDos, EOL: true, EmptyLine: true}, ""},
+	}
+	for _, c := range cases {
+		format, content := detectFileFormat([]byte(c.content))
+		require.Equal(t, c.format, format, c.content)
+		require.Equal(t, c.lines, string(content))
+
+		tab := NewTab("", NewPieceTable(content))
+		tab.fileFormat = format
+		buf := bytes.Buffer{}
+		require.NoError(t, tab.writeTo(&buf))
+		require.Equal(t, c.content, buf.String())
+	}
+
+	tab := NewTab("", NewPieceTable([]byte("a\nb")))
+	_, err := setOptions(tab, "ff=dos noeol")
+	require.NoError(t, err)
+	msg, err := setOptions(tab, "ff? eol?")
+	require.NoError(t, err)
+	require.Equal(t, "fileformat=dos noeol", msg)
+	buf := bytes.Buffer{}
+	require.NoError(t, tab.writeTo(&buf))
+	require.Equal(t, "a\r\nb", buf.String())
+	_, err = setOptions(tab, "ff=windows")
+	require.Error(t, err)
+}
@@ -0,0 +1,93 @@
+package editor
+
+import (
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// global executes :g/{pattern}/{command}, or :v when invert is true to run the command on
+// the lines that do not match. The lines of the range, the whole text by default, are
+// marked before the command runs on them so that a command that deletes or moves lines
+// skips the deleted ones. The whole run is undone as a single step. Without a command the
+// lines are listed.
+func (s *Editor) global(cmd exCommand, invert bool) error {
+	if s.inGlobal {
+		return errors.New("cannot run :global recursively")
+	}
+	tab := s.getActiveTab()
+	if tab.hexMode {
+		return errHexView
+	}
+	delim, size := utf8.DecodeRuneInString(cmd.args)
+	if size == 0 || !isDelimiter(delim) {
+		return errors.New("missing pattern delimiter")
+	}
+	parts := splitEscaped(cmd.args[size:], delim, 2)
+	pattern := parts[0]
+	if pattern == "" {
+		pattern = lastSearch.pattern
+	}
+	if pattern == "" {
+		return errors.New("no previous search pattern")
+	}
+	re, err := compileSearch(pattern)
+	if err != nil {
+		return err
+	}
+	lastSearch.pattern, lastSearch.hidden = pattern, false
+
+	first, last := max(cmd.first, 0), cmd.last
+	if cmd.addresses == 0 {
+		first, last = 0, tab.doc.LineCount()-1
+	}
+	var marked []int
+	for line := first; line <= last; line++ {
+		if re.match(tab.doc.Line(line)) != invert {
+			marked = append(marked, line)
+		}
+	}
+	if len(marked) == 0 {
+		if invert {
+			return fmt.Errorf("pattern found in every line: %s", pattern)
+		}
+		return &exError{err: errNotFound, text: pattern}
+	}
+
+	command := ""
+	if len(parts) > 1 {
+		command = parts[1]
+	}
+	if command == "" {
+		lines := make([]string, len(marked))
+		for i, line := range marked {
+			lines[i] = fmt.Sprintf("%3d %s", line+1, tab.doc.Line(line))
+		}
+		GlobalState.ShowLines(lines)
+		tab.SetCursor(Position{Line: marked[len(marked)-1], Col: tab.indentCol(marked[len(marked)-1])})
+		return nil
+	}
+
+	s.inGlobal = true
+	tab.globalLines = marked
+	tab.BeginChange()
+	defer func() {
+		tab.EndChange()
+		tab.globalLines = nil
+		s.inGlobal = false
+	}()
+	for i := 0; i < len(tab.globalLines); i++ {
+		line := tab.globalLines[i]
+		if line < 0 {
+			continue
+		}
+		tab.SetCursor(Position{Line: line})
+		if err := s.runCommand(command); err != nil {
+			return err
+		}
+		if s.getActiveTab() != tab {
+			break
+		}
+	}
+	return nil
+}
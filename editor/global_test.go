@@ -0,0 +1,59 @@
+package editor
+
+import (
+	"github.com/test-go/testify/require"
+	"testing"
+)
+
+func TestGlobal(t *testing.T) {
+	tests := []struct {
+		name, text, cmd, want string
+	}{
+		{"delete", "a1\nb\na2\na3\nc", ":g/a/d\r", "b\nc"},
+		{"delete the next line", "a\nx\na\nx\ny", ":g/a/+1d\r", "a\na\ny"},
+		{"delete marked lines", "a\na\nb\nc", ":g/a/.,+1d\r", "b\nc"},
+		{"invert", "a1\nb\na2\nc", ":v/a/d\r", "a1\na2"},
+		{"bang", "a1\nb\na2\nc", ":g!/a/d\r", "a1\na2"},
+		{"range", "a\na\na\na", ":2,3g/a/s//b/\r", "a\nb\nb\na"},
+		{"reverse", "1\n2\n3\n4", ":g/^/m0\r", "4\n3\n2\n1"},
+		{"copy", "a\nb", ":g/./t.\r", "a\na\nb\nb"},
+		{"shift", "a\nb\na", ":g/a/>\r", "\ta\nb\n\ta"},
+		{"substitute", "foo\nbar foo\nbaz", ":g/ba/s/o/0/g\r", "foo\nbar f00\nbaz"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resetSearch(t)
+			e := newTestEditor(t, test.text)
+			tab := e.getActiveTab()
+			typeEditorKeys(e, test.cmd)
+			require.Equal(t, test.want, tabText(tab))
+			require.Nil(t, tab.globalLines)
+			require.NoError(t, tab.Undo())
+			require.Equal(t, test.text, tabText(tab))
+		})
+	}
+}
+
+func TestGlobalErrors(t *testing.T) {
+	resetSearch(t)
+	e := newTestEditor(t, "a\nb")
+	tab := e.getActiveTab()
+	for line, msg := range map[string]string{
+		":g/x/d\r":        "err: pattern not found: x",
+		":v/./d\r":        "err: pattern found in every line: .",
+		":g\r":            "err: missing pattern delimiter",
+		":g/a/g/b/d\r":    "err: cannot run :global recursively",
+		":g/a/s/a/b/c\r":  "err: cannot confirm the substitutions of :global",
+		":g/a/qwe\r":      "err: not an editor command: qwe",
+		":g/(/d\r":        "err: invalid pattern: error parsing regexp: missing closing ): `(`",
+		":vglobal!/a/d\r": "err: no ! allowed: vglobal!/a/d",
+	} {
+		typeEditorKeys(e, line)
+		require.Equal(t, msg, GlobalState.getInfoLine(), line)
+	}
+	require.Equal(t, "a\nb", tabText(tab))
+
+	typeEditorKeys(e, "\x1b:g/a\r")
+	require.Equal(t, []string{"  1 a"}, GlobalState.messageLines)
+	require.Equal(t, "a\nb", tabText(tab))
+}
@@ -0,0 +1,274 @@
+package editor
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/dangdungcntt/ndditor/editor/layout"
+	"github.com/gdamore/tcell/v2"
+	"unicode/utf8"
+)
+
+const (
+	// hexBytesPerRow is the number of bytes shown on every row of the hex view
+	hexBytesPerRow = 16
+	// hexOffsetWidth is the width of the offset column, 8 hex digits and a gap
+	hexOffsetWidth = 10
+	// hexBytesWidth is the width of the hex column, 3 cells per byte, an extra space
+	// in the middle of the row and a gap
+	hexBytesWidth = hexBytesPerRow*3 + 2
+)
+
+// binarySniffLength is how many bytes are checked for a NUL byte to detect binary files
+const binarySniffLength = 8000
+
+// isBinary returns true when content looks like binary data rather than text
+func isBinary(content []byte) bool {
+	return bytes.IndexByte(content[:min(len(content), binarySniffLength)], 0) >= 0
+}
+
+// hexText is the text of a text tab while its hex view edits the bytes of its file
+type hexText struct {
+	doc      Document
+	undo     *UndoTree
+	savedSeq int
+	// modified is true when the text was not written to the file
+	modified bool
+}
+
+// ToggleHex switches the tab between the text view and the hex view. The hex view of a
+// text tab edits the bytes of its file, the text encoded with the encoding, the byte order
+// mark and the line endings of the tab, which are decoded back to text when it is left.
+func (s *Tab) ToggleHex() error {
+	if s.hexMode {
+		return s.leaveHex()
+	}
+	s.hexNibble = 0
+	if s.encoding == EncodingBinary {
+		s.hexMode = true
+		s.hexCursor = s.cursorOffset()
+		return nil
+	}
+	buf := bytes.Buffer{}
+	if err := s.writeTo(&buf); err != nil {
+		return err
+	}
+	s.hexCursor = s.fileOffset(s.cursorOffset())
+	s.hexText = &hexText{doc: s.doc, undo: s.undo, savedSeq: s.savedSeq, modified: s.Modified()}
+	s.doc = NewPieceTable(buf.Bytes())
+	s.undo = NewUndoTree(s.doc)
+	s.wraps, s.cols = nil, nil
+	s.savedSeq = s.undo.Seq()
+	s.hexMode = true
+	return nil
+}
+
+// leaveHex leaves the hex view. The bytes of a text tab are decoded back to its text,
+// the changes made to them are a single change of the text.
+func (s *Tab) leaveHex() error {
+	if s.hexText == nil {
+		s.hexMode = false
+		s.SetCursor(s.positionOf(s.hexCursor))
+		return nil
+	}
+	content := s.doc.Slice(0, s.doc.Len())
+	mark := byteOrderMarks[s.encoding]
+	bom := mark != nil && bytes.HasPrefix(content, mark)
+	text := content
+	if bom {
+		text = content[len(mark):]
+	}
+	format, text, lossy, err := decodeText(text, s.encoding)
+	if err != nil {
+		return err
+	}
+	format.BOM = bom
+	cursor := textOffset(content[:s.hexCursor], s.encoding, format)
+	modified := s.Modified()
+
+	h := s.hexText
+	s.doc, s.undo, s.savedSeq, s.hexText = h.doc, h.undo, h.savedSeq, nil
+	s.hexMode = false
+	s.wraps, s.cols = nil, nil
+	if !bytes.Equal(text, s.doc.Slice(0, s.doc.Len())) {
+		s.BeginChange()
+		s.deleteText(0, s.doc.Len())
+		s.insertText(0, text)
+		s.EndChange()
+	}
+	s.fileFormat = format
+	s.lossy = lossy
+	if !modified {
+		s.savedSeq = s.undo.Seq()
+	}
+	s.SetCursor(s.positionOf(min(cursor, s.doc.Len())))
+	return nil
+}
+
+// fileOffset returns the offset in the bytes of the file of the text before offset
+func (s *Tab) fileOffset(offset int) int {
+	text := bytes.ReplaceAll(s.doc.Slice(0, offset), []byte{'\n'}, s.fileFormat.lineEnding())
+	if _, enc, _ := lookupEncoding(s.encoding); enc != nil {
+		text, _ = enc.NewEncoder().Bytes(text)
+	}
+	if mark := byteOrderMarks[s.encoding]; s.fileFormat.BOM {
+		return len(mark) + len(text)
+	}
+	return len(text)
+}
+
+// textOffset returns the offset in the text of the end of content, the start of the
+// bytes of a file in the encoding name and format. A character cut at the end of content
+// is left out.
+func textOffset(content []byte, name string, format FileFormat) int {
+	if mark := byteOrderMarks[name]; format.BOM {
+		content = content[min(len(mark), len(content)):]
+	}
+	text := content
+	if _, enc, _ := lookupEncoding(name); enc != nil {
+		// a UTF-8 character is at most 3 bytes for every byte of the encoding, not at
+		// the end of the input the decoder stops before a cut character
+		text = make([]byte, 3*len(content)+utf8.UTFMax)
+		n, _, _ := enc.NewDecoder().Transform(text, content, false)
+		text = text[:n]
+	} else {
+		for i := max(len(text)-utf8.UTFMax+1, 0); i < len(text); i++ {
+			if utf8.RuneStart(text[i]) && !utf8.FullRune(text[i:]) {
+				text = text[:i]
+				break
+			}
+		}
+	}
+	switch format.Format {
+	case FileFormatDos:
+		text = bytes.ReplaceAll(text, []byte("\r\n"), []byte{'\n'})
+	case FileFormatMac:
+		text = bytes.ReplaceAll(text, []byte{'\r'}, []byte{'\n'})
+	}
+	return len(text)
+}
+
+// IsHex returns true when the tab shows the hex view
+func (s *Tab) IsHex() bool {
+	return s.hexMode
+}
+
+func (s *Tab) hexMoveCursor(dx, dy int) {
+	s.setHexCursor(s.hexCursor + dx + dy*hexBytesPerRow)
+}
+
+func (s *Tab) setHexCursor(offset int) {
+	s.hexCursor = min(max(offset, 0), s.doc.Len())
+	s.hexNibble = 0
+}
+
+// hexInsertRune overwrites the nibble under the cursor with the hex digit r.
+// Typing at the end of the document appends a new byte.
+func (s *Tab) hexInsertRune(r rune) {
+	var digit byte
+	switch {
+	case r >= '0' && r <= '9':
+		digit = byte(r - '0')
+	case r >= 'a' && r <= 'f':
+		digit = byte(r-'a') + 10
+	case r >= 'A' && r <= 'F':
+		digit = byte(r-'A') + 10
+	default:
+		return
+	}
+
+	s.BeginChange()
+	defer s.EndChange()
+	var b byte
+	if s.hexCursor < s.doc.Len() {
+		b = s.doc.Slice(s.hexCursor, 1)[0]
+		s.deleteText(s.hexCursor, 1)
+	}
+	if s.hexNibble == 0 {
+		b = b&0x0F | digit<<4
+	} else {
+		b = b&0xF0 | digit
+	}
+	s.insertText(s.hexCursor, []byte{b})
+
+	if s.hexNibble == 0 {
+		s.hexNibble = 1
+	} else {
+		s.setHexCursor(s.hexCursor + 1)
+	}
+}
+
+// hexBackspace moves the cursor back by one nibble
+func (s *Tab) hexBackspace() {
+	if s.hexNibble == 1 {
+		s.hexNibble = 0
+	} else if s.hexCursor > 0 {
+		s.hexCursor--
+		s.hexNibble = 1
+	}
+}
+
+// hexDelete deletes the byte under the cursor
+func (s *Tab) hexDelete() {
+	if s.hexCursor < s.doc.Len() {
+		s.deleteText(s.hexCursor, 1)
+	}
+}
+
+// renderHex renders the hex view: the offset, the hex and the ASCII columns of every row
+func (s *Tab) renderHex(screen tcell.Screen, mountPoint layout.Point) layout.Size {
+	renderSize := s.GetRenderSize()
+	height := max(renderSize.Height, 1)
+	cursorRow := s.hexCursor / hexBytesPerRow
+	if cursorRow < s.hexTopRow {
+		s.hexTopRow = cursorRow
+	} else if cursorRow >= s.hexTopRow+height {
+		s.hexTopRow = cursorRow - height + 1
+	}
+
+	start := s.hexTopRow * hexBytesPerRow
+	data := s.doc.Slice(start, height*hexBytesPerRow)
+	offsets := &layout.TextBlock{Size: layout.Size{Width: hexOffsetWidth}}
+	hexes := &layout.TextBlock{Size: layout.Size{Width: hexBytesWidth}}
+	ascii := &layout.TextBlock{}
+	for row := 0; row < height && row*hexBytesPerRow <= len(data); row++ {
+		offsets.Lines = append(offsets.Lines, []layout.Span{{Text: fmt.Sprintf("%08x", start+row*hexBytesPerRow)}})
+		var hexLine, asciiLine []layout.Span
+		for col := range hexBytesPerRow {
+			i := row*hexBytesPerRow + col
+			if i > len(data) {
+				break
+			}
+			style := tcell.StyleDefault
+			if start+i == s.hexCursor {
+				style = style.Reverse(true)
+			}
+			hexText, asciiText := "  ", " "
+			if i < len(data) {
+				hexText = fmt.Sprintf("%02x", data[i])
+				asciiText = "."
+				if data[i] >= 0x20 && data[i] < 0x7f {
+					asciiText = string(rune(data[i]))
+				}
+			}
+			hexLine = append(hexLine, layout.Span{Text: hexText, Style: style}, layout.Span{Text: " "})
+			if col == hexBytesPerRow/2-1 {
+				hexLine = append(hexLine, layout.Span{Text: " "})
+			}
+			asciiLine = append(asciiLine, layout.Span{Text: asciiText, Style: style})
+		}
+		hexes.Lines = append(hexes.Lines, hexLine)
+		ascii.Lines = append(ascii.Lines, asciiLine)
+	}
+
+	row := layout.Row{Children: []layout.Element{offsets, hexes, ascii}}
+	row.SetRenderSize(renderSize)
+	row.Render(screen, mountPoint)
+
+	col := s.hexCursor % hexBytesPerRow
+	cursorX := hexOffsetWidth + col*3 + s.hexNibble
+	if col >= hexBytesPerRow/2 {
+		cursorX++
+	}
+	screen.ShowCursor(mountPoint.X+cursorX, mountPoint.Y+cursorRow-s.hexTopRow)
+	return renderSize
+}
@@ -0,0 +1,95 @@
+package editor
+
+import (
+	"bytes"
+	"github.com/test-go/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHexEditBinary(t *testing.T) {
+	content := []byte("\x7fELF\x00\x01\r\n\xff")
+	filePath := filepath.Join(t.TempDir(), "bin")
+	require.NoError(t, os.WriteFile(filePath, content, 0644))
+	tab, err := NewTabFromPath(filePath)
+	require.NoError(t, err)
+	require.True(t, tab.IsHex())
+	require.Equal(t, EncodingBinary, tab.encoding)
+
+	buf := bytes.Buffer{}
+	require.NoError(t, tab.writeTo(&buf))
+	require.Equal(t, content, buf.Bytes())
+
+	tab.MoveCursor(1, 0)
+	tab.InsertRune('4')
+	tab.InsertRune('c')
+	tab.MoveCursor(10, 0)
+	tab.BeginChange()
+	tab.InsertRune('a')
+	tab.InsertRune('B')
+	tab.EndChange()
+	buf.Reset()
+	require.NoError(t, tab.writeTo(&buf))
+	require.Equal(t, []byte("\x7fLLF\x00\x01\r\n\xff\xab"), buf.Bytes())
+
+	require.NoError(t, tab.Undo())
+	require.Equal(t, []byte("\x7fLLF\x00\x01\r\n\xff"), tab.doc.Slice(0, tab.doc.Len()))
+	require.Equal(t, 9, tab.hexCursor)
+
+	// the encoding of the bytes cannot be changed, saving keeps them as they are
+	_, err = setOptions(tab, "fenc=utf-8")
+	require.Error(t, err)
+	require.Equal(t, EncodingBinary, tab.encoding)
+	require.NoError(t, tab.Save())
+	written, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+	require.Equal(t, []byte("\x7fLLF\x00\x01\r\n\xff"), written)
+}
+
+func TestHexViewOfText(t *testing.T) {
+	content := []byte("\xff\xfea\x00b\x00\r\x00\n\x00c\x00d\x00\r\x00\n\x00")
+	filePath := filepath.Join(t.TempDir(), "text")
+	require.NoError(t, os.WriteFile(filePath, content, 0644))
+	tab, err := NewTabFromPath(filePath, "utf-16le")
+	require.NoError(t, err)
+	e := newTestEditor(t, "")
+	e.window.ReplaceActiveTab(tab)
+	tab.SetCursor(Position{Line: 1, Col: 1})
+
+	// the hex view shows the bytes of the file, the cursor on the same character
+	typeEditorKeys(e, ":hex\r")
+	require.True(t, tab.IsHex())
+	require.Equal(t, content, tab.doc.Slice(0, tab.doc.Len()))
+	require.Equal(t, 12, tab.hexCursor)
+	require.False(t, tab.Modified())
+	_, err = setOptions(tab, "fenc=utf-8")
+	require.Error(t, err)
+	require.Equal(t, "utf-16le", tab.encoding)
+
+	// the edited bytes are written as they are
+	typeEditorKeys(e, "i41\x1b")
+	require.True(t, tab.Modified())
+	typeEditorKeys(e, ":w\r")
+	require.False(t, tab.Modified())
+	written, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+	require.Equal(t, "\xff\xfea\x00b\x00\r\x00\n\x00c\x00A\x00\r\x00\n\x00", string(written))
+
+	// and decoded back to the text, as a single change
+	typeEditorKeys(e, ":hex\r")
+	require.False(t, tab.IsHex())
+	require.Equal(t, "ab\ncA", tabText(tab))
+	require.Equal(t, FileFormat{Format: FileFormatDos, BOM: true, EOL: true}, tab.fileFormat)
+	require.Equal(t, Position{Line: 1, Col: 1}, tab.GetCursor())
+	require.False(t, tab.Modified())
+	require.NoError(t, tab.Undo())
+	require.Equal(t, "ab\ncd", tabText(tab))
+
+	// a text that the encoding cannot write stays in the text view
+	tab.encoding = "iso-8859-1"
+	tab.insertText(0, []byte("世"))
+	typeEditorKeys(e, ":hex\r")
+	require.False(t, tab.IsHex())
+	require.Contains(t, GlobalState.getInfoLine(), "cannot write as iso-8859-1")
+}
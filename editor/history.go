@@ -0,0 +1,89 @@
+package editor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// maxHistory is the number of command lines kept for every prompt
+const maxHistory = 100
+
+// history holds the submitted command lines, the commands typed after : and the patterns
+// typed after / or ?, oldest first
+type history struct {
+	entries map[string][]string
+	// path is the file the history is saved to, empty to not save it
+	path string
+}
+
+func newHistory() *history {
+	return &history{entries: map[string][]string{}}
+}
+
+// historyKey returns the key of the entries of prompt, / and ? share their patterns
+func historyKey(prompt rune) string {
+	if isSearchPrompt(prompt) {
+		return "/"
+	}
+	return string(prompt)
+}
+
+// loadHistory reads the history saved in the state directory of the user
+func loadHistory() (*history, error) {
+	h := newHistory()
+	dir, err := userStateDir()
+	if err != nil {
+		return h, err
+	}
+	h.path = filepath.Join(dir, "ndditor", "history.json")
+	data, err := os.ReadFile(h.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return h, nil
+	}
+	if err != nil {
+		return h, err
+	}
+	if err = json.Unmarshal(data, &h.entries); err != nil {
+		h.entries = map[string][]string{}
+		return h, fmt.Errorf("invalid history file %s: %w", h.path, err)
+	}
+	return h, nil
+}
+
+// save writes the history to the history file
+func (h *history) save() error {
+	if h.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(h.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(h.path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(h.path, data, 0o600)
+}
+
+// add adds a line submitted after prompt and saves the history. A line already in the
+// history moves to the end.
+func (h *history) add(prompt rune, line string) error {
+	if line == "" {
+		return nil
+	}
+	key := historyKey(prompt)
+	entries := slices.DeleteFunc(h.entries[key], func(entry string) bool { return entry == line })
+	entries = append(entries, line)
+	h.entries[key] = entries[max(len(entries)-maxHistory, 0):]
+	return h.save()
+}
+
+// get returns the entries of prompt
+func (h *history) get(prompt rune) []string {
+	return h.entries[historyKey(prompt)]
+}
@@ -0,0 +1,77 @@
+package editor
+
+import (
+	"bytes"
+	"strings"
+)
+
+// shiftWidth returns the width of one level of indentation
+func shiftWidth() int {
+	if globalOptions.shiftWidth > 0 {
+		return globalOptions.shiftWidth
+	}
+	return max(globalOptions.tabStop, 1)
+}
+
+// indentWidth returns the length in bytes and the screen width of the leading
+// whitespace of line
+func indentWidth(line []byte) (length, width int) {
+	for _, b := range line {
+		switch b {
+		case ' ':
+			width++
+		case '\t':
+			width += tabWidth(width)
+		default:
+			return length, width
+		}
+		length++
+	}
+	return length, width
+}
+
+// makeIndent returns the whitespace indenting a line by width screen columns, made of
+// tabs and spaces, or only spaces with expandtab
+func makeIndent(width int) []byte {
+	if globalOptions.expandTab {
+		return bytes.Repeat([]byte{' '}, width)
+	}
+	tabStop := max(globalOptions.tabStop, 1)
+	return []byte(strings.Repeat("\t", width/tabStop) + strings.Repeat(" ", width%tabStop))
+}
+
+// ShiftLines changes the indentation of lines from to to (inclusive) by count levels
+// of shiftwidth, to the right when count is positive and to the left when negative.
+// Empty lines are left as they are. The cursor moves to the first non-blank
+// character of its line.
+func (s *Tab) ShiftLines(from, to, count int) {
+	if s.hexMode {
+		return
+	}
+	from = max(from, 0)
+	to = min(to, s.doc.LineCount()-1)
+
+	s.BeginChange()
+	for n := from; n <= to; n++ {
+		line := s.doc.Line(n)
+		if len(line) == 0 {
+			continue
+		}
+		length, width := indentWidth(line)
+		width = max(width+count*shiftWidth(), 0)
+		indent := makeIndent(width)
+		if bytes.Equal(indent, line[:length]) {
+			continue
+		}
+		start := s.doc.LineStart(n)
+		s.deleteText(start, length)
+		if len(indent) > 0 {
+			s.insertText(start, indent)
+		}
+	}
+	s.EndChange()
+
+	length, _ := indentWidth(s.doc.Line(s.lineIndex))
+	s.cursorPos.X = len(decodeRunes(s.doc.Line(s.lineIndex)[:length]))
+	s.updateWant()
+}
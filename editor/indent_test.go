@@ -0,0 +1,54 @@
+package editor
+
+import (
+	"github.com/test-go/testify/require"
+	"testing"
+)
+
+func TestTabWidth(t *testing.T) {
+	clusters := lineClusters([]rune("a\tb世\tc"))
+	require.Equal(t, []int{1, 7, 1, 2, 5, 1}, []int{
+		clusters[0].width, clusters[1].width, clusters[2].width, clusters[3].width, clusters[4].width, clusters[5].width,
+	})
+
+	tab := NewTab("", NewPieceTable([]byte("ab")))
+	tab.SetCursor(Position{Col: 1})
+	tab.InsertRune('\t')
+	require.Equal(t, "a\tb", string(tab.doc.Line(0)))
+	require.Equal(t, 8, tab.screenCol(0, 2))
+
+	globalOptions.expandTab = true
+	defer func() { globalOptions.expandTab = false }()
+	tab.SetCursor(Position{Col: 3})
+	tab.InsertRune('\t')
+	require.Equal(t, "a\tb       ", string(tab.doc.Line(0)))
+	require.Equal(t, 10, tab.GetCursor().Col)
+}
+
+func TestShiftLines(t *testing.T) {
+	defer func(ts, sw int) { globalOptions.tabStop, globalOptions.shiftWidth = ts, sw }(globalOptions.tabStop, globalOptions.shiftWidth)
+	_, err := setOptions(nil, "ts=4 sw=2")
+	require.NoError(t, err)
+
+	tab := NewTab("", NewPieceTable([]byte("  a\n\n\tb\nc")))
+	tab.ShiftLines(0, 3, 1)
+	require.Equal(t, "\ta\n\n\t  b\n  c", string(tab.doc.Slice(0, tab.doc.Len())))
+	require.Equal(t, 1, tab.GetCursor().Col)
+
+	tab.ShiftLines(0, 3, -2)
+	require.Equal(t, "a\n\n  b\nc", string(tab.doc.Slice(0, tab.doc.Len())))
+
+	require.NoError(t, tab.Undo())
+	require.Equal(t, "\ta\n\n\t  b\n  c", string(tab.doc.Slice(0, tab.doc.Len())))
+
+	_, err = setOptions(nil, "ts=0")
+	require.Error(t, err)
+	// wider tabs and indentations are refused like in vim
+	_, err = setOptions(nil, "ts=10000")
+	require.Error(t, err)
+	_, err = setOptions(nil, "sw=1000000000")
+	require.Error(t, err)
+	require.Equal(t, 2, globalOptions.shiftWidth)
+	_, err = setOptions(nil, "ts=9999")
+	require.NoError(t, err)
+}
@@ -0,0 +1,39 @@
+package layout
+
+import (
+	"github.com/mattn/go-runewidth"
+	"github.com/rivo/uniseg"
+	"iter"
+)
+
+// Graphemes iterates over the grapheme clusters (user-perceived characters) of text
+func Graphemes(text string) iter.Seq[[]rune] {
+	return func(yield func([]rune) bool) {
+		state := -1
+		var cluster string
+		for len(text) > 0 {
+			cluster, text, _, state = uniseg.FirstGraphemeClusterInString(text, state)
+			if !yield([]rune(cluster)) {
+				return
+			}
+		}
+	}
+}
+
+// ClusterWidth returns the number of screen cells used to draw a grapheme cluster.
+// The width of the first rune is used because that is how tcell lays out the cells.
+func ClusterWidth(cluster []rune) int {
+	if len(cluster) == 0 {
+		return 0
+	}
+	return max(runewidth.RuneWidth(cluster[0]), 1)
+}
+
+// StringWidth returns the number of screen cells used to draw text
+func StringWidth(text string) int {
+	width := 0
+	for cluster := range Graphemes(text) {
+		width += ClusterWidth(cluster)
+	}
+	return width
+}
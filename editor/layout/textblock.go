@@ -0,0 +1,54 @@
+package layout
+
+import (
+	"github.com/gdamore/tcell/v2"
+)
+
+var _ Element = (*TextBlock)(nil)
+
+// Span is a piece of text drawn with a single style
+type Span struct {
+	Text  string
+	Style tcell.Style
+}
+
+// TextBlock draws lines of styled spans, one line per row.
+// Lines longer than the render width are clipped instead of wrapped.
+type TextBlock struct {
+	BaseElement
+	Lines [][]Span
+	// Size original size
+	Size Size
+}
+
+// GetName returns the name of the text block
+func (b *TextBlock) GetName() string {
+	return "TextBlock"
+}
+
+// GetPreferredSize returns the preferred size of the text block
+func (b *TextBlock) GetPreferredSize() Size {
+	return b.Size
+}
+
+// Render renders the text block
+func (b *TextBlock) Render(screen tcell.Screen, mountPoint Point) Size {
+	renderSize := b.GetRenderSize()
+	for row, line := range b.Lines {
+		if row >= renderSize.Height {
+			break
+		}
+		col := 0
+		for _, span := range line {
+			for cluster := range Graphemes(span.Text) {
+				width := ClusterWidth(cluster)
+				if col+width > renderSize.Width {
+					break
+				}
+				screen.SetContent(mountPoint.X+col, mountPoint.Y+row, cluster[0], cluster[1:], span.Style)
+				col += width
+			}
+		}
+	}
+	return renderSize
+}
@@ -73,15 +73,16 @@ func drawBox(s tcell.Screen, x1, y1, x2, y2 int, style tcell.Style) {
 func drawText(s tcell.Screen, x1, y1, x2, y2 int, style tcell.Style, text string) {
 	row := y1
 	col := x1
-	for _, r := range text {
-		s.SetContent(col, row, r, nil, style)
-		col++
-		if col >= x2 {
+	for cluster := range Graphemes(text) {
+		width := ClusterWidth(cluster)
+		if col+width > x2 && col > x1 {
 			row++
 			col = x1
 		}
 		if row > y2 {
 			break
 		}
+		s.SetContent(col, row, cluster[0], cluster[1:], style)
+		col += width
 	}
 }
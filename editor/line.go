@@ -159,11 +159,12 @@ func (g *Line) Runes() iter.Seq2[int, rune] {
 	}
 }
 
-// Bytes returns the bytes representation of the line
+// Bytes returns the UTF-8 encoding of the line.
+// Runes decoded from invalid bytes are written back as the original bytes.
 func (g *Line) Bytes() []byte {
 	res := make([]byte, 0, g.Len())
 	for _, r := range g.Runes() {
-		res = append(res, byte(r))
+		res = appendRune(res, r)
 	}
 	return res
 }
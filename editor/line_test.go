@@ -76,3 +76,12 @@ func TestGapRunes(t *testing.T) {
 		{0, 'a'}, {1, 'b'}, {2, 'c'}, {3, 'd'},
 	}, collect2Entries(g.Runes()))
 }
+
+func TestLineBytes(t *testing.T) {
+	raw := []byte("Xin chào 世界 🎉 \xff\xfe tail \xe4\xb8")
+	g := NewLine(decodeRunes(raw))
+	require.Equal(t, raw, g.Bytes())
+	g.moveCursorTo(4)
+	g.Insert('ệ')
+	require.Equal(t, "Xin ệchào", string(g.Bytes()[:len("Xin ệchào")]))
+}
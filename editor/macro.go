@@ -0,0 +1,315 @@
+package editor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gdamore/tcell/v2"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxMacroDepth limits the nesting of macros replaying other macros, a macro that
+// replays itself would never stop
+const maxMacroDepth = 100
+
+// macroKey is a recorded key as saved in the macro file
+type macroKey struct {
+	Key  tcell.Key     `json:"key"`
+	Rune rune          `json:"rune,omitempty"`
+	Mod  tcell.ModMask `json:"mod,omitempty"`
+}
+
+// macros records the typed keys into a register, q{reg} records the keys typed until the
+// next q and @{reg} replays the keys of a register
+type macros struct {
+	// recording is the register being recorded, 0 when not recording, and keys the keys
+	// recorded so far
+	recording rune
+	keys      []macroKey
+	// last is the last replayed register, replayed again by @@
+	last  rune
+	depth int
+	// path is the file the registers are saved to, empty to not save them
+	path string
+}
+
+// isMacroRegister returns true when r names a register that keys can be recorded into,
+// uppercase letters append to the register of the lowercase letter. The keys recorded
+// into these registers are saved across sessions.
+func isMacroRegister(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
+}
+
+// savedRegister is a recorded macro as saved in the macro file. The text of the other
+// registers, which may be anything that was yanked or deleted, is not saved.
+type savedRegister struct {
+	Keys []macroKey `json:"keys,omitempty"`
+}
+
+// userStateDir returns the directory for the state files of the user, $XDG_STATE_HOME
+// or ~/.local/state
+func userStateDir() (string, error) {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return dir, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "state"), nil
+}
+
+// loadMacros reads the macros saved in the state directory of the user into the register
+// table
+func loadMacros() (*macros, error) {
+	m := &macros{}
+	dir, err := userStateDir()
+	if err != nil {
+		return m, err
+	}
+	m.path = filepath.Join(dir, "ndditor", "macros.json")
+	data, err := os.ReadFile(m.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return m, err
+	}
+	var saved map[string]savedRegister
+	if err = json.Unmarshal(data, &saved); err != nil {
+		return m, fmt.Errorf("invalid macro file %s: %w", m.path, err)
+	}
+	for name, reg := range saved {
+		r := []rune(name)
+		if len(r) != 1 || !isMacroRegister(r[0]) {
+			continue
+		}
+		if reg.Keys != nil {
+			registers[r[0]] = macroRegister(reg.Keys)
+		}
+	}
+	return m, nil
+}
+
+// save writes the registers holding keys recorded by q to the macro file
+func (m *macros) save() error {
+	if m.path == "" {
+		return nil
+	}
+	saved := map[string]savedRegister{}
+	for r, reg := range registers {
+		if isMacroRegister(r) && len(reg.keys) > 0 {
+			saved[string(r)] = savedRegister{Keys: reg.keys}
+		}
+	}
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(m.path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(m.path, data, 0o600)
+}
+
+// startRecording starts recording the keys into register r, an uppercase register
+// appends to the lowercase one
+func (m *macros) startRecording(r rune) {
+	m.recording = unicode.ToLower(r)
+	m.keys = nil
+	if unicode.IsUpper(r) {
+		reg, _ := getRegister(m.recording)
+		m.keys = slices.Clone(registerKeys(reg))
+	}
+}
+
+// stopRecording stops recording, stores the keys into the register and saves the registers.
+// Like in vim, the unnamed register is left alone.
+func (m *macros) stopRecording() error {
+	registers[m.recording] = macroRegister(m.keys)
+	m.recording, m.keys = 0, nil
+	return m.save()
+}
+
+// record appends a key typed while recording
+func (m *macros) record(ev *tcell.EventKey) {
+	m.keys = append(m.keys, macroKey{Key: ev.Key(), Rune: ev.Rune(), Mod: ev.Modifiers()})
+}
+
+// macroRegister returns the register holding recorded keys. Its text is the keys as typed,
+// the control keys as control characters like in vim, so that it can be put and edited.
+func macroRegister(keys []macroKey) register {
+	var text []byte
+	for _, k := range keys {
+		switch {
+		case k.Key == tcell.KeyRune:
+			text = appendRune(text, k.Rune)
+		case k.Key < 0x80:
+			// the keys of the ASCII control characters are their code
+			text = append(text, byte(k.Key))
+		default:
+			text = append(text, "<"+tcell.NewEventKey(k.Key, k.Rune, k.Mod).Name()+">"...)
+		}
+	}
+	return register{text: text, keys: keys}
+}
+
+// registerKeys returns the keys replayed from reg, the recorded keys or else the keys
+// typing its text. A line break is typed as <CR>, which runs a yanked command line.
+func registerKeys(reg register) []macroKey {
+	if reg.keys != nil {
+		return reg.keys
+	}
+	keys := make([]macroKey, 0, len(reg.text))
+	for _, r := range decodeRunes(reg.text) {
+		switch {
+		case r == '\n' || r == '\r':
+			keys = append(keys, macroKey{Key: tcell.KeyEnter})
+		case isControl(r) || r == '\t':
+			keys = append(keys, macroKey{Key: tcell.Key(r)})
+		default:
+			keys = append(keys, macroKey{Key: tcell.KeyRune, Rune: r})
+		}
+	}
+	return keys
+}
+
+// keyNames returns the keys as typed, special keys are written like <Esc> or <C-r>
+func keyNames(keys []macroKey) string {
+	var b strings.Builder
+	for _, k := range keys {
+		switch {
+		case k.Key == tcell.KeyRune:
+			b.WriteRune(k.Rune)
+		case k.Key == tcell.KeyEscape:
+			b.WriteString("<Esc>")
+		case k.Key == tcell.KeyEnter:
+			b.WriteString("<CR>")
+		case k.Key == tcell.KeyTab:
+			b.WriteString("<Tab>")
+		case k.Key == tcell.KeyBackspace || k.Key == tcell.KeyBackspace2:
+			b.WriteString("<BS>")
+		case k.Key >= tcell.KeyCtrlA && k.Key <= tcell.KeyCtrlZ:
+			b.WriteString(fmt.Sprintf("<C-%c>", 'a'+rune(k.Key-tcell.KeyCtrlA)))
+		default:
+			b.WriteString("<" + tcell.NewEventKey(k.Key, k.Rune, k.Mod).Name() + ">")
+		}
+	}
+	return b.String()
+}
+
+// parseKeyNames is the reverse of keyNames, it returns the keys written in s. The special
+// keys are written like <Esc>, <CR>, <Tab>, <S-Tab>, <BS>, <Space>, <Up> or <C-r>, and <lt>
+// is a literal <. A < that does not start a key name is a literal <.
+func parseKeyNames(s string) []macroKey {
+	var keys []macroKey
+	for len(s) > 0 {
+		if s[0] == '<' {
+			if end := strings.IndexByte(s, '>'); end > 0 {
+				if key, ok := namedKey(s[1:end]); ok {
+					keys = append(keys, key)
+					s = s[end+1:]
+					continue
+				}
+			}
+		}
+		r, size := utf8.DecodeRuneInString(s)
+		keys = append(keys, macroKey{Key: tcell.KeyRune, Rune: r})
+		s = s[size:]
+	}
+	return keys
+}
+
+// namedKey returns the key written <name>
+func namedKey(name string) (macroKey, bool) {
+	lower := strings.ToLower(name)
+	switch lower {
+	case "esc":
+		return macroKey{Key: tcell.KeyEscape}, true
+	case "cr", "enter":
+		return macroKey{Key: tcell.KeyEnter}, true
+	case "tab":
+		return macroKey{Key: tcell.KeyTab}, true
+	case "bs":
+		return macroKey{Key: tcell.KeyBackspace2}, true
+	case "s-tab":
+		return macroKey{Key: tcell.KeyBacktab}, true
+	case "up":
+		return macroKey{Key: tcell.KeyUp}, true
+	case "down":
+		return macroKey{Key: tcell.KeyDown}, true
+	case "left":
+		return macroKey{Key: tcell.KeyLeft}, true
+	case "right":
+		return macroKey{Key: tcell.KeyRight}, true
+	case "space":
+		return macroKey{Key: tcell.KeyRune, Rune: ' '}, true
+	case "lt":
+		return macroKey{Key: tcell.KeyRune, Rune: '<'}, true
+	}
+	if len(lower) == 3 && strings.HasPrefix(lower, "c-") && lower[2] >= 'a' && lower[2] <= 'z' {
+		return macroKey{Key: tcell.KeyCtrlA + tcell.Key(lower[2]-'a'), Mod: tcell.ModCtrl}, true
+	}
+	return macroKey{}, false
+}
+
+// executeMacro handles q{reg}, q, @{reg} and @@. The keys of a replayed macro go through
+// handleKey like typed keys, count times.
+func (s *Editor) executeMacro(keys string, count int) {
+	runes := []rune(keys)
+	if runes[0] == 'q' {
+		if len(runes) == 1 {
+			if err := s.macros.stopRecording(); err != nil {
+				GlobalState.ToastMessage(fmt.Sprintf("err: %v", err))
+			}
+			GlobalState.SetRecording(0)
+			return
+		}
+		s.macros.startRecording(runes[1])
+		GlobalState.SetRecording(s.macros.recording)
+		return
+	}
+
+	r := unicode.ToLower(runes[1])
+	if r == '@' {
+		r = s.macros.last
+		if r == 0 {
+			GlobalState.ToastMessage("no previously used register")
+			return
+		}
+	}
+	if s.macros.depth >= maxMacroDepth {
+		GlobalState.ToastMessage("macro recursion too deep")
+		return
+	}
+	s.macros.last = r
+	s.macros.depth++
+	GlobalState.BeginReplay()
+	defer func() {
+		GlobalState.EndReplay()
+		s.macros.depth--
+	}()
+	reg, _ := getRegister(r)
+	keysToReplay := registerKeys(reg)
+	for range max(count, 1) {
+		for _, k := range keysToReplay {
+			s.handleKey(tcell.NewEventKey(k.Key, k.Rune, k.Mod))
+		}
+	}
+}
+
+// showRegisters executes :reg, listing the registers
+func (s *Editor) showRegisters() {
+	lines := registerLines()
+	if len(lines) == 0 {
+		GlobalState.InfoMessage("no registers")
+		return
+	}
+	GlobalState.ShowLines(append([]string{"--- Registers ---"}, lines...))
+}
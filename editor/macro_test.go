@@ -0,0 +1,99 @@
+package editor
+
+import (
+	"github.com/gdamore/tcell/v2"
+	"github.com/test-go/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestEditor creates an editor on a simulation screen with a tab of text, its macros
+// are saved in a temporary state directory
+func newTestEditor(t *testing.T, text string) *Editor {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	registers = map[rune]register{}
+	InitEventEmitter()
+	screen := tcell.NewSimulationScreen("")
+	require.NoError(t, screen.Init())
+	t.Cleanup(screen.Fini)
+	e := NewEditor(screen)
+	e.init(nil)
+	e.window.ReplaceActiveTab(NewTab("", NewPieceTable([]byte(text))))
+	return e
+}
+
+// typeEditorKeys types keys into the editor like the event consumer.
+// \x1b and \r stand for escape and enter.
+func typeEditorKeys(e *Editor, keys string) {
+	for _, r := range keys {
+		ev := tcell.NewEventKey(tcell.KeyRune, r, tcell.ModNone)
+		switch r {
+		case '\x1b':
+			ev = tcell.NewEventKey(tcell.KeyEscape, 0, tcell.ModNone)
+		case '\r':
+			ev = tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone)
+		}
+		e.handleTypedKey(ev)
+	}
+}
+
+func TestMacroRecordAndReplay(t *testing.T) {
+	e := newTestEditor(t, "a\nb\nc\nd\ne\nf")
+	tab := e.getActiveTab()
+	typeEditorKeys(e, "qa0iX\x1bjq")
+	require.Equal(t, "Xa\nb\nc\nd\ne\nf", tabText(tab))
+	require.Equal(t, "0iX\x1bj", string(registers['a'].text))
+	require.Contains(t, registerLines(), `"a   0iX^[j`)
+
+	typeEditorKeys(e, "2@a")
+	require.Equal(t, "Xa\nXb\nXc\nd\ne\nf", tabText(tab))
+	typeEditorKeys(e, "@@")
+	require.Equal(t, "Xa\nXb\nXc\nXd\ne\nf", tabText(tab))
+
+	// an uppercase register appends to the macro
+	typeEditorKeys(e, "qA0xq")
+	typeEditorKeys(e, "@a")
+	require.Equal(t, "Xa\nXb\nXc\nXd\nX\n", tabText(tab))
+	_, err := os.Stat(filepath.Join(os.Getenv("XDG_STATE_HOME"), "ndditor", "macros.json"))
+	require.NoError(t, err)
+
+	// a macro replaying itself stops
+	typeEditorKeys(e, "qbq")
+	registers['b'] = register{text: []byte("@b")}
+	typeEditorKeys(e, "@b")
+}
+
+func TestMacroPersistence(t *testing.T) {
+	e := newTestEditor(t, "")
+	typeEditorKeys(e, "qzix\x1bq")
+	typeEditorKeys(e, `"yyy`)
+	require.NoError(t, e.macros.save())
+	recorded, yanked := registers['z'], registers['y']
+	require.Len(t, recorded.keys, 3)
+
+	require.Equal(t, "x\n", string(yanked.text))
+
+	// only the recorded keys are saved, not the yanked or deleted text
+	registers = map[rune]register{}
+	_, err := loadMacros()
+	require.NoError(t, err)
+	require.Equal(t, recorded, registers['z'])
+	require.Equal(t, register{}, registers['y'])
+	require.Equal(t, register{}, registers['"'])
+}
+
+func TestMacroRegisters(t *testing.T) {
+	e := newTestEditor(t, "1\n:1s/1/one/")
+	tab := e.getActiveTab()
+	// yanked text is replayed, the line break runs the command line
+	typeEditorKeys(e, `j"ayy@a`)
+	require.Equal(t, "one\n:1s/1/one/", tabText(tab))
+
+	// recorded keys are put as text, the unnamed register is left alone
+	typeEditorKeys(e, "qb0i!\x1bq")
+	require.Equal(t, "!one\n:1s/1/one/", tabText(tab))
+	require.Equal(t, ":1s/1/one/\n", string(registers['"'].text))
+	typeEditorKeys(e, `"bp`)
+	require.Equal(t, "!o0i!\x1bne\n:1s/1/one/", tabText(tab))
+}
@@ -0,0 +1,87 @@
+package editor
+
+import "bytes"
+
+// isMarkName returns true when r names a mark that m can set, < and > are the start and
+// the end of the last selection and are set when visual mode ends
+func isMarkName(r rune) bool {
+	return r >= 'a' && r <= 'z'
+}
+
+// setMark sets the mark r at pos
+func (s *Tab) setMark(r rune, pos Position) {
+	if s.marks == nil {
+		s.marks = map[rune]Position{}
+	}
+	s.marks[r] = pos
+}
+
+// getMark returns the position of the mark r
+func (s *Tab) getMark(r rune) (Position, bool) {
+	pos, ok := s.marks[r]
+	if !ok {
+		return Position{}, false
+	}
+	pos.Line = min(pos.Line, s.doc.LineCount()-1)
+	return pos, true
+}
+
+// adjustMarks moves the marks after an edit of the text at offset, text is the inserted
+// or the deleted text. The marks below the edit follow their line, the marks on deleted
+// lines move to the line of the edit.
+func (s *Tab) adjustMarks(offset int, text []byte, insert bool) {
+	lines := bytes.Count(text, []byte{'\n'})
+	if lines == 0 || (len(s.marks) == 0 && len(s.globalLines) == 0) {
+		return
+	}
+	line := s.doc.LineAt(offset)
+	s.adjustGlobalLines(offset, line, lines, insert)
+	for r, pos := range s.marks {
+		switch {
+		case pos.Line <= line:
+			continue
+		case insert:
+			pos.Line += lines
+		case pos.Line <= line+lines:
+			pos = Position{Line: line}
+		default:
+			pos.Line -= lines
+		}
+		s.marks[r] = pos
+	}
+}
+
+// markMotion moves to the mark typed as arg, to the first non-blank character of its
+// line with linewise
+func markMotion(linewise bool) motionFunc {
+	return func(s *Tab, _ Position, _ int, arg rune) (Position, bool) {
+		pos, ok := s.getMark(arg)
+		if ok && linewise {
+			pos.Col = s.indentCol(pos.Line)
+		}
+		return pos, ok
+	}
+}
+
+// adjustGlobalLines moves the lines marked by :g after lines were inserted or deleted at
+// offset in line. An edit at the start of a line moves or deletes that line too, the
+// marked lines that are deleted become -1.
+func (s *Tab) adjustGlobalLines(offset, line, lines int, insert bool) {
+	first := line + 1
+	if offset == s.doc.LineStart(line) {
+		first = line
+	}
+	for i, marked := range s.globalLines {
+		switch {
+		case marked < first:
+			continue
+		case insert:
+			marked += lines
+		case marked < first+lines:
+			marked = -1
+		default:
+			marked -= lines
+		}
+		s.globalLines[i] = marked
+	}
+}
@@ -0,0 +1,553 @@
+package editor
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// motionFunc returns the target of a motion from pos. count is the count typed before
+// the motion, 0 when none was typed. arg is the character typed after f, t, F and T.
+// ok is false when the motion fails, e.g. when there is no match.
+type motionFunc func(s *Tab, pos Position, count int, arg rune) (target Position, ok bool)
+
+// motion is a cursor movement of view mode, which operators also use to find the text
+// they act on
+type motion struct {
+	target motionFunc
+	// linewise motions make operators act on whole lines
+	linewise bool
+	// inclusive motions make operators include the character at the target
+	inclusive bool
+	// keepColumn motions move between lines and keep the wanted screen column
+	keepColumn bool
+	// toEnd motions make the cursor stay at the end of the lines it moves to next
+	toEnd bool
+	// needsArg motions are followed by a character, like f
+	needsArg bool
+}
+
+var motions = map[string]*motion{
+	"h":  {target: moveLeft},
+	"l":  {target: moveRight},
+	"j":  {target: moveDown, linewise: true, keepColumn: true},
+	"k":  {target: moveUp, linewise: true, keepColumn: true},
+	"gj": {target: moveRowDown, keepColumn: true},
+	"gk": {target: moveRowUp, keepColumn: true},
+	"w":  {target: wordForward(false)},
+	"W":  {target: wordForward(true)},
+	"b":  {target: wordBackward(false)},
+	"B":  {target: wordBackward(true)},
+	"e":  {target: wordEnd(false), inclusive: true},
+	"E":  {target: wordEnd(true), inclusive: true},
+	"0":  {target: lineStart},
+	"^":  {target: firstNonBlank},
+	"$":  {target: lineEnd, inclusive: true, toEnd: true},
+	"gg": {target: gotoLine(false), linewise: true},
+	"G":  {target: gotoLine(true), linewise: true},
+	"f":  {target: findCharMotion('f'), inclusive: true, needsArg: true},
+	"F":  {target: findCharMotion('F'), needsArg: true},
+	"t":  {target: findCharMotion('t'), inclusive: true, needsArg: true},
+	"T":  {target: findCharMotion('T'), needsArg: true},
+	";":  {target: repeatFindChar(false)},
+	",":  {target: repeatFindChar(true)},
+	"%":  {target: matchPair, inclusive: true},
+	"}":  {target: paragraphForward},
+	"{":  {target: paragraphBackward},
+	"H":  {target: screenLine('H'), linewise: true},
+	"M":  {target: screenLine('M'), linewise: true},
+	"L":  {target: screenLine('L'), linewise: true},
+	"n":  {target: searchMotion(false)},
+	"N":  {target: searchMotion(true)},
+	"*":  {target: searchWord(false)},
+	"#":  {target: searchWord(true)},
+	"'":  {target: markMotion(true), linewise: true, needsArg: true},
+	"`":  {target: markMotion(false), needsArg: true},
+}
+
+const (
+	// keysInvalid means the keys are not a command
+	keysInvalid = iota
+	// keysPending means the keys are the beginning of a command
+	keysPending
+	// keysComplete means the keys are a whole command
+	keysComplete
+)
+
+// parseMotion looks up the motion typed as keys and the character typed after it.
+// ; and , resolve to the motion of the last f, t, F or T so that operators know
+// whether it is inclusive.
+func parseMotion(keys string) (*motion, rune, int) {
+	if m, ok := motions[keys]; ok {
+		if m.needsArg {
+			return nil, 0, keysPending
+		}
+		if keys == ";" || keys == "," {
+			kind := lastCharSearch.kind
+			if keys == "," {
+				kind = reversedCharSearch[kind]
+			}
+			last, ok := motions[string(kind)]
+			if !ok {
+				return nil, 0, keysInvalid
+			}
+			return &motion{target: m.target, inclusive: last.inclusive}, 0, keysComplete
+		}
+		return m, 0, keysComplete
+	}
+	runes := []rune(keys)
+	if len(runes) == 2 {
+		if m, ok := motions[string(runes[0])]; ok && m.needsArg {
+			return m, runes[1], keysComplete
+		}
+	}
+	for name := range motions {
+		if strings.HasPrefix(name, keys) {
+			return nil, 0, keysPending
+		}
+	}
+	return nil, 0, keysInvalid
+}
+
+// applyMotion moves the cursor to the target of m. It returns false when the motion fails.
+func (s *Tab) applyMotion(m *motion, count int, arg rune) bool {
+	if s.hexMode {
+		return false
+	}
+	pos, ok := m.target(s, s.GetCursor(), count, arg)
+	if !ok {
+		return false
+	}
+	want := s.want
+	s.SetCursor(Position{Line: pos.Line, Col: s.moveCol(pos.Line, pos.Col, 0)})
+	if m.keepColumn {
+		s.want = want
+	} else if m.toEnd {
+		s.want.end = true
+	}
+	return true
+}
+
+func moveLeft(s *Tab, pos Position, count int, _ rune) (Position, bool) {
+	col := s.moveCol(pos.Line, pos.Col, -max(count, 1))
+	return Position{Line: pos.Line, Col: col}, col != pos.Col
+}
+
+func moveRight(s *Tab, pos Position, count int, _ rune) (Position, bool) {
+	col := s.moveCol(pos.Line, pos.Col, max(count, 1))
+	return Position{Line: pos.Line, Col: col}, col != pos.Col
+}
+
+func moveDown(s *Tab, pos Position, count int, _ rune) (Position, bool) {
+	return s.lineTarget(pos.Line + max(count, 1))
+}
+
+func moveUp(s *Tab, pos Position, count int, _ rune) (Position, bool) {
+	return s.lineTarget(pos.Line - max(count, 1))
+}
+
+// lineTarget returns the position on line n at the wanted screen column, or at its last
+// character after $. ok is false when n is not a line of the document.
+func (s *Tab) lineTarget(n int) (Position, bool) {
+	if n < 0 || n >= s.doc.LineCount() {
+		return Position{}, false
+	}
+	if s.want.end {
+		return Position{Line: n, Col: s.lastCol(n)}, true
+	}
+	return Position{Line: n, Col: s.colAtScreen(n, s.want.lineX)}, true
+}
+
+func moveRowDown(s *Tab, pos Position, count int, _ rune) (Position, bool) {
+	return s.rowTarget(pos, max(count, 1))
+}
+
+func moveRowUp(s *Tab, pos Position, count int, _ rune) (Position, bool) {
+	return s.rowTarget(pos, -max(count, 1))
+}
+
+func lineStart(_ *Tab, pos Position, _ int, _ rune) (Position, bool) {
+	return Position{Line: pos.Line}, true
+}
+
+func firstNonBlank(s *Tab, pos Position, _ int, _ rune) (Position, bool) {
+	return Position{Line: pos.Line, Col: s.indentCol(pos.Line)}, true
+}
+
+// lineEnd moves to the last character of the line, count-1 lines below
+func lineEnd(s *Tab, pos Position, count int, _ rune) (Position, bool) {
+	n := pos.Line + max(count, 1) - 1
+	if n >= s.doc.LineCount() {
+		return Position{}, false
+	}
+	return Position{Line: n, Col: s.lastCol(n)}, true
+}
+
+// gotoLine moves to the first non-blank character of line count. Without a count it
+// moves to the last line, or to the first one for gg.
+func gotoLine(last bool) motionFunc {
+	return func(s *Tab, _ Position, count int, _ rune) (Position, bool) {
+		n := count - 1
+		if count == 0 && last {
+			n = s.doc.LineCount() - 1
+		}
+		n = min(max(n, 0), s.doc.LineCount()-1)
+		return Position{Line: n, Col: s.indentCol(n)}, true
+	}
+}
+
+// indentCol returns the column of the first non-blank character of line n
+func (s *Tab) indentCol(n int) int {
+	length, _ := indentWidth(s.doc.Line(n))
+	return length
+}
+
+// screenLine moves to the first non-blank character of a line shown in the tab:
+// the count-th line from the top for H, from the bottom for L, or the middle line for M
+func screenLine(kind rune) motionFunc {
+	return func(s *Tab, _ Position, count int, _ rune) (Position, bool) {
+		first, last := s.visibleLines()
+		var n int
+		switch kind {
+		case 'H':
+			n = min(first+max(count, 1)-1, last)
+		case 'L':
+			n = max(last-max(count, 1)+1, first)
+		default:
+			n = (first + last) / 2
+		}
+		return Position{Line: n, Col: s.indentCol(n)}, true
+	}
+}
+
+// visibleLines returns the first and the last line that are entirely shown in the tab
+func (s *Tab) visibleLines() (first, last int) {
+	l := s.lineLayout()
+	height := max(s.GetRenderSize().Height, 1)
+	first, last = s.topLine, s.topLine
+	if s.topRow > 0 && first+1 < s.doc.LineCount() {
+		first++
+	}
+	rows := -s.topRow
+	for n := s.topLine; n < s.doc.LineCount(); n++ {
+		rows += s.rowCount(l, n)
+		if rows > height {
+			break
+		}
+		last = n
+	}
+	return first, max(first, last)
+}
+
+// charClass returns the class of a character for word motions: 0 for blanks, 1 for
+// punctuation and 2 for word characters. For WORD motions every non-blank is in class 1.
+func charClass(r rune, bigWord bool) int {
+	switch {
+	case r == ' ' || r == '\t' || r == '\n':
+		return 0
+	case bigWord:
+		return 1
+	case r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r):
+		return 2
+	}
+	return 1
+}
+
+// walkerWindow is the number of bytes around a textWalker that are decoded at once
+const walkerWindow = 4096
+
+// textWalker walks the characters of a tab across lines, the end of every line is
+// a '\n' character. The characters are decoded from a window of the document around
+// the walker, so only the part of a long line that is walked over is read.
+type textWalker struct {
+	s   *Tab
+	pos Position
+	// offset is the offset of the character at pos, end the offset of the end of its line
+	offset int
+	end    int
+	// window holds the bytes of the document from windowStart
+	window      []byte
+	windowStart int
+}
+
+func (s *Tab) newWalker(pos Position) *textWalker {
+	w := &textWalker{s: s}
+	w.setLine(pos.Line)
+	for w.pos.Col < pos.Col && w.offset < w.end {
+		w.next()
+	}
+	return w
+}
+
+func (w *textWalker) setLine(n int) {
+	w.pos = Position{Line: n}
+	w.offset, w.end = w.s.doc.LineStart(n), w.s.doc.LineEnd(n)
+}
+
+// load reads the window of the document around offset
+func (w *textWalker) load(offset int) {
+	w.windowStart = max(offset-walkerWindow/2, 0)
+	w.window = w.s.doc.Slice(w.windowStart, min(walkerWindow, w.s.doc.Len()-w.windowStart))
+}
+
+// runeAt returns the character at offset and its size
+func (w *textWalker) runeAt(offset int) (rune, int) {
+	i := offset - w.windowStart
+	if i < 0 || (i+utf8.UTFMax > len(w.window) && w.windowStart+len(w.window) < w.s.doc.Len()) {
+		w.load(offset)
+		i = offset - w.windowStart
+	}
+	return decodeRune(w.window[i:])
+}
+
+// runeBefore returns the character before offset and its size
+func (w *textWalker) runeBefore(offset int) (rune, int) {
+	i := offset - w.windowStart
+	if i > len(w.window) || (i < utf8.UTFMax && w.windowStart > 0) {
+		w.load(offset)
+		i = offset - w.windowStart
+	}
+	return decodeLastRune(w.window[:i])
+}
+
+// char returns the character at the position of the walker
+func (w *textWalker) char() rune {
+	if w.offset >= w.end {
+		return '\n'
+	}
+	r, _ := w.runeAt(w.offset)
+	return r
+}
+
+// emptyLine returns true when the walker is on an empty line
+func (w *textWalker) emptyLine() bool {
+	return w.s.isEmptyLine(w.pos.Line)
+}
+
+// next moves to the next character, it returns false at the end of the document
+func (w *textWalker) next() bool {
+	if w.offset < w.end {
+		_, size := w.runeAt(w.offset)
+		w.offset += size
+		w.pos.Col++
+		return true
+	}
+	if w.pos.Line+1 >= w.s.doc.LineCount() {
+		return false
+	}
+	w.setLine(w.pos.Line + 1)
+	return true
+}
+
+// prev moves to the previous character, it returns false at the start of the document
+func (w *textWalker) prev() bool {
+	if w.pos.Col > 0 {
+		_, size := w.runeBefore(w.offset)
+		w.offset -= size
+		w.pos.Col--
+		return true
+	}
+	if w.pos.Line == 0 {
+		return false
+	}
+	n := w.pos.Line - 1
+	w.setLine(n)
+	w.pos.Col, w.offset = w.s.lineLen(n), w.end
+	return true
+}
+
+// wordForward moves to the start of the next word, empty lines count as words
+func wordForward(bigWord bool) motionFunc {
+	return func(s *Tab, pos Position, count int, _ rune) (Position, bool) {
+		w := s.newWalker(pos)
+		for range max(count, 1) {
+			class := charClass(w.char(), bigWord)
+			if !w.next() {
+				break
+			}
+			for class != 0 && charClass(w.char(), bigWord) == class && w.next() {
+			}
+			for charClass(w.char(), bigWord) == 0 && !w.emptyLine() && w.next() {
+			}
+		}
+		return w.pos, w.pos != pos
+	}
+}
+
+// wordEnd moves to the end of the word, or of the next one when already at its end
+func wordEnd(bigWord bool) motionFunc {
+	return func(s *Tab, pos Position, count int, _ rune) (Position, bool) {
+		w := s.newWalker(pos)
+		for range max(count, 1) {
+			if !w.next() {
+				break
+			}
+			for charClass(w.char(), bigWord) == 0 && w.next() {
+			}
+			class := charClass(w.char(), bigWord)
+			for w.next() {
+				if charClass(w.char(), bigWord) != class {
+					w.prev()
+					break
+				}
+			}
+		}
+		return w.pos, w.pos != pos
+	}
+}
+
+// wordBackward moves to the start of the word, or of the previous one when already at its start
+func wordBackward(bigWord bool) motionFunc {
+	return func(s *Tab, pos Position, count int, _ rune) (Position, bool) {
+		w := s.newWalker(pos)
+		for range max(count, 1) {
+			if !w.prev() {
+				break
+			}
+			for charClass(w.char(), bigWord) == 0 && !w.emptyLine() && w.prev() {
+			}
+			class := charClass(w.char(), bigWord)
+			for class != 0 && w.prev() {
+				if charClass(w.char(), bigWord) != class {
+					w.next()
+					break
+				}
+			}
+		}
+		return w.pos, w.pos != pos
+	}
+}
+
+// lastCharSearch is the last f, t, F or T search, repeated by ; and ,
+var lastCharSearch struct {
+	kind rune
+	char rune
+}
+
+func findCharMotion(kind rune) motionFunc {
+	return func(s *Tab, pos Position, count int, arg rune) (Position, bool) {
+		lastCharSearch.kind = kind
+		lastCharSearch.char = arg
+		return findChar(s, pos, count, kind, arg, false)
+	}
+}
+
+// reversedCharSearch maps every character search to the one in the opposite direction
+var reversedCharSearch = map[rune]rune{'f': 'F', 'F': 'f', 't': 'T', 'T': 't'}
+
+// repeatFindChar repeats the last f, t, F or T search, in the opposite direction for ,
+func repeatFindChar(reverse bool) motionFunc {
+	return func(s *Tab, pos Position, count int, _ rune) (Position, bool) {
+		kind := lastCharSearch.kind
+		if kind == 0 {
+			return Position{}, false
+		}
+		if reverse {
+			kind = reversedCharSearch[kind]
+		}
+		return findChar(s, pos, count, kind, lastCharSearch.char, true)
+	}
+}
+
+// findChar finds the count-th occurrence of char in the line of pos, after pos for f
+// and t, before it for F and T. t and T stop next to the character. A repeated t or T
+// skips the character right next to the cursor, otherwise it would not move.
+func findChar(s *Tab, pos Position, count int, kind, char rune, repeat bool) (Position, bool) {
+	w := s.newWalker(pos)
+	move, back := w.next, w.prev
+	if kind == 'F' || kind == 'T' {
+		move, back = w.prev, w.next
+	}
+	// step moves to the next character in the direction of the search, without leaving the line
+	step := func() bool {
+		return move() && w.pos.Line == pos.Line && w.char() != '\n'
+	}
+	till := kind == 't' || kind == 'T'
+	if till && repeat && !step() {
+		return Position{}, false
+	}
+	for count = max(count, 1); count > 0; {
+		if !step() {
+			return Position{}, false
+		}
+		if w.char() == char {
+			count--
+		}
+	}
+	if till {
+		back()
+	}
+	return w.pos, true
+}
+
+// bracketPairs maps every bracket to its counterpart
+var bracketPairs = map[rune]rune{'(': ')', ')': '(', '[': ']', ']': '[', '{': '}', '}': '{'}
+
+// matchPair moves from the first bracket at or after the cursor in the line to its counterpart
+func matchPair(s *Tab, pos Position, _ int, _ rune) (Position, bool) {
+	w := s.newWalker(pos)
+	for bracketPairs[w.char()] == 0 {
+		if w.char() == '\n' {
+			return Position{}, false
+		}
+		w.next()
+	}
+	open := w.char()
+	match := bracketPairs[open]
+	move := w.next
+	if strings.ContainsRune(")]}", open) {
+		move = w.prev
+	}
+	depth := 0
+	for move() {
+		switch w.char() {
+		case open:
+			depth++
+		case match:
+			if depth == 0 {
+				return w.pos, true
+			}
+			depth--
+		}
+	}
+	return Position{}, false
+}
+
+// isEmptyLine returns true when line n has no character
+func (s *Tab) isEmptyLine(n int) bool {
+	return s.doc.LineStart(n) == s.doc.LineEnd(n)
+}
+
+// paragraphForward moves to the next empty line after a paragraph, or to the end of the document
+func paragraphForward(s *Tab, pos Position, count int, _ rune) (Position, bool) {
+	last := s.doc.LineCount() - 1
+	n := pos.Line
+	for range max(count, 1) {
+		for n < last && s.isEmptyLine(n) {
+			n++
+		}
+		for n < last && !s.isEmptyLine(n) {
+			n++
+		}
+	}
+	target := Position{Line: n}
+	if !s.isEmptyLine(n) {
+		target.Col = s.lineLen(n)
+	}
+	return target, target != pos
+}
+
+// paragraphBackward moves to the previous empty line before a paragraph, or to the start of the document
+func paragraphBackward(s *Tab, pos Position, count int, _ rune) (Position, bool) {
+	n := pos.Line
+	for range max(count, 1) {
+		for n > 0 && s.isEmptyLine(n) {
+			n--
+		}
+		for n > 0 && !s.isEmptyLine(n) {
+			n--
+		}
+	}
+	target := Position{Line: n}
+	return target, target != pos
+}
@@ -0,0 +1,122 @@
+package editor
+
+import (
+	"github.com/dangdungcntt/ndditor/editor/layout"
+	"github.com/test-go/testify/require"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// motionTarget returns the target of the motion typed as keys from pos
+func motionTarget(t *testing.T, tab *Tab, pos Position, count int, keys string) Position {
+	m, arg, status := parseMotion(keys)
+	require.Equal(t, keysComplete, status, keys)
+	tab.SetCursor(pos)
+	require.True(t, tab.applyMotion(m, count, arg), keys)
+	return tab.GetCursor()
+}
+
+func TestWordMotions(t *testing.T) {
+	tab := NewTab("", NewPieceTable([]byte("foo.bar  baz\n\n  qux-quux")))
+	require.Equal(t, Position{Line: 0, Col: 3}, motionTarget(t, tab, Position{}, 0, "w"))
+	require.Equal(t, Position{Line: 0, Col: 9}, motionTarget(t, tab, Position{}, 0, "W"))
+	require.Equal(t, Position{Line: 1, Col: 0}, motionTarget(t, tab, Position{Col: 9}, 0, "w"))
+	require.Equal(t, Position{Line: 2, Col: 2}, motionTarget(t, tab, Position{Line: 1}, 0, "w"))
+	require.Equal(t, Position{Line: 2, Col: 5}, motionTarget(t, tab, Position{Line: 1}, 2, "w"))
+	require.Equal(t, Position{Line: 0, Col: 2}, motionTarget(t, tab, Position{}, 0, "e"))
+	require.Equal(t, Position{Line: 0, Col: 6}, motionTarget(t, tab, Position{}, 0, "E"))
+	require.Equal(t, Position{Line: 2, Col: 4}, motionTarget(t, tab, Position{Col: 11}, 0, "e"))
+	require.Equal(t, Position{Line: 1, Col: 0}, motionTarget(t, tab, Position{Line: 2, Col: 2}, 0, "b"))
+	require.Equal(t, Position{Line: 0, Col: 4}, motionTarget(t, tab, Position{Col: 9}, 0, "b"))
+	require.Equal(t, Position{Line: 0, Col: 0}, motionTarget(t, tab, Position{Col: 9}, 0, "B"))
+}
+
+func TestLineMotions(t *testing.T) {
+	tab := NewTab("", NewPieceTable([]byte("  one\ntwo (a [b] c)\n\tthree\n\nfour")))
+	tab.SetRenderSize(layout.Size{Width: 20, Height: 10})
+	require.Equal(t, Position{Line: 0, Col: 0}, motionTarget(t, tab, Position{Col: 3}, 0, "0"))
+	require.Equal(t, Position{Line: 0, Col: 2}, motionTarget(t, tab, Position{Col: 4}, 0, "^"))
+	require.Equal(t, Position{Line: 1, Col: 12}, motionTarget(t, tab, Position{}, 2, "$"))
+	require.Equal(t, Position{Line: 4, Col: 0}, motionTarget(t, tab, Position{}, 0, "G"))
+	require.Equal(t, Position{Line: 2, Col: 1}, motionTarget(t, tab, Position{}, 3, "G"))
+	require.Equal(t, Position{Line: 0, Col: 2}, motionTarget(t, tab, Position{Line: 3}, 0, "gg"))
+	require.Equal(t, Position{Line: 1, Col: 12}, motionTarget(t, tab, Position{Line: 1}, 0, "%"))
+	require.Equal(t, Position{Line: 1, Col: 4}, motionTarget(t, tab, Position{Line: 1, Col: 12}, 0, "%"))
+	require.Equal(t, Position{Line: 1, Col: 9}, motionTarget(t, tab, Position{Line: 1, Col: 6}, 0, "%"))
+	require.Equal(t, Position{Line: 3, Col: 0}, motionTarget(t, tab, Position{}, 0, "}"))
+	require.Equal(t, Position{Line: 4, Col: 4}, motionTarget(t, tab, Position{}, 2, "}"))
+	require.Equal(t, Position{Line: 0, Col: 0}, motionTarget(t, tab, Position{Line: 2}, 0, "{"))
+	require.Equal(t, Position{Line: 4, Col: 0}, motionTarget(t, tab, Position{}, 0, "L"))
+	require.Equal(t, Position{Line: 2, Col: 1}, motionTarget(t, tab, Position{}, 0, "M"))
+	require.Equal(t, Position{Line: 1, Col: 0}, motionTarget(t, tab, Position{}, 2, "H"))
+
+	// j and k keep the screen column, tabs included
+	require.Equal(t, Position{Line: 1, Col: 4}, motionTarget(t, tab, Position{Col: 4}, 0, "j"))
+	require.Equal(t, Position{Line: 2, Col: 0}, motionTarget(t, tab, Position{Line: 1, Col: 4}, 0, "j"))
+	tab.applyMotion(motions["j"], 0, 0)
+	tab.applyMotion(motions["j"], 0, 0)
+	require.Equal(t, Position{Line: 4, Col: 4}, tab.GetCursor())
+	_, ok := motions["k"].target(tab, Position{}, 0, 0)
+	require.False(t, ok)
+}
+
+func TestFindCharMotions(t *testing.T) {
+	tab := NewTab("", NewPieceTable([]byte("a,c,c,c")))
+	require.Equal(t, Position{Col: 3}, motionTarget(t, tab, Position{}, 2, "f,"))
+	require.Equal(t, Position{Col: 5}, motionTarget(t, tab, Position{Col: 3}, 0, ";"))
+	require.Equal(t, Position{Col: 1}, motionTarget(t, tab, Position{Col: 3}, 0, ","))
+	require.Equal(t, Position{Col: 1}, motionTarget(t, tab, Position{}, 0, "tc"))
+	require.Equal(t, Position{Col: 3}, motionTarget(t, tab, Position{Col: 1}, 0, ";"))
+	require.Equal(t, Position{Col: 5}, motionTarget(t, tab, Position{Col: 6}, 0, "Tc"))
+
+	m, _, _ := parseMotion("Tc")
+	require.False(t, m.inclusive)
+	m, _, _ = parseMotion(",")
+	require.True(t, m.inclusive)
+	_, ok := findChar(tab, Position{}, 0, 'f', 'x', false)
+	require.False(t, ok)
+	_, _, status := parseMotion("f")
+	require.Equal(t, keysPending, status)
+}
+
+func TestLongLineMotions(t *testing.T) {
+	// words of multi-byte and invalid characters cross the windows the walker decodes
+	word := "é\xff€x"
+	line := strings.Repeat(word+" ", 5000)
+	tab := NewTab("", NewPieceTable([]byte("a\n"+line+"\nb")))
+	runes := slices.Collect(tab.lineRunes(1))
+	w := tab.newWalker(Position{Line: 1})
+	for col, r := range runes {
+		require.Equal(t, Position{Line: 1, Col: col}, w.pos)
+		require.Equal(t, r, w.char())
+		require.True(t, w.next())
+	}
+	require.Equal(t, '\n', w.char())
+	for col := len(runes) - 1; col >= 0; col-- {
+		require.True(t, w.prev())
+		require.Equal(t, runes[col], w.char(), col)
+	}
+
+	require.Equal(t, Position{Line: 1, Col: 4000}, motionTarget(t, tab, Position{Line: 1, Col: 3998}, 0, "w"))
+	require.Equal(t, Position{Line: 1, Col: 3995}, motionTarget(t, tab, Position{Line: 1, Col: 4000}, 0, "B"))
+	require.Equal(t, Position{Line: 2}, motionTarget(t, tab, Position{Line: 1, Col: len(runes) - 2}, 0, "w"))
+	require.Equal(t, Position{Line: 1, Col: len(runes) - 2}, motionTarget(t, tab, Position{Line: 2}, 0, "b"))
+	require.Equal(t, Position{Line: 1, Col: 4003}, motionTarget(t, tab, Position{Line: 1, Col: 3999}, 0, "fx"))
+	require.Equal(t, Position{Line: 1, Col: 3998}, motionTarget(t, tab, Position{Line: 1, Col: 4000}, 0, "T€"))
+}
+
+func TestVerticalMotionsOnWrappedLines(t *testing.T) {
+	text := strings.Repeat("a", 50) + "\n" + strings.Repeat("b", 50) + "\n\tc" + strings.Repeat("d", 40)
+	tab := NewTab("", NewPieceTable([]byte(text)))
+	tab.SetRenderSize(layout.Size{Width: 20, Height: 10})
+
+	// j and k keep the column in the whole line, gj and gk the column in the row
+	require.Equal(t, Position{Line: 1, Col: 25}, motionTarget(t, tab, Position{Col: 25}, 0, "j"))
+	require.Equal(t, Position{Line: 0, Col: 25}, motionTarget(t, tab, Position{Line: 1, Col: 25}, 0, "k"))
+	require.Equal(t, Position{Line: 0, Col: 45}, motionTarget(t, tab, Position{Col: 25}, 0, "gj"))
+	require.Equal(t, Position{Line: 2, Col: 18}, motionTarget(t, tab, Position{Line: 1, Col: 25}, 0, "j"))
+	m, _, _ := parseMotion("k")
+	require.True(t, tab.applyMotion(m, 0, 0))
+	require.Equal(t, Position{Line: 1, Col: 25}, tab.GetCursor())
+}
@@ -0,0 +1,248 @@
+package editor
+
+import (
+	"bytes"
+	"slices"
+	"unicode/utf8"
+)
+
+// cursor is an additional cursor of a tab
+type cursor struct {
+	pos Position
+	// want is the column the cursor tries to keep when moving between lines or rows
+	want wantColumn
+}
+
+// matchCursors is the text matched to add cursors with ctrl-n
+type matchCursors struct {
+	pattern []byte
+	// rel is the offset of the cursors in the matches
+	rel       int
+	wholeWord bool
+}
+
+// eachCursor runs fn at every cursor as a single change. fn runs from the last cursor to
+// the first, so that its edits do not move the cursors it has still to run at, the
+// cursors after an edit are then shifted by the size of the text it inserted or deleted.
+// Cursors that end at the same place are merged.
+func (s *Tab) eachCursor(fn func()) {
+	if len(s.cursors) == 0 || s.hexMode {
+		fn()
+		return
+	}
+	type run struct {
+		cursor
+		primary       bool
+		offset, delta int
+	}
+	runs := []run{{cursor: cursor{pos: s.GetCursor(), want: s.want}, primary: true}}
+	for _, c := range s.cursors {
+		runs = append(runs, run{cursor: c})
+	}
+	slices.SortStableFunc(runs, func(a, b run) int { return comparePositions(a.pos, b.pos) })
+
+	s.BeginChange()
+	defer s.EndChange()
+	for i := len(runs) - 1; i >= 0; i-- {
+		s.SetCursor(runs[i].pos)
+		s.want = runs[i].want
+		size := s.doc.Len()
+		fn()
+		runs[i].offset, runs[i].delta = s.cursorOffset(), s.doc.Len()-size
+		runs[i].want = s.want
+	}
+
+	var primary cursor
+	s.cursors = s.cursors[:0]
+	shift := 0
+	for _, r := range runs {
+		c := cursor{pos: s.positionOf(min(max(r.offset+shift, 0), s.doc.Len())), want: r.want}
+		shift += r.delta
+		if r.primary {
+			primary = c
+		} else {
+			s.cursors = append(s.cursors, c)
+		}
+	}
+	s.SetCursor(primary.pos)
+	s.want = primary.want
+	s.mergeCursors()
+}
+
+// comparePositions compares the positions a and b like cmp.Compare
+func comparePositions(a, b Position) int {
+	switch {
+	case positionLess(a, b):
+		return -1
+	case positionLess(b, a):
+		return 1
+	}
+	return 0
+}
+
+// mergeCursors removes the additional cursors at the same place as another cursor
+func (s *Tab) mergeCursors() {
+	primary := s.GetCursor()
+	s.cursors = slices.DeleteFunc(s.cursors, func(c cursor) bool { return c.pos == primary })
+	slices.SortFunc(s.cursors, func(a, b cursor) int { return comparePositions(a.pos, b.pos) })
+	s.cursors = slices.CompactFunc(s.cursors, func(a, b cursor) bool { return a.pos == b.pos })
+}
+
+// addCursor adds a cursor at pos, the primary cursor stays where it is
+func (s *Tab) addCursor(pos Position) {
+	s.cursors = append(s.cursors, cursor{pos: pos, want: s.wantAt(pos)})
+	s.mergeCursors()
+}
+
+// ClearCursors removes the additional cursors
+func (s *Tab) ClearCursors() {
+	s.cursors = nil
+	s.match = nil
+}
+
+// cursorCols returns the columns of the additional cursors on line n
+func (s *Tab) cursorCols(n int) []int {
+	var cols []int
+	for _, c := range s.cursors {
+		if c.pos.Line == n {
+			cols = append(cols, c.pos.Col)
+		}
+	}
+	return cols
+}
+
+// addLineCursor adds a cursor on line n at the screen column x of the whole line, which
+// it keeps when it moves with j and k
+func (s *Tab) addLineCursor(n, x int) {
+	pos := Position{Line: n, Col: s.colAtScreen(n, x)}
+	want := s.wantAt(pos)
+	want.lineX = x
+	s.cursors = append(s.cursors, cursor{pos: pos, want: want})
+}
+
+// AddCursorLine adds a cursor dy lines below the lowest cursor, or above the highest one
+// when dy is negative, at the same screen column. It returns false past the first or the
+// last line.
+func (s *Tab) AddCursorLine(dy int) bool {
+	from := cursor{pos: s.GetCursor(), want: s.want}
+	for _, c := range s.cursors {
+		if (dy > 0 && c.pos.Line > from.pos.Line) || (dy < 0 && c.pos.Line < from.pos.Line) {
+			from = c
+		}
+	}
+	line := from.pos.Line + dy
+	if line < 0 || line >= s.doc.LineCount() {
+		return false
+	}
+	s.addLineCursor(line, from.want.lineX)
+	s.mergeCursors()
+	return true
+}
+
+// AddNextMatch adds a cursor on the next match of the word under the cursor, after the
+// last cursor and wrapping around the end of the text. The new cursor is at the same
+// place in the match as the cursor in the word. Once there are several cursors, the
+// first matched text is matched again. It returns false when there is no other match.
+func (s *Tab) AddNextMatch() bool {
+	if s.hexMode {
+		return false
+	}
+	if len(s.cursors) == 0 {
+		word, ok := wordObject(false, false)(s, s.GetCursor(), 1)
+		if !ok || charClass(s.charAt(word.start), false) != 2 {
+			return false
+		}
+		start, end := s.rangeOffsets(word)
+		s.match = &matchCursors{pattern: bytes.Clone(s.doc.Slice(start, end-start)), rel: s.cursorOffset() - start, wholeWord: true}
+	}
+	return s.match != nil && s.addMatchCursor(*s.match)
+}
+
+// matchWindow is the number of bytes of the document searched at once for the next match
+const matchWindow = 1 << 16
+
+// addMatchCursor adds a cursor in the next match after the last cursor, wrapping around
+// to the start of the text when there is none. With wholeWord, only the matches that are
+// not part of a longer word count.
+func (s *Tab) addMatchCursor(m matchCursors) bool {
+	pattern, rel, wholeWord := m.pattern, m.rel, m.wholeWord
+	taken := map[int]bool{s.cursorOffset() - rel: true}
+	last := s.cursorOffset() - rel
+	for _, c := range s.cursors {
+		offset := s.offsetOf(c.pos.Line, c.pos.Col) - rel
+		taken[offset] = true
+		last = max(last, offset)
+	}
+
+	isMatch := func(i int) bool {
+		if taken[i] {
+			return false
+		}
+		if !wholeWord {
+			return true
+		}
+		end := i + len(pattern)
+		before, _ := utf8.DecodeLastRune(s.doc.Slice(max(i-utf8.UTFMax, 0), min(i, utf8.UTFMax)))
+		after, _ := utf8.DecodeRune(s.doc.Slice(end, min(s.doc.Len()-end, utf8.UTFMax)))
+		return (i == 0 || charClass(before, false) != 2) && (end == s.doc.Len() || charClass(after, false) != 2)
+	}
+	i := s.findMatch(pattern, last+1, s.doc.Len(), isMatch)
+	if i < 0 {
+		i = s.findMatch(pattern, 0, min(last+1, s.doc.Len()), isMatch)
+	}
+	if i < 0 {
+		return false
+	}
+	s.addCursor(s.positionOf(i + rel))
+	return true
+}
+
+// findMatch returns the first offset in [from, to) where pattern starts and ok is true,
+// or -1. The document is read a window at a time, the text after the match is not copied.
+func (s *Tab) findMatch(pattern []byte, from, to int, ok func(int) bool) int {
+	if len(pattern) == 0 {
+		return -1
+	}
+	for start := from; start < to; start += matchWindow {
+		// the window overlaps the next one by the matches that start in it
+		limit := min(start+matchWindow, to)
+		text := s.doc.Slice(start, min(limit+len(pattern)-1, s.doc.Len())-start)
+		for i := 0; ; {
+			j := bytes.Index(text[i:], pattern)
+			if j < 0 || start+i+j >= limit {
+				break
+			}
+			if ok(start + i + j) {
+				return start + i + j
+			}
+			i += j + 1
+		}
+	}
+	return -1
+}
+
+// selectionToCursors ends the visual mode with a cursor on every line of the selection, at
+// the screen column of the cursor. In characterwise visual mode, a cursor is added at the
+// start of the next match of the selected text instead.
+func (s *Tab) selectionToCursors() bool {
+	if s.visual == ModeVisual {
+		r := s.selection()
+		if r.start.Line != r.end.Line {
+			return false
+		}
+		start, end := s.rangeOffsets(r)
+		s.SetCursor(r.start)
+		s.cursors = nil
+		s.match = &matchCursors{pattern: bytes.Clone(s.doc.Slice(start, end-start))}
+		return s.addMatchCursor(*s.match)
+	}
+	first, last := s.selectedLines()
+	x := s.want.lineX
+	for n := first; n <= last; n++ {
+		if n != s.lineIndex {
+			s.addLineCursor(n, x)
+		}
+	}
+	s.mergeCursors()
+	return true
+}
@@ -0,0 +1,95 @@
+package editor
+
+import (
+	"github.com/dangdungcntt/ndditor/editor/layout"
+	"github.com/test-go/testify/require"
+	"strings"
+	"testing"
+)
+
+func cursorPositions(tab *Tab) []Position {
+	positions := []Position{tab.GetCursor()}
+	for _, c := range tab.cursors {
+		positions = append(positions, c.pos)
+	}
+	return positions
+}
+
+func TestEachCursorEdits(t *testing.T) {
+	w, tab := newTestWindow("ab\ncd\nef")
+	typeKeys(w, "l<C-Down><C-Down>")
+	require.Equal(t, []Position{{Col: 1}, {Line: 1, Col: 1}, {Line: 2, Col: 1}}, cursorPositions(tab))
+
+	typeKeys(w, "ix<CR>y<Esc>")
+	require.Equal(t, "ax\nyb\ncx\nyd\nex\nyf", tabText(tab))
+	require.Equal(t, []Position{{Line: 1, Col: 1}, {Line: 3, Col: 1}, {Line: 5, Col: 1}}, cursorPositions(tab))
+
+	typeKeys(w, "i<BS><BS><Esc>")
+	require.Equal(t, "axb\ncxd\nexf", tabText(tab))
+	typeKeys(w, "x")
+	require.Equal(t, "ax\ncx\nex", tabText(tab))
+
+	// the whole edit is undone as a single step, and the cursors are removed
+	require.NoError(t, tab.Undo())
+	require.Equal(t, "axb\ncxd\nexf", tabText(tab))
+	require.Empty(t, tab.cursors)
+}
+
+func TestAddNextMatch(t *testing.T) {
+	w, tab := newTestWindow("foo bar\nfoobar foo\nfoo")
+	typeKeys(w, "l<C-n><C-n>")
+	require.Equal(t, []Position{{Col: 1}, {Line: 1, Col: 8}, {Line: 2, Col: 1}}, cursorPositions(tab))
+	typeKeys(w, "<C-n>")
+	require.Len(t, tab.cursors, 2)
+
+	typeKeys(w, "ciwbaz<Esc>")
+	require.Equal(t, "baz bar\nfoobar baz\nbaz", tabText(tab))
+	typeKeys(w, "<Esc>")
+	require.Empty(t, tab.cursors)
+
+	// a characterwise selection is matched anywhere
+	w, tab = newTestWindow("a-b a-b")
+	typeKeys(w, "vll<C-n>")
+	require.Equal(t, []Position{{}, {Col: 4}}, cursorPositions(tab))
+	require.True(t, GlobalState.IsMode(ModeView))
+	typeKeys(w, "3x")
+	require.Equal(t, " ", tabText(tab))
+}
+
+func TestAddNextMatchLongText(t *testing.T) {
+	// the second match straddles two windows, the search wraps around after the last one
+	second := matchWindow - 1
+	third := second + 3 + matchWindow
+	text := "foo" + strings.Repeat(" ", second-3) + "foo" + strings.Repeat(" ", matchWindow) + "foo"
+	w, tab := newTestWindow(text)
+	typeKeys(w, "l<C-n><C-n>")
+	require.Equal(t, []Position{{Col: 1}, {Col: second + 1}, {Col: third + 1}}, cursorPositions(tab))
+
+	w, tab = newTestWindow(text)
+	typeKeys(w, "$<C-n><C-n>")
+	require.Equal(t, []Position{{Col: third + 2}, {Col: 2}, {Col: second + 2}}, cursorPositions(tab))
+}
+
+func TestBlockToCursors(t *testing.T) {
+	w, tab := newTestWindow("abc\nd\nefg")
+	typeKeys(w, "ll<C-v>jj<C-n>")
+	require.Equal(t, []Position{{Line: 2, Col: 2}, {Col: 2}, {Line: 1, Col: 1}}, cursorPositions(tab))
+	typeKeys(w, "k")
+	require.Equal(t, []Position{{Line: 1, Col: 1}, {Col: 2}}, cursorPositions(tab))
+}
+
+func TestAddCursorsOnWrappedLines(t *testing.T) {
+	line := strings.Repeat("a", 50)
+	w, tab := newTestWindow(line + "\n" + line + "\n" + line)
+	tab.SetRenderSize(layout.Size{Width: 20, Height: 10})
+	tab.SetCursor(Position{Col: 25})
+	typeKeys(w, "<C-Down>")
+	require.Equal(t, []Position{{Col: 25}, {Line: 1, Col: 25}}, cursorPositions(tab))
+
+	tab.ClearCursors()
+	tab.SetCursor(Position{Col: 25})
+	typeKeys(w, "<C-v>jj<C-n>")
+	require.Equal(t, []Position{{Line: 2, Col: 25}, {Col: 25}, {Line: 1, Col: 25}}, cursorPositions(tab))
+	typeKeys(w, "k")
+	require.Equal(t, []Position{{Line: 1, Col: 25}, {Col: 25}}, cursorPositions(tab))
+}
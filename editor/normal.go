@@ -0,0 +1,65 @@
+package editor
+
+import (
+	"errors"
+	"github.com/gdamore/tcell/v2"
+)
+
+// maxNormalDepth limits the nesting of :normal, keys that run :normal again through a macro
+// would never stop. It is lower than maxMacroDepth as every level may run on many lines.
+const maxNormalDepth = 20
+
+// normal executes :[range]normal {keys}, the keys are typed in view mode once on every
+// line of the range, with the cursor at the start of the line, or once where the cursor
+// is without a range. A command left unfinished by the keys is aborted like with <Esc>.
+// The whole run is undone as a single step.
+func (s *Editor) normal(cmd exCommand) error {
+	if cmd.args == "" {
+		return &exError{err: errNoArgument, text: "normal"}
+	}
+	if s.normalDepth >= maxNormalDepth {
+		return errors.New(":normal recursion too deep")
+	}
+	tab := s.getActiveTab()
+	if tab.hexMode && cmd.addresses > 0 {
+		return errHexView
+	}
+	keys := parseKeyNames(cmd.args)
+
+	s.normalDepth++
+	tab.BeginChange()
+	GlobalState.BeginReplay()
+	defer func() {
+		GlobalState.EndReplay()
+		tab.EndChange()
+		s.normalDepth--
+	}()
+	if cmd.addresses == 0 {
+		s.feedKeys(keys)
+		return nil
+	}
+	// like vim, the lines are not adjusted when the keys add or delete lines
+	for line := max(cmd.first, 0); line <= cmd.last && line < tab.doc.LineCount(); line++ {
+		tab.SetCursor(Position{Line: line})
+		s.feedKeys(keys)
+		if s.getActiveTab() != tab {
+			break
+		}
+	}
+	return nil
+}
+
+// feedKeys types keys synchronously through handleKey, the pipeline of the typed keys,
+// then aborts the command they left unfinished
+func (s *Editor) feedKeys(keys []macroKey) {
+	for _, k := range keys {
+		s.handleKey(tcell.NewEventKey(k.Key, k.Rune, k.Mod))
+	}
+	// a confirm prompt takes an <Esc> of its own before the mode
+	for range 3 {
+		if GlobalState.isIdle() {
+			return
+		}
+		s.handleKey(tcell.NewEventKey(tcell.KeyEscape, 0, tcell.ModNone))
+	}
+}
@@ -0,0 +1,69 @@
+package editor
+
+import (
+	"github.com/gdamore/tcell/v2"
+	"github.com/test-go/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestNormal(t *testing.T) {
+	tests := []struct {
+		name, text, cmd, want string
+		cursor                Position
+	}{
+		{"insert", "a\nb\nc", ":%normal 0i#\r", "#a\n#b\n#c", Position{Line: 2, Col: 1}},
+		{"current line", "a\nb", ":norm ix\r", "xa\nb", Position{Col: 1}},
+		{"range", "a\nb\nc\nd", ":2,3norm! x\r", "a\n\n\nd", Position{Line: 2}},
+		{"key names", "a b\nc d", ":%norm wi<lt><Space><Esc>\r", "a < b\nc < d", Position{Line: 1, Col: 4}},
+		{"unfinished", "abc", ":norm d\r", "abc", Position{}},
+		{"ex command", "a\nb", ":%norm :s/$/./<CR>\r", "a.\nb.", Position{Line: 1}},
+		{"global", "a\nb\na", ":g/a/norm 0i-\r", "-a\nb\n-a", Position{Line: 2, Col: 1}},
+		{"deleted lines", "a\nb\nc\nd", ":%norm dd\r", "b\nd", Position{Line: 1}},
+		{"trailing blanks", "a\nb", ":%norm i  \r", "  a\n  b", Position{Line: 1, Col: 2}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resetSearch(t)
+			e := newTestEditor(t, test.text)
+			tab := e.getActiveTab()
+			typeEditorKeys(e, test.cmd)
+			require.Equal(t, test.want, tabText(tab))
+			require.Equal(t, test.cursor, tab.GetCursor())
+			require.True(t, GlobalState.isIdle())
+			if test.want == test.text {
+				return
+			}
+			require.NoError(t, tab.Undo())
+			require.Equal(t, test.text, tabText(tab))
+		})
+	}
+}
+
+func TestNormalRecursion(t *testing.T) {
+	e := newTestEditor(t, "a")
+	tab := e.getActiveTab()
+	// the register is only written when the recording stops, the @a typed while
+	// recording replays nothing
+	typeEditorKeys(e, "qa0ib\x1b:norm @a\rq@a")
+	require.Equal(t, "err: :normal recursion too deep", GlobalState.getInfoLine())
+	require.Equal(t, strings.Repeat("b", maxNormalDepth+2)+"a", tabText(tab))
+	require.Equal(t, 0, e.normalDepth)
+
+	typeEditorKeys(e, ":norm\r")
+	require.Equal(t, "err: argument required: normal", GlobalState.getInfoLine())
+}
+
+func TestParseKeyNames(t *testing.T) {
+	keys := parseKeyNames("a<Esc><c-r><x><")
+	require.Equal(t, []macroKey{
+		{Key: tcell.KeyRune, Rune: 'a'},
+		{Key: tcell.KeyEscape},
+		{Key: tcell.KeyCtrlR, Mod: tcell.ModCtrl},
+		{Key: tcell.KeyRune, Rune: '<'},
+		{Key: tcell.KeyRune, Rune: 'x'},
+		{Key: tcell.KeyRune, Rune: '>'},
+		{Key: tcell.KeyRune, Rune: '<'},
+	}, keys)
+	require.Equal(t, "a<Esc><C-r><x><", keyNames(keys))
+}
@@ -0,0 +1,220 @@
+package editor
+
+import (
+	"bytes"
+	"strings"
+)
+
+// operators are the keys of the operators, they act on the text covered by a motion
+// or a text object
+const operators = "dcy><"
+
+// textRange is the text an operator acts on. end is excluded, linewise ranges cover
+// the whole lines from start.Line to end.Line.
+type textRange struct {
+	start    Position
+	end      Position
+	linewise bool
+}
+
+// operatorCommand is a parsed operator command like dw, ci" or dd
+type operatorCommand struct {
+	op rune
+	// line is true when the operator is doubled like dd, it then acts on whole lines
+	line       bool
+	motion     *motion
+	motionKeys string
+	arg        rune
+	object     textObjectFunc
+	// register is the register given with "{reg} before the command, 0 for none
+	register rune
+}
+
+// parseOperator parses an operator followed by a motion, a text object or the operator
+// again
+func parseOperator(keys string) (operatorCommand, int) {
+	runes := []rune(keys)
+	if len(runes) == 0 || !strings.ContainsRune(operators, runes[0]) {
+		return operatorCommand{}, keysInvalid
+	}
+	cmd := operatorCommand{op: runes[0]}
+	rest := string(runes[1:])
+	switch {
+	case rest == "":
+		return cmd, keysPending
+	case rest == string(cmd.op):
+		cmd.line = true
+		return cmd, keysComplete
+	case rest == "i" || rest == "a":
+		return cmd, keysPending
+	case textObjects[rest] != nil:
+		cmd.object = textObjects[rest]
+		return cmd, keysComplete
+	}
+	m, arg, status := parseMotion(rest)
+	cmd.motion, cmd.motionKeys, cmd.arg = m, rest, arg
+	return cmd, status
+}
+
+// operatorRange returns the text an operator command acts on
+func (s *Tab) operatorRange(cmd operatorCommand, count int) (textRange, bool) {
+	pos := s.GetCursor()
+	if cmd.line {
+		end := min(pos.Line+max(count, 1)-1, s.doc.LineCount()-1)
+		return textRange{start: Position{Line: pos.Line}, end: Position{Line: end}, linewise: true}, true
+	}
+	if cmd.object != nil {
+		return cmd.object(s, pos, count)
+	}
+
+	m := cmd.motion
+	var target Position
+	var ok bool
+	if cmd.op == 'c' && (cmd.motionKeys == "w" || cmd.motionKeys == "W") && s.charAt(pos) != ' ' && s.charAt(pos) != '\t' {
+		// like vim, cw on a word changes up to the end of the word, not to the next one
+		target, ok = s.wordEndFrom(pos, count, cmd.motionKeys == "W"), true
+		m = motions["e"]
+	} else {
+		target, ok = m.target(s, pos, count, cmd.arg)
+	}
+	if !ok {
+		return textRange{}, false
+	}
+
+	r := textRange{start: pos, end: target, linewise: m.linewise}
+	if positionLess(target, pos) {
+		r.start, r.end = target, pos
+	}
+	if r.linewise {
+		return r, true
+	}
+	if m.inclusive {
+		r.end.Col = s.moveCol(r.end.Line, r.end.Col, 1)
+	} else if r.end.Col == 0 && r.end.Line > r.start.Line {
+		// an exclusive motion that ends at the start of a line stops at the end of the
+		// previous line, and acts on whole lines when it starts before the text of its line
+		r.end = Position{Line: r.end.Line - 1, Col: s.lineLen(r.end.Line - 1)}
+		if r.start.Col <= s.indentCol(r.start.Line) {
+			r.linewise = true
+		}
+	}
+	return r, true
+}
+
+// wordEndFrom returns the end of the word at pos, then of the count-1 following words
+func (s *Tab) wordEndFrom(pos Position, count int, bigWord bool) Position {
+	w := s.newWalker(pos)
+	class := charClass(w.char(), bigWord)
+	for w.next() {
+		if charClass(w.char(), bigWord) != class {
+			w.prev()
+			break
+		}
+	}
+	if count > 1 {
+		target, _ := wordEnd(bigWord)(s, w.pos, count-1, 0)
+		return target
+	}
+	return w.pos
+}
+
+// charAt returns the character at pos, '\n' at the end of a line
+func (s *Tab) charAt(pos Position) rune {
+	for _, r := range docRunes(s.doc, s.offsetOf(pos.Line, pos.Col), s.doc.LineEnd(pos.Line)) {
+		return r
+	}
+	return '\n'
+}
+
+func positionLess(a, b Position) bool {
+	return a.Line < b.Line || (a.Line == b.Line && a.Col < b.Col)
+}
+
+// rangeOffsets returns the document offsets of the start and the end of r. The end of
+// a linewise range is the end of its last line, without the line break.
+func (s *Tab) rangeOffsets(r textRange) (int, int) {
+	if r.linewise {
+		return s.doc.LineStart(r.start.Line), s.doc.LineEnd(r.end.Line)
+	}
+	return s.offsetOf(r.start.Line, r.start.Col), s.offsetOf(r.end.Line, r.end.Col)
+}
+
+// rangeText returns the text of r, linewise text ends with a line break
+func (s *Tab) rangeText(r textRange) register {
+	start, end := s.rangeOffsets(r)
+	text := bytes.Clone(s.doc.Slice(start, end-start))
+	if r.linewise {
+		text = append(text, '\n')
+	}
+	return register{text: text, linewise: r.linewise}
+}
+
+// applyOperator applies an operator command to the tab as a single change.
+// It returns false when the motion or the text object fails.
+func (s *Tab) applyOperator(cmd operatorCommand, count int) bool {
+	if s.hexMode {
+		return false
+	}
+	r, ok := s.operatorRange(cmd, count)
+	if !ok {
+		return false
+	}
+	s.applyRange(cmd.op, cmd.register, r)
+	return true
+}
+
+// applyRange applies the operator op to the text of r as a single change, the deleted
+// or yanked text is stored into register
+func (s *Tab) applyRange(op, register rune, r textRange) {
+	s.BeginChange()
+	defer s.EndChange()
+	switch op {
+	case 'y':
+		storeRegister(register, op, s.rangeText(r))
+		if r.linewise {
+			s.SetCursor(Position{Line: r.start.Line, Col: s.GetCursor().Col})
+		} else {
+			s.SetCursor(r.start)
+		}
+	case 'd':
+		storeRegister(register, op, s.rangeText(r))
+		s.deleteRange(r)
+	case 'c':
+		storeRegister(register, op, s.rangeText(r))
+		if r.linewise {
+			// the lines are emptied but kept, for the text typed in insert mode
+			start, end := s.rangeOffsets(r)
+			s.deleteText(start, end-start)
+			s.SetCursor(Position{Line: r.start.Line})
+		} else {
+			s.deleteRange(r)
+		}
+	case '>', '<':
+		count := 1
+		if op == '<' {
+			count = -1
+		}
+		s.SetCursor(Position{Line: r.start.Line})
+		s.ShiftLines(r.start.Line, r.end.Line, count)
+	}
+}
+
+// deleteRange deletes the text of r and moves the cursor to where it was
+func (s *Tab) deleteRange(r textRange) {
+	start, end := s.rangeOffsets(r)
+	if !r.linewise {
+		s.deleteText(start, end-start)
+		s.SetCursor(r.start)
+		return
+	}
+	// the line break after the last line goes too, or the one before the first line
+	// when the range ends at the last line
+	if r.end.Line < s.doc.LineCount()-1 {
+		end++
+	} else if start > 0 {
+		start--
+	}
+	s.deleteText(start, end-start)
+	line := min(r.start.Line, s.doc.LineCount()-1)
+	s.SetCursor(Position{Line: line, Col: s.indentCol(line)})
+}
@@ -0,0 +1,107 @@
+package editor
+
+import (
+	"github.com/test-go/testify/require"
+	"testing"
+)
+
+// runOperator applies the operator command typed as keys at pos and returns the text of the tab
+func runOperator(t *testing.T, text string, pos Position, keys string) (*Tab, string) {
+	tab := NewTab("", NewPieceTable([]byte(text)))
+	tab.SetCursor(pos)
+	cmd, status := parseOperator(keys)
+	require.Equal(t, keysComplete, status, keys)
+	require.True(t, tab.applyOperator(cmd, 0), keys)
+	return tab, string(tab.doc.Slice(0, tab.doc.Len()))
+}
+
+func TestOperatorMotions(t *testing.T) {
+	_, text := runOperator(t, "foo bar baz", Position{Col: 4}, "dw")
+	require.Equal(t, "foo baz", text)
+	_, text = runOperator(t, "foo bar\nbaz", Position{Col: 4}, "dw")
+	require.Equal(t, "foo \nbaz", text)
+	tab, text := runOperator(t, "foo bar baz", Position{Col: 4}, "cw")
+	require.Equal(t, "foo  baz", text)
+	require.Equal(t, Position{Col: 4}, tab.GetCursor())
+	_, text = runOperator(t, "foo bar baz", Position{Col: 1}, "d$")
+	require.Equal(t, "f", text)
+	_, text = runOperator(t, "a(b)c", Position{Col: 1}, "d%")
+	require.Equal(t, "ac", text)
+	tab, text = runOperator(t, "one\ntwo\nthree", Position{Line: 1}, "dj")
+	require.Equal(t, "one", text)
+	require.Equal(t, register{text: []byte("two\nthree\n"), linewise: true}, registers['"'])
+	require.Equal(t, Position{}, tab.GetCursor())
+	_, text = runOperator(t, "one\ntwo\n\nthree", Position{}, "d}")
+	require.Equal(t, "\nthree", text)
+
+	tab, text = runOperator(t, "one\ntwo", Position{Col: 2}, "yy")
+	require.Equal(t, "one\ntwo", text)
+	require.Equal(t, register{text: []byte("one\n"), linewise: true}, registers['"'])
+	require.Equal(t, Position{Col: 2}, tab.GetCursor())
+
+	_, text = runOperator(t, "one\n  two\nthree", Position{Line: 1}, "dd")
+	require.Equal(t, "one\nthree", text)
+	_, text = runOperator(t, "one\n  two", Position{Line: 1}, "cc")
+	require.Equal(t, "one\n", text)
+	_, text = runOperator(t, "one\ntwo", Position{}, ">j")
+	require.Equal(t, "\tone\n\ttwo", text)
+
+	tab, text = runOperator(t, "one two", Position{}, "dw")
+	require.NoError(t, tab.Undo())
+	require.Equal(t, "one two", string(tab.doc.Slice(0, tab.doc.Len())))
+	require.Equal(t, "two", text)
+}
+
+func TestTextObjects(t *testing.T) {
+	cases := []struct {
+		text string
+		pos  Position
+		keys string
+		want string
+	}{
+		{"foo bar baz", Position{Col: 5}, "diw", "foo  baz"},
+		{"foo bar baz", Position{Col: 5}, "daw", "foo baz"},
+		{"foo bar", Position{Col: 5}, "daw", "foo"},
+		{"a.b c", Position{Col: 1}, "diW", " c"},
+		{"foo  bar\nbaz", Position{Col: 3}, "daw", "foo\nbaz"},
+		{"x foo\nbar", Position{Col: 4}, "daw", "x\nbar"},
+		{"aé€x  y", Position{Col: 1}, "diw", "€x  y"},
+		{`say "hello world" now`, Position{Col: 8}, `di"`, `say "" now`},
+		{`say "hello world" now`, Position{Col: 8}, `da"`, `say now`},
+		{`x = 'a\'b'`, Position{Col: 9}, "ci'", `x = ''`},
+		{`a "b" c "d"`, Position{Col: 4}, `di"`, `a "" c "d"`},
+		{`a "b" c "d"`, Position{Col: 8}, `di"`, `a "b" c ""`},
+		{`x "b" c`, Position{Col: 0}, `di"`, `x "" c`},
+		{`x = "a\\" + "b"`, Position{Col: 13}, `di"`, `x = "a\\" + ""`},
+		{`x  "b"`, Position{Col: 4}, `da"`, `x`},
+		{"f(a, (b), c)", Position{Col: 6}, "di(", "f(a, (), c)"},
+		{"f(a, (b), c)", Position{Col: 3}, "dab", "f"},
+		{"if x {\n\tfoo\n\tbar\n}", Position{Line: 1}, "di{", "if x {\n}"},
+		{"<a><b>text</b> tail</a>", Position{Col: 7}, "dit", "<a><b></b> tail</a>"},
+		{"<a><b>text</b> tail</a>", Position{Col: 7}, "dat", "<a> tail</a>"},
+		{"<a><b>text</b> tail</a>", Position{Col: 16}, "dit", "<a></a>"},
+		{"<a><b>text</b> tail</a>", Position{Col: 12}, "dit", "<a><b></b> tail</a>"},
+		{"<p>one <br> two</p>", Position{Col: 13}, "dit", "<p></p>"},
+		{"<div class=\"x\">\n  <P>a</p>\n</DIV>", Position{Line: 2}, "dat", ""},
+		{"One. Two three. Four.", Position{Col: 7}, "dis", "One.  Four."},
+		{"One. Two three. Four.", Position{Col: 7}, "das", "One. Four."},
+		{"One.\nTwo three.\nFour.", Position{Line: 1}, "das", "One.\nFour."},
+		{"Say (\"hi.\")  Then go! Done", Position{Col: 16}, "dis", "Say (\"hi.\")   Done"},
+		{"Para one.\n\nA b.\n  C d.", Position{Line: 3, Col: 3}, "das", "Para one.\n\nA b."},
+		{"First line\ngoes on. Next", Position{Col: 40}, "dis", " Next"},
+		{"a\nb\n\nc", Position{}, "dap", "c"},
+		{"a\nb\n\nc", Position{}, "yip", "a\nb\n\nc"},
+	}
+	for _, c := range cases {
+		_, text := runOperator(t, c.text, c.pos, c.keys)
+		require.Equal(t, c.want, text, c.keys)
+	}
+
+	// a count selects the enclosing tags
+	tab := NewTab("", NewPieceTable([]byte("<a><b>text</b> tail</a>")))
+	r, ok := textObjects["it"](tab, Position{Col: 7}, 2)
+	require.True(t, ok)
+	require.Equal(t, textRange{start: Position{Col: 3}, end: Position{Col: 19}}, r)
+	_, ok = textObjects["it"](tab, Position{Col: 7}, 3)
+	require.False(t, ok)
+}
@@ -0,0 +1,372 @@
+package editor
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	optionBool = iota
+	optionNumber
+	optionString
+)
+
+// maxIndentWidth is the largest tabstop and shiftwidth, wider tabs and indentations would
+// only make the editor slow
+const maxIndentWidth = 9999
+
+// option is a setting that can be read and changed with :set.
+// Options are read from and written to the active tab, global options simply ignore it.
+type option struct {
+	name  string
+	short string
+	kind  int
+	// values lists the accepted values of a string option, empty means any value
+	values []string
+	get    func(t *Tab) string
+	set    func(t *Tab, value string) error
+}
+
+// globalOptions holds the options shared by every tab
+var globalOptions = struct {
+	fallbackEncoding string
+	tabStop          int
+	// shiftWidth is the width of one level of indentation, 0 means tabStop
+	shiftWidth int
+	expandTab  bool
+	wrap       bool
+	lineBreak  bool
+	showBreak  string
+	// sideScroll is the minimal number of columns to scroll horizontally, 0 means half a screen
+	sideScroll    int
+	sideScrollOff int
+	// ignoreCase makes searches ignore the case, smartCase only when the pattern has no
+	// uppercase letter
+	ignoreCase bool
+	smartCase  bool
+	// hlSearch highlights the matches of the last search
+	hlSearch bool
+}{
+	fallbackEncoding: EncodingUTF8,
+	tabStop:          8,
+	shiftWidth:       8,
+	wrap:             true,
+	hlSearch:         true,
+}
+
+var options = []*option{
+	{
+		name:  "fileencoding",
+		short: "fenc",
+		kind:  optionString,
+		get: func(t *Tab) string {
+			return t.encoding
+		},
+		set: func(t *Tab, value string) error {
+			// the bytes of binary tabs and of the hex view are written as they are
+			if t.encoding == EncodingBinary || t.hexText != nil {
+				return errors.New("cannot change the encoding of the bytes shown in the hex view")
+			}
+			name, _, err := lookupEncoding(value)
+			if err != nil {
+				return err
+			}
+			t.encoding = name
+			return nil
+		},
+	},
+	{
+		name:  "fallbackencoding",
+		short: "fbenc",
+		kind:  optionString,
+		get: func(_ *Tab) string {
+			return globalOptions.fallbackEncoding
+		},
+		set: func(_ *Tab, value string) error {
+			name, _, err := lookupEncoding(value)
+			if err != nil {
+				return err
+			}
+			globalOptions.fallbackEncoding = name
+			return nil
+		},
+	},
+	{
+		name:  "tabstop",
+		short: "ts",
+		kind:  optionNumber,
+		get: func(_ *Tab) string {
+			return strconv.Itoa(globalOptions.tabStop)
+		},
+		set: func(_ *Tab, value string) error {
+			n, _ := strconv.Atoi(value)
+			if n <= 0 {
+				return fmt.Errorf("argument must be positive: tabstop=%s", value)
+			}
+			if n > maxIndentWidth {
+				return fmt.Errorf("argument must be at most %d: tabstop=%s", maxIndentWidth, value)
+			}
+			globalOptions.tabStop = n
+			return nil
+		},
+	},
+	{
+		name:  "shiftwidth",
+		short: "sw",
+		kind:  optionNumber,
+		get: func(_ *Tab) string {
+			return strconv.Itoa(globalOptions.shiftWidth)
+		},
+		set: func(_ *Tab, value string) error {
+			n, _ := strconv.Atoi(value)
+			if n < 0 {
+				return fmt.Errorf("argument must be positive: shiftwidth=%s", value)
+			}
+			if n > maxIndentWidth {
+				return fmt.Errorf("argument must be at most %d: shiftwidth=%s", maxIndentWidth, value)
+			}
+			globalOptions.shiftWidth = n
+			return nil
+		},
+	},
+	{
+		name:  "expandtab",
+		short: "et",
+		kind:  optionBool,
+		get: func(_ *Tab) string {
+			return formatBool(globalOptions.expandTab)
+		},
+		set: func(_ *Tab, value string) error {
+			globalOptions.expandTab = value == "true"
+			return nil
+		},
+	},
+	{
+		name: "wrap",
+		kind: optionBool,
+		get: func(_ *Tab) string {
+			return formatBool(globalOptions.wrap)
+		},
+		set: func(_ *Tab, value string) error {
+			globalOptions.wrap = value == "true"
+			return nil
+		},
+	},
+	{
+		name:  "linebreak",
+		short: "lbr",
+		kind:  optionBool,
+		get: func(_ *Tab) string {
+			return formatBool(globalOptions.lineBreak)
+		},
+		set: func(_ *Tab, value string) error {
+			globalOptions.lineBreak = value == "true"
+			return nil
+		},
+	},
+	{
+		name:  "showbreak",
+		short: "sbr",
+		kind:  optionString,
+		get: func(_ *Tab) string {
+			return globalOptions.showBreak
+		},
+		set: func(_ *Tab, value string) error {
+			globalOptions.showBreak = value
+			return nil
+		},
+	},
+	{
+		name:  "sidescroll",
+		short: "ss",
+		kind:  optionNumber,
+		get: func(_ *Tab) string {
+			return strconv.Itoa(globalOptions.sideScroll)
+		},
+		set: func(_ *Tab, value string) error {
+			n, _ := strconv.Atoi(value)
+			if n < 0 {
+				return fmt.Errorf("argument must be positive: sidescroll=%s", value)
+			}
+			globalOptions.sideScroll = n
+			return nil
+		},
+	},
+	{
+		name:  "sidescrolloff",
+		short: "siso",
+		kind:  optionNumber,
+		get: func(_ *Tab) string {
+			return strconv.Itoa(globalOptions.sideScrollOff)
+		},
+		set: func(_ *Tab, value string) error {
+			n, _ := strconv.Atoi(value)
+			if n < 0 {
+				return fmt.Errorf("argument must be positive: sidescrolloff=%s", value)
+			}
+			globalOptions.sideScrollOff = n
+			return nil
+		},
+	},
+	{
+		name:  "ignorecase",
+		short: "ic",
+		kind:  optionBool,
+		get: func(_ *Tab) string {
+			return formatBool(globalOptions.ignoreCase)
+		},
+		set: func(_ *Tab, value string) error {
+			globalOptions.ignoreCase = value == "true"
+			return nil
+		},
+	},
+	{
+		name:  "smartcase",
+		short: "scs",
+		kind:  optionBool,
+		get: func(_ *Tab) string {
+			return formatBool(globalOptions.smartCase)
+		},
+		set: func(_ *Tab, value string) error {
+			globalOptions.smartCase = value == "true"
+			return nil
+		},
+	},
+	{
+		name:  "hlsearch",
+		short: "hls",
+		kind:  optionBool,
+		get: func(_ *Tab) string {
+			return formatBool(globalOptions.hlSearch)
+		},
+		set: func(_ *Tab, value string) error {
+			globalOptions.hlSearch = value == "true"
+			return nil
+		},
+	},
+	{
+		name:   "fileformat",
+		short:  "ff",
+		kind:   optionString,
+		values: fileFormats,
+		get: func(t *Tab) string {
+			return t.fileFormat.Format
+		},
+		set: func(t *Tab, value string) error {
+			t.fileFormat.Format = value
+			t.fileFormat.Mixed = false
+			return nil
+		},
+	},
+	{
+		name: "eol",
+		kind: optionBool,
+		get: func(t *Tab) string {
+			return formatBool(t.fileFormat.EOL)
+		},
+		set: func(t *Tab, value string) error {
+			t.fileFormat.EOL = value == "true"
+			return nil
+		},
+	},
+	{
+		name: "bomb",
+		kind: optionBool,
+		get: func(t *Tab) string {
+			return formatBool(t.fileFormat.BOM)
+		},
+		set: func(t *Tab, value string) error {
+			t.fileFormat.BOM = value == "true"
+			return nil
+		},
+	},
+}
+
+func formatBool(b bool) string {
+	return strconv.FormatBool(b)
+}
+
+func findOption(name string) *option {
+	for _, o := range options {
+		if o.name == name || (o.short != "" && o.short == name) {
+			return o
+		}
+	}
+	return nil
+}
+
+// setOptions executes the arguments of :set on the tab. Every argument is one of
+// name, noname, name!, name? or name=value. It returns the values that were queried.
+func setOptions(t *Tab, args string) (string, error) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		return "", errors.New("missing option name")
+	}
+	var shown []string
+	for _, arg := range fields {
+		msg, err := setOption(t, arg)
+		if err != nil {
+			return "", err
+		}
+		if msg != "" {
+			shown = append(shown, msg)
+		}
+	}
+	return strings.Join(shown, " "), nil
+}
+
+func setOption(t *Tab, arg string) (string, error) {
+	name, value, hasValue := strings.Cut(arg, "=")
+	query := strings.HasSuffix(name, "?")
+	toggle := strings.HasSuffix(name, "!")
+	name = strings.TrimRight(name, "?!")
+
+	o := findOption(name)
+	negate := false
+	if o == nil && strings.HasPrefix(name, "no") {
+		o = findOption(name[2:])
+		negate = o != nil && o.kind == optionBool
+	}
+	if o == nil {
+		return "", fmt.Errorf("unknown option: %s", name)
+	}
+
+	if query || (!hasValue && !toggle && o.kind != optionBool) {
+		return showOption(t, o), nil
+	}
+
+	switch o.kind {
+	case optionBool:
+		if hasValue {
+			return "", fmt.Errorf("invalid argument: %s", arg)
+		}
+		on := !negate
+		if toggle {
+			on = o.get(t) != "true"
+		}
+		return "", o.set(t, formatBool(on))
+	case optionNumber:
+		if _, err := strconv.Atoi(value); err != nil {
+			return "", fmt.Errorf("number required: %s", arg)
+		}
+	default:
+		if len(o.values) > 0 && !slices.Contains(o.values, value) {
+			return "", fmt.Errorf("invalid argument: %s", arg)
+		}
+	}
+	return "", o.set(t, value)
+}
+
+func showOption(t *Tab, o *option) string {
+	value := o.get(t)
+	if o.kind != optionBool {
+		return fmt.Sprintf("%s=%s", o.name, value)
+	}
+	if value == "true" {
+		return o.name
+	}
+	return "no" + o.name
+}
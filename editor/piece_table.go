@@ -0,0 +1,377 @@
+package editor
+
+import (
+	"bytes"
+	"io"
+	"iter"
+	"math/rand/v2"
+	"sort"
+)
+
+var _ Document = (*PieceTable)(nil)
+
+// pieceBuffer is an append-only byte buffer together with the offsets of every newline in it.
+type pieceBuffer struct {
+	data     []byte
+	newlines []int
+}
+
+func newPieceBuffer(data []byte) *pieceBuffer {
+	b := &pieceBuffer{}
+	b.append(data)
+	return b
+}
+
+func (b *pieceBuffer) append(text []byte) {
+	base := len(b.data)
+	b.data = append(b.data, text...)
+	for i, c := range text {
+		if c == '\n' {
+			b.newlines = append(b.newlines, base+i)
+		}
+	}
+}
+
+// countNewlines returns the index in newlines of the first newline at or after start,
+// and the number of newlines in [start, end)
+func (b *pieceBuffer) countNewlines(start, end int) (first int, count int) {
+	first = sort.SearchInts(b.newlines, start)
+	last := sort.SearchInts(b.newlines, end)
+	return first, last - first
+}
+
+// pieceNode is a node of a treap ordered by document offset.
+// Every node holds one piece, a span of one of the buffers, and caches the
+// byte size and newline count of its subtree so lookups by offset or by line are O(log n).
+type pieceNode struct {
+	buf      *pieceBuffer
+	start    int
+	length   int
+	lf       int
+	priority uint32
+	left     *pieceNode
+	right    *pieceNode
+	size     int
+	lfs      int
+}
+
+func newPieceNode(buf *pieceBuffer, start, length int) *pieceNode {
+	_, lf := buf.countNewlines(start, start+length)
+	n := &pieceNode{
+		buf:      buf,
+		start:    start,
+		length:   length,
+		lf:       lf,
+		priority: rand.Uint32(),
+	}
+	n.update()
+	return n
+}
+
+func (n *pieceNode) bytes() []byte {
+	return n.buf.data[n.start : n.start+n.length]
+}
+
+func (n *pieceNode) update() {
+	n.size = n.length + n.left.getSize() + n.right.getSize()
+	n.lfs = n.lf + n.left.getLfs() + n.right.getLfs()
+}
+
+func (n *pieceNode) getSize() int {
+	if n == nil {
+		return 0
+	}
+	return n.size
+}
+
+func (n *pieceNode) getLfs() int {
+	if n == nil {
+		return 0
+	}
+	return n.lfs
+}
+
+// splitPieces splits the tree into the pieces before offset and the pieces after it,
+// cutting a piece in two if offset falls inside of it
+func splitPieces(n *pieceNode, offset int) (*pieceNode, *pieceNode) {
+	if n == nil {
+		return nil, nil
+	}
+	leftSize := n.left.getSize()
+	switch {
+	case offset <= leftSize:
+		l, r := splitPieces(n.left, offset)
+		n.left = r
+		n.update()
+		return l, n
+	case offset >= leftSize+n.length:
+		l, r := splitPieces(n.right, offset-leftSize-n.length)
+		n.right = l
+		n.update()
+		return n, r
+	default:
+		k := offset - leftSize
+		tail := newPieceNode(n.buf, n.start+k, n.length-k)
+		r := mergePieces(tail, n.right)
+		n.right = nil
+		n.length = k
+		_, n.lf = n.buf.countNewlines(n.start, n.start+k)
+		n.update()
+		return n, r
+	}
+}
+
+// mergePieces joins two trees, every piece of a must come before every piece of b
+func mergePieces(a, b *pieceNode) *pieceNode {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	if a.priority > b.priority {
+		a.right = mergePieces(a.right, b)
+		a.update()
+		return a
+	}
+	b.left = mergePieces(a, b.left)
+	b.update()
+	return b
+}
+
+// PieceTable is a Document that keeps the original content untouched and records
+// edits as pieces pointing either into the original buffer or into an append-only add buffer.
+// Pieces are stored in a balanced tree, so line lookup, insertion and deletion are O(log n).
+type PieceTable struct {
+	original *pieceBuffer
+	add      *pieceBuffer
+	root     *pieceNode
+}
+
+// NewPieceTable creates a new PieceTable with the given content.
+// The table takes ownership of content, it must not be modified afterward.
+func NewPieceTable(content []byte) *PieceTable {
+	t := &PieceTable{
+		original: newPieceBuffer(content),
+		add:      newPieceBuffer(nil),
+	}
+	if len(content) > 0 {
+		t.root = newPieceNode(t.original, 0, len(content))
+	}
+	return t
+}
+
+// Len returns the size of the document in bytes
+func (t *PieceTable) Len() int {
+	return t.root.getSize()
+}
+
+// LineCount returns the number of lines in the document
+func (t *PieceTable) LineCount() int {
+	return t.root.getLfs() + 1
+}
+
+// LineStart returns the offset of the first byte of line n
+func (t *PieceTable) LineStart(n int) int {
+	if n <= 0 {
+		return 0
+	}
+	if n >= t.LineCount() {
+		return t.Len()
+	}
+	return t.newlineOffset(n-1) + 1
+}
+
+// LineEnd returns the offset of the newline that terminates line n, or Len() for the last line
+func (t *PieceTable) LineEnd(n int) int {
+	if n < 0 {
+		return 0
+	}
+	if n >= t.LineCount()-1 {
+		return t.Len()
+	}
+	return t.newlineOffset(n)
+}
+
+// newlineOffset returns the offset of the k-th (zero based) newline of the document
+func (t *PieceTable) newlineOffset(k int) int {
+	offset := 0
+	n := t.root
+	for n != nil {
+		leftLfs := n.left.getLfs()
+		if k < leftLfs {
+			n = n.left
+			continue
+		}
+		k -= leftLfs
+		offset += n.left.getSize()
+		if k < n.lf {
+			first, _ := n.buf.countNewlines(n.start, n.start+n.length)
+			return offset + n.buf.newlines[first+k] - n.start
+		}
+		k -= n.lf
+		offset += n.length
+		n = n.right
+	}
+	return t.Len()
+}
+
+// LineAt returns the index of the line containing offset
+func (t *PieceTable) LineAt(offset int) int {
+	line := 0
+	n := t.root
+	for n != nil {
+		leftSize := n.left.getSize()
+		if offset < leftSize {
+			n = n.left
+			continue
+		}
+		offset -= leftSize
+		line += n.left.getLfs()
+		if offset < n.length {
+			_, lf := n.buf.countNewlines(n.start, n.start+offset)
+			return line + lf
+		}
+		offset -= n.length
+		line += n.lf
+		n = n.right
+	}
+	return line
+}
+
+// Line returns a copy of line n without its trailing newline
+func (t *PieceTable) Line(n int) []byte {
+	start := t.LineStart(n)
+	return t.Slice(start, t.LineEnd(n)-start)
+}
+
+// Slice returns a copy of length bytes starting at offset
+func (t *PieceTable) Slice(offset, length int) []byte {
+	res := make([]byte, 0, max(length, 0))
+	for chunk := range t.Chunks(offset, offset+length) {
+		res = append(res, chunk...)
+	}
+	return res
+}
+
+// Chunks iterates over the bytes in [from, to) without copying them
+func (t *PieceTable) Chunks(from, to int) iter.Seq[[]byte] {
+	return func(yield func([]byte) bool) {
+		from = max(from, 0)
+		to = min(to, t.Len())
+		if from >= to {
+			return
+		}
+		walkPieces(t.root, 0, from, to, yield)
+	}
+}
+
+// walkPieces yields the part of every piece in the subtree n that overlaps [from, to).
+// base is the document offset of the first byte of the subtree.
+func walkPieces(n *pieceNode, base, from, to int, yield func([]byte) bool) bool {
+	if n == nil || base >= to || base+n.size <= from {
+		return true
+	}
+	if !walkPieces(n.left, base, from, to, yield) {
+		return false
+	}
+	start := base + n.left.getSize()
+	end := start + n.length
+	if start < to && end > from {
+		data := n.bytes()[max(from, start)-start : min(to, end)-start]
+		if !yield(data) {
+			return false
+		}
+	}
+	return walkPieces(n.right, end, from, to, yield)
+}
+
+// Lines iterates over the lines in [from, to), yielding the line index and its content
+func (t *PieceTable) Lines(from, to int) iter.Seq2[int, []byte] {
+	return func(yield func(int, []byte) bool) {
+		from = max(from, 0)
+		to = min(to, t.LineCount())
+		if from >= to {
+			return
+		}
+		lineIndex := from
+		var line []byte
+		for chunk := range t.Chunks(t.LineStart(from), t.LineEnd(to-1)) {
+			for len(chunk) > 0 {
+				i := bytes.IndexByte(chunk, '\n')
+				if i < 0 {
+					line = append(line, chunk...)
+					break
+				}
+				line = append(line, chunk[:i]...)
+				if !yield(lineIndex, line) {
+					return
+				}
+				lineIndex++
+				line = nil
+				chunk = chunk[i+1:]
+			}
+		}
+		yield(lineIndex, line)
+	}
+}
+
+// Insert inserts text at offset
+func (t *PieceTable) Insert(offset int, text []byte) {
+	if len(text) == 0 {
+		return
+	}
+	offset = min(max(offset, 0), t.Len())
+	left, right := splitPieces(t.root, offset)
+	addEnd := len(t.add.data)
+	t.add.append(text)
+	// typing usually inserts right after the previous insertion, so try to grow
+	// the last piece instead of creating a new one for every character
+	if !t.extendLastPiece(left, addEnd, len(text)) {
+		left = mergePieces(left, newPieceNode(t.add, addEnd, len(text)))
+	}
+	t.root = mergePieces(left, right)
+}
+
+func (t *PieceTable) extendLastPiece(n *pieceNode, addEnd, length int) bool {
+	if n == nil {
+		return false
+	}
+	if n.right != nil {
+		if !t.extendLastPiece(n.right, addEnd, length) {
+			return false
+		}
+		n.update()
+		return true
+	}
+	if n.buf != t.add || n.start+n.length != addEnd {
+		return false
+	}
+	n.length += length
+	_, n.lf = n.buf.countNewlines(n.start, n.start+n.length)
+	n.update()
+	return true
+}
+
+// Delete removes length bytes starting at offset
+func (t *PieceTable) Delete(offset, length int) {
+	if length <= 0 {
+		return
+	}
+	left, rest := splitPieces(t.root, offset)
+	_, right := splitPieces(rest, length)
+	t.root = mergePieces(left, right)
+}
+
+// WriteTo writes the whole document to w
+func (t *PieceTable) WriteTo(w io.Writer) (int64, error) {
+	var written int64
+	for chunk := range t.Chunks(0, t.Len()) {
+		n, err := w.Write(chunk)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
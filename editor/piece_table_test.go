@@ -0,0 +1,84 @@
+package editor
+
+import (
+	"bytes"
+	"github.com/test-go/testify/require"
+	"math/rand/v2"
+	"strings"
+	"testing"
+)
+
+func collectLines(d Document, from, to int) []string {
+	res := make([]string, 0, to-from)
+	for _, line := range d.Lines(from, to) {
+		res = append(res, string(line))
+	}
+	return res
+}
+
+func TestPieceTableLines(t *testing.T) {
+	d := NewPieceTable([]byte("abc\ndef\n\nghi"))
+	require.Equal(t, 4, d.LineCount())
+	require.Equal(t, 0, d.LineStart(0))
+	require.Equal(t, 4, d.LineStart(1))
+	require.Equal(t, 8, d.LineStart(2))
+	require.Equal(t, 9, d.LineStart(3))
+	require.Equal(t, 3, d.LineEnd(0))
+	require.Equal(t, 12, d.LineEnd(3))
+	require.Equal(t, "def", string(d.Line(1)))
+	require.Equal(t, "", string(d.Line(2)))
+	require.Equal(t, 1, d.LineAt(4))
+	require.Equal(t, 1, d.LineAt(7))
+	require.Equal(t, 3, d.LineAt(12))
+	require.Equal(t, []string{"abc", "def", "", "ghi"}, collectLines(d, 0, 10))
+	require.Equal(t, []string{"def", ""}, collectLines(d, 1, 3))
+
+	empty := NewPieceTable(nil)
+	require.Equal(t, 1, empty.LineCount())
+	require.Equal(t, []string{""}, collectLines(empty, 0, 1))
+}
+
+func TestPieceTableEdit(t *testing.T) {
+	d := NewPieceTable([]byte("hello world"))
+	d.Insert(5, []byte(","))
+	d.Insert(6, []byte("\nbig"))
+	require.Equal(t, "hello,\nbig world", string(d.Slice(0, d.Len())))
+	require.Equal(t, 2, d.LineCount())
+	d.Delete(3, 6)
+	require.Equal(t, "helg world", string(d.Slice(0, d.Len())))
+	require.Equal(t, 1, d.LineCount())
+
+	buf := bytes.Buffer{}
+	n, err := d.WriteTo(&buf)
+	require.NoError(t, err)
+	require.Equal(t, int64(10), n)
+	require.Equal(t, "helg world", buf.String())
+}
+
+func TestPieceTableRandomEdits(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	model := []byte("first line\nsecond line\nthird")
+	d := NewPieceTable(bytes.Clone(model))
+	alphabet := []byte("ab\nc")
+	for range 2000 {
+		if len(model) > 0 && r.IntN(3) == 0 {
+			offset := r.IntN(len(model))
+			length := r.IntN(min(len(model)-offset, 8)) + 1
+			model = append(model[:offset:offset], model[offset+length:]...)
+			d.Delete(offset, length)
+		} else {
+			offset := r.IntN(len(model) + 1)
+			text := []byte{alphabet[r.IntN(len(alphabet))], alphabet[r.IntN(len(alphabet))]}
+			model = append(model[:offset:offset], append(text, model[offset:]...)...)
+			d.Insert(offset, text)
+		}
+	}
+	require.Equal(t, string(model), string(d.Slice(0, d.Len())))
+	lines := strings.Split(string(model), "\n")
+	require.Equal(t, len(lines), d.LineCount())
+	require.Equal(t, lines, collectLines(d, 0, d.LineCount()))
+	for i, line := range lines {
+		require.Equal(t, line, string(d.Line(i)))
+		require.Equal(t, i, d.LineAt(d.LineStart(i)))
+	}
+}
@@ -0,0 +1,207 @@
+package editor
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// register holds text that was deleted or yanked, or keys recorded by q. The lines of
+// blockwise text are put at the same column on successive lines.
+type register struct {
+	text      []byte
+	linewise  bool
+	blockwise bool
+	// keys are the recorded keys, replayed by @ instead of the text, nil for text
+	keys []macroKey
+}
+
+// clipboard is the system clipboard, tcell screens set it with the OSC 52 escape sequence
+type clipboard interface {
+	SetClipboard(data []byte)
+}
+
+// systemClipboard receives the text written to the + and * registers, nil when there is
+// no terminal
+var systemClipboard clipboard
+
+// registers holds the text registers by name:
+//   - " is the unnamed register, it holds the text of the last delete, change or yank
+//   - 0 holds the last yank and 1 to 9 the history of the deletes of whole lines or of
+//     text across lines, the last one in 1
+//   - - holds the last delete within a line
+//   - a to z are the named registers, A to Z append to them
+//   - + and * are the system clipboard, they always hold the same text
+//
+// The macros are recorded into the same registers, and any register can be replayed.
+var registers = map[rune]register{}
+
+// isRegisterName returns true when r names a register, _ is the black hole register that
+// discards the text written to it
+func isRegisterName(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || strings.ContainsRune(`"-_+*`, r)
+}
+
+// getRegister returns the register with name, 0 for the unnamed register
+func getRegister(name rune) (register, bool) {
+	if name == 0 {
+		name = '"'
+	}
+	reg, ok := registers[unicode.ToLower(name)]
+	return reg, ok && len(reg.text) > 0
+}
+
+// storeRegister stores the text deleted or yanked by the operator op into the register
+// with name, 0 when no register was given. The unnamed register always gets the text.
+func storeRegister(name, op rune, reg register) {
+	switch {
+	case name == '_':
+		return
+	case name == 0 || name == '"':
+		if op == 'y' {
+			registers['0'] = reg
+		} else if reg.linewise || bytes.ContainsRune(reg.text, '\n') {
+			for i := '9'; i > '1'; i-- {
+				registers[i] = registers[i-1]
+			}
+			registers['1'] = reg
+		} else {
+			registers['-'] = reg
+		}
+	case unicode.IsUpper(name):
+		name = unicode.ToLower(name)
+		reg = appendRegister(registers[name], reg)
+		registers[name] = reg
+	case name == '+' || name == '*':
+		registers['+'] = reg
+		registers['*'] = reg
+		if systemClipboard != nil {
+			systemClipboard.SetClipboard(reg.text)
+		}
+	default:
+		registers[name] = reg
+	}
+	registers['"'] = reg
+}
+
+// appendRegister appends reg to the text of prev, the text is linewise when one of
+// them is
+func appendRegister(prev, reg register) register {
+	text := bytes.Clone(prev.text)
+	linewise := prev.linewise || reg.linewise
+	if linewise && len(text) > 0 && !bytes.HasSuffix(text, []byte("\n")) {
+		text = append(text, '\n')
+	}
+	text = append(text, reg.text...)
+	if linewise && !bytes.HasSuffix(text, []byte("\n")) {
+		text = append(text, '\n')
+	}
+	return register{text: text, linewise: linewise}
+}
+
+// setClipboardRegisters stores the content of the system clipboard sent by the terminal
+// into the + and * registers
+func setClipboardRegisters(data []byte) {
+	reg := register{text: bytes.Clone(data), linewise: bytes.HasSuffix(data, []byte("\n"))}
+	registers['+'] = reg
+	registers['*'] = reg
+}
+
+// registerLines returns a line for every non-empty register with its text, line breaks
+// and the other control characters are shown like ^J
+func registerLines() []string {
+	names := make([]rune, 0, len(registers))
+	for r, reg := range registers {
+		if len(reg.text) > 0 {
+			names = append(names, r)
+		}
+	}
+	// the unnamed register comes first, then the numbered, named and special ones
+	order := func(r rune) int {
+		if r == '"' {
+			return -1
+		}
+		return strings.IndexRune("0123456789abcdefghijklmnopqrstuvwxyz-+*", r)
+	}
+	slices.SortFunc(names, func(a, b rune) int { return order(a) - order(b) })
+	lines := make([]string, 0, len(names))
+	for _, r := range names {
+		var text strings.Builder
+		for _, c := range string(registers[r].text) {
+			if isControl(c) || c == '\t' {
+				text.WriteString(controlText(c))
+			} else {
+				text.WriteRune(c)
+			}
+		}
+		lines = append(lines, fmt.Sprintf(`"%c   %s`, r, text.String()))
+	}
+	return lines
+}
+
+// put inserts the text of reg count times after the cursor, or before it when before is
+// true. Linewise text is put below or above the current line.
+func (s *Tab) put(reg register, before bool, count int) {
+	if s.hexMode || len(reg.text) == 0 {
+		return
+	}
+	text := bytes.Repeat(reg.text, max(count, 1))
+	pos := s.GetCursor()
+
+	s.BeginChange()
+	defer s.EndChange()
+	if reg.blockwise {
+		s.putBlock(reg, before, count)
+		return
+	}
+	if reg.linewise {
+		line := pos.Line
+		if !before {
+			line++
+		}
+		if line < s.doc.LineCount() {
+			s.insertText(s.doc.LineStart(line), text)
+		} else {
+			// below the last line, the line break goes before the text
+			s.insertText(s.doc.Len(), append([]byte{'\n'}, text[:len(text)-1]...))
+		}
+		s.SetCursor(Position{Line: line, Col: s.indentCol(line)})
+		return
+	}
+
+	col := s.clampCol(pos.Line, pos.Col)
+	if !before {
+		col = s.moveCol(pos.Line, col, 1)
+	}
+	offset := s.offsetOf(pos.Line, col)
+	s.insertText(offset, text)
+	if bytes.ContainsRune(text, '\n') {
+		s.SetCursor(Position{Line: pos.Line, Col: col})
+		return
+	}
+	// the cursor ends on the last put character
+	end := s.positionOf(offset + len(text))
+	s.SetCursor(Position{Line: end.Line, Col: s.moveCol(end.Line, end.Col, -1)})
+}
+
+// putBlock puts the lines of blockwise text count times at the screen column of the
+// cursor, or after the cursor, on the current line and the following ones
+func (s *Tab) putBlock(reg register, before bool, count int) {
+	pos := s.GetCursor()
+	col := s.clampCol(pos.Line, pos.Col)
+	if !before {
+		col = s.moveCol(pos.Line, col, 1)
+	}
+	x := s.screenCol(pos.Line, col)
+	for i, line := range bytes.Split(reg.text, []byte("\n")) {
+		n := pos.Line + i
+		if n >= s.doc.LineCount() {
+			s.insertText(s.doc.Len(), []byte{'\n'})
+		}
+		s.padLine(n, x)
+		s.insertText(s.offsetOf(n, s.colAtScreen(n, x)), bytes.Repeat(line, max(count, 1)))
+	}
+	s.SetCursor(Position{Line: pos.Line, Col: col})
+}
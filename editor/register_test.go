@@ -0,0 +1,69 @@
+package editor
+
+import (
+	"github.com/test-go/testify/require"
+	"testing"
+)
+
+// fakeClipboard keeps the text written to the system clipboard
+type fakeClipboard struct {
+	data []byte
+}
+
+func (c *fakeClipboard) SetClipboard(data []byte) {
+	c.data = data
+}
+
+func TestYankAndPut(t *testing.T) {
+	registers = map[rune]register{}
+	w, tab := newTestWindow("one two\nthree")
+	typeKeys(w, "yiwP")
+	require.Equal(t, "oneone two\nthree", tabText(tab))
+	require.Equal(t, Position{Col: 2}, tab.GetCursor())
+	typeKeys(w, "2p")
+	require.Equal(t, "oneoneoneone two\nthree", tabText(tab))
+
+	typeKeys(w, "yyjp")
+	require.Equal(t, "oneoneoneone two\nthree\noneoneoneone two", tabText(tab))
+	require.Equal(t, Position{Line: 2}, tab.GetCursor())
+	typeKeys(w, "ggP")
+	require.Equal(t, "oneoneoneone two\noneoneoneone two\nthree\noneoneoneone two", tabText(tab))
+	require.Equal(t, Position{}, tab.GetCursor())
+}
+
+func TestNamedAndNumberedRegisters(t *testing.T) {
+	registers = map[rune]register{}
+	w, tab := newTestWindow("a\nb\nc\nd")
+	typeKeys(w, `"ayy"Ayyj"byyddddgg`)
+	require.Equal(t, register{text: []byte("a\na\n"), linewise: true}, registers['a'])
+	require.Equal(t, register{text: []byte("b\n"), linewise: true}, registers['b'])
+	require.Equal(t, register{text: []byte("c\n"), linewise: true}, registers['1'])
+	require.Equal(t, register{text: []byte("b\n"), linewise: true}, registers['2'])
+	require.Equal(t, "a\nd", tabText(tab))
+
+	typeKeys(w, `"_dd"ap`)
+	require.Equal(t, "d\na\na", tabText(tab))
+	require.Equal(t, register{text: []byte("c\n"), linewise: true}, registers['1'])
+
+	// small deletes go to the - register, yanks to 0
+	typeKeys(w, `xyy`)
+	require.Equal(t, "d\n\na", tabText(tab))
+	require.Equal(t, register{text: []byte("a")}, registers['-'])
+	require.Equal(t, register{text: []byte("\n"), linewise: true}, registers['0'])
+}
+
+func TestClipboardRegister(t *testing.T) {
+	registers = map[rune]register{}
+	c := &fakeClipboard{}
+	systemClipboard = c
+	defer func() { systemClipboard = nil }()
+
+	w, tab := newTestWindow("copy me")
+	typeKeys(w, `"+yiw`)
+	require.Equal(t, "copy", string(c.data))
+	require.Equal(t, registers['+'], registers['*'])
+
+	setClipboardRegisters([]byte("pasted "))
+	typeKeys(w, `"*P`)
+	require.Equal(t, "pasted copy me", tabText(tab))
+}
@@ -0,0 +1,455 @@
+package editor
+
+import (
+	"fmt"
+	"github.com/gdamore/tcell/v2"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// maxSearchCount is the number of matches above which the match counter shows >999
+const maxSearchCount = 999
+
+// maxSearchCountLen is the number of bytes read to count the matches, the counter shows ?
+// when it is not known from them
+const maxSearchCountLen = 1 << 20
+
+// searchWindowMargin is the number of bytes around the part of a long line on screen that
+// are searched with it, for the matches that start or end off screen
+const searchWindowMargin = 1024
+
+// searchStyle is the style of the matches of the last search
+var searchStyle = tcell.StyleDefault.Background(tcell.ColorYellow).Foreground(tcell.ColorBlack)
+
+// lastSearch is the last searched pattern, it is shared by the tabs and repeated by n and N
+var lastSearch struct {
+	pattern  string
+	backward bool
+	// hidden is true after :nohlsearch, until the next search
+	hidden bool
+}
+
+// previewPattern is the pattern being typed after / or ?, nil when not searching
+var previewPattern *searchRegexp
+
+// searchRegexp is a compiled search pattern. Like in vim, a pattern starting with \< only
+// matches at the start of a word and a pattern ending with \> only at the end of one. The
+// words are the ones of charClass, the \b of Go only knows ASCII letters.
+type searchRegexp struct {
+	re                 *regexp.Regexp
+	wordStart, wordEnd bool
+}
+
+// compileSearch compiles a search pattern with the Go regexp syntax. With ignorecase the
+// case is ignored, unless smartcase is set and the pattern has an uppercase letter.
+func compileSearch(pattern string) (*searchRegexp, error) {
+	return compilePattern(pattern, globalOptions.ignoreCase && !(globalOptions.smartCase && hasUpper(pattern)))
+}
+
+// compiledSearch is the last compiled pattern, which is compiled again and again to
+// highlight its matches and to repeat the search
+var compiledSearch struct {
+	pattern    string
+	ignoreCase bool
+	re         *searchRegexp
+}
+
+// compilePattern compiles a search pattern, ignoring the case with ignoreCase
+func compilePattern(pattern string, ignoreCase bool) (*searchRegexp, error) {
+	if c := compiledSearch; c.re != nil && c.pattern == pattern && c.ignoreCase == ignoreCase {
+		return c.re, nil
+	}
+	key := pattern
+	res := &searchRegexp{}
+	pattern, res.wordStart = strings.CutPrefix(pattern, `\<`)
+	if strings.HasSuffix(pattern, `\>`) && !isEscaped(pattern, len(pattern)-2) {
+		pattern, res.wordEnd = pattern[:len(pattern)-2], true
+	}
+	if ignoreCase {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern: %w", err)
+	}
+	res.re = re
+	compiledSearch.pattern, compiledSearch.ignoreCase, compiledSearch.re = key, ignoreCase, res
+	return res, nil
+}
+
+// isEscaped returns true when the character at i of pattern follows an odd number of
+// backslashes
+func isEscaped(pattern string, i int) bool {
+	n := 0
+	for i > 0 && pattern[i-1] == '\\' {
+		n++
+		i--
+	}
+	return n%2 == 1
+}
+
+// matches returns the indexes of the first n matches of line and of their groups, of
+// all of them when n < 0
+func (r *searchRegexp) matches(line []byte, n int) [][]int {
+	if !r.wordStart && !r.wordEnd {
+		return r.re.FindAllSubmatchIndex(line, n)
+	}
+	var res [][]int
+	for _, m := range r.re.FindAllSubmatchIndex(line, -1) {
+		if before, after := wordsAround(line, m[0]); r.wordStart && (before || !after) {
+			continue
+		}
+		if before, after := wordsAround(line, m[1]); r.wordEnd && (!before || after) {
+			continue
+		}
+		res = append(res, m)
+		if len(res) == n {
+			break
+		}
+	}
+	return res
+}
+
+// match returns true when line has a match
+func (r *searchRegexp) match(line []byte) bool {
+	return len(r.matches(line, 1)) > 0
+}
+
+// wordsAround returns whether the characters before and after the offset i of line are
+// word characters
+func wordsAround(line []byte, i int) (before, after bool) {
+	if i > 0 {
+		r, _ := decodeLastRune(line[:i])
+		before = charClass(r, false) == 2
+	}
+	if i < len(line) {
+		r, _ := decodeRune(line[i:])
+		after = charClass(r, false) == 2
+	}
+	return before, after
+}
+
+// hasUpper returns true when pattern has an uppercase letter, the escaped characters
+// like \S or \W are classes rather than letters
+func hasUpper(pattern string) bool {
+	escaped := false
+	for _, r := range pattern {
+		if !escaped && unicode.IsUpper(r) {
+			return true
+		}
+		escaped = !escaped && r == '\\'
+	}
+	return false
+}
+
+// highlightPattern returns the pattern whose matches are highlighted, nil for none
+func highlightPattern() *searchRegexp {
+	if previewPattern != nil {
+		return previewPattern
+	}
+	if lastSearch.pattern == "" || lastSearch.hidden || !globalOptions.hlSearch {
+		return nil
+	}
+	re, _ := compileSearch(lastSearch.pattern)
+	return re
+}
+
+// lineMatches returns the columns [start, end) of the matches of re in line n
+func (s *Tab) lineMatches(re *searchRegexp, n int) [][2]int {
+	start := s.doc.LineStart(n)
+	indexes := re.matches(s.doc.Line(n), -1)
+	if len(indexes) == 0 {
+		return nil
+	}
+	// the byte offsets of the matches are converted to columns in a single pass
+	matches := make([][2]int, len(indexes))
+	i, col := 0, 0
+	convert := func(offset int) {
+		for ; i < 2*len(indexes) && indexes[i/2][i%2] <= offset; i++ {
+			matches[i/2][i%2] = col
+		}
+	}
+	for offset := range docRunes(s.doc, start, s.doc.LineEnd(n)) {
+		convert(offset - start)
+		col++
+	}
+	convert(s.doc.LineEnd(n) - start)
+	return matches
+}
+
+// windowMatches returns the columns [start, end) of the matches of re in line n that
+// overlap the columns [from, to). Short lines are searched whole, long lines only around
+// those columns and their matches are clipped to them, so highlighting the matches on
+// screen stays cheap on very long lines.
+func (s *Tab) windowMatches(re *searchRegexp, n, from, to int) [][2]int {
+	if s.colIndex(n) == nil {
+		return s.lineMatches(re, n)
+	}
+	// the starts of the clusters of the window map the offsets of the matches to columns
+	lineLen := s.doc.LineEnd(n) - s.doc.LineStart(n)
+	var starts []colMark
+	end := colMark{offset: lineLen}
+	for m, c := range s.lineClustersFrom(n, func(m colMark) bool { return m.col <= from }) {
+		if m.col >= to {
+			end = m
+			break
+		}
+		if c.end() > from {
+			starts = append(starts, m)
+		}
+		end.col = c.end()
+	}
+	if len(starts) == 0 {
+		return nil
+	}
+	start := starts[0]
+	winStart := max(start.offset-searchWindowMargin, 0)
+	winEnd := min(end.offset+searchWindowMargin, lineLen)
+	// a line break around a window inside the line keeps ^ and $ from matching at its ends
+	var text []byte
+	prefix := 0
+	if winStart > 0 {
+		text, prefix = append(text, '\n'), 1
+	}
+	text = append(text, s.doc.Slice(s.doc.LineStart(n)+winStart, winEnd-winStart)...)
+	if winEnd < lineLen {
+		text = append(text, '\n')
+	}
+	colOf := func(offset int) int {
+		switch {
+		case offset <= start.offset:
+			return start.col
+		case offset >= end.offset:
+			return end.col
+		}
+		i, _ := slices.BinarySearchFunc(starts, offset, func(m colMark, offset int) int { return m.offset - offset })
+		if i == len(starts) {
+			return end.col
+		}
+		return starts[i].col
+	}
+	var matches [][2]int
+	for _, m := range re.matches(text, -1) {
+		mStart := min(max(m[0]-prefix, 0), winEnd-winStart) + winStart
+		mEnd := min(max(m[1]-prefix, 0), winEnd-winStart) + winStart
+		if mEnd <= start.offset || mStart >= end.offset {
+			continue
+		}
+		matches = append(matches, [2]int{colOf(mStart), colOf(mEnd)})
+	}
+	return matches
+}
+
+// searchFrom returns the start of the count-th match of re after pos, or before pos when
+// backward is true. The search wraps around the end of the text.
+func (s *Tab) searchFrom(re *searchRegexp, pos Position, backward bool, count int) (Position, bool) {
+	lineCount := s.doc.LineCount()
+	for range max(count, 1) {
+		found := false
+		// the line of pos is searched again last, for the matches on the other side of pos
+		for i := 0; i <= lineCount && !found; i++ {
+			n := (pos.Line + i) % lineCount
+			if backward {
+				n = ((pos.Line-i)%lineCount + lineCount) % lineCount
+			}
+			matches := s.lineMatches(re, n)
+			if backward {
+				for j := len(matches) - 1; j >= 0; j-- {
+					col := matches[j][0]
+					if (i == 0 && col >= pos.Col) || (i == lineCount && col < pos.Col) {
+						continue
+					}
+					pos, found = Position{Line: n, Col: col}, true
+					break
+				}
+				continue
+			}
+			for _, m := range matches {
+				col := m[0]
+				if (i == 0 && col <= pos.Col) || (i == lineCount && col > pos.Col) {
+					continue
+				}
+				pos, found = Position{Line: n, Col: col}, true
+				break
+			}
+		}
+		if !found {
+			return Position{}, false
+		}
+	}
+	return pos, true
+}
+
+// matchIndex returns the number of the match of re at pos and the number of matches,
+// both limited to maxSearchCount+1. They are -1 when they are not known from the first
+// maxSearchCountLen bytes of the text.
+func (s *Tab) matchIndex(re *searchRegexp, pos Position) (int, int) {
+	index, total, read := 0, 0, 0
+	for n := 0; n < s.doc.LineCount() && total <= maxSearchCount; n++ {
+		read += s.doc.LineEnd(n) - s.doc.LineStart(n) + 1
+		if read > maxSearchCountLen {
+			if n <= pos.Line {
+				return -1, -1
+			}
+			return min(index, maxSearchCount+1), -1
+		}
+		for _, m := range s.lineMatches(re, n) {
+			total++
+			if n < pos.Line || (n == pos.Line && m[0] <= pos.Col) {
+				index = total
+			}
+		}
+	}
+	return min(index, maxSearchCount+1), min(total, maxSearchCount+1)
+}
+
+// searchMotion moves to the count-th match of the last search, in the direction of the
+// search or in the other one with reverse
+func searchMotion(reverse bool) motionFunc {
+	return func(s *Tab, pos Position, count int, _ rune) (Position, bool) {
+		if lastSearch.pattern == "" {
+			return Position{}, false
+		}
+		re, err := compileSearch(lastSearch.pattern)
+		if err != nil {
+			return Position{}, false
+		}
+		lastSearch.hidden = false
+		return s.searchFrom(re, pos, lastSearch.backward != reverse, count)
+	}
+}
+
+// searchWord searches the word under the cursor as a whole word, forward for * and
+// backward for #
+func searchWord(backward bool) motionFunc {
+	return func(s *Tab, pos Position, count int, arg rune) (Position, bool) {
+		word, ok := wordObject(false, false)(s, pos, 1)
+		if !ok || charClass(s.charAt(word.start), false) == 0 {
+			return Position{}, false
+		}
+		start, end := s.rangeOffsets(word)
+		text := string(s.doc.Slice(start, end-start))
+		pattern := regexp.QuoteMeta(text)
+		if charClass(s.charAt(word.start), false) == 2 {
+			pattern = `\<` + pattern + `\>`
+		}
+		lastSearch.pattern, lastSearch.backward = pattern, backward
+		// the search starts from the start of the word, so that # skips it
+		return searchMotion(false)(s, word.start, count, arg)
+	}
+}
+
+// searchInfo returns the message shown after moving to a match, the pattern and the
+// match counter like /foo [3/17]
+func (s *Tab) searchInfo() string {
+	prompt := '/'
+	if lastSearch.backward {
+		prompt = '?'
+	}
+	re, err := compileSearch(lastSearch.pattern)
+	if err != nil {
+		return err.Error()
+	}
+	index, total := s.matchIndex(re, s.GetCursor())
+	counter := func(n int) string {
+		if n > maxSearchCount {
+			return fmt.Sprintf(">%d", maxSearchCount)
+		}
+		if n < 0 {
+			return "?"
+		}
+		return fmt.Sprint(n)
+	}
+	if index > maxSearchCount {
+		index = -1
+	}
+	return fmt.Sprintf("%c%s [%s/%s]", prompt, lastSearch.pattern, counter(index), counter(total))
+}
+
+// searchStart is where the cursor and the view were when a search started, they are
+// restored when the search is cancelled
+type searchStart struct {
+	pos                      Position
+	topLine, topRow, leftCol int
+}
+
+// previewSearch moves the cursor of tab to the first match of the pattern being typed,
+// from where it was when the search started
+func (s *Window) previewSearch(tab *Tab, pattern string, backward bool) {
+	if s.searchStart == nil {
+		s.searchStart = &searchStart{pos: tab.GetCursor(), topLine: tab.topLine, topRow: tab.topRow, leftCol: tab.leftCol}
+	}
+	s.restoreSearchStart(tab, false)
+	previewPattern = nil
+	if pattern == "" {
+		return
+	}
+	re, err := compileSearch(pattern)
+	if err != nil {
+		return
+	}
+	previewPattern = re
+	if target, ok := tab.searchFrom(re, s.searchStart.pos, backward, 1); ok {
+		tab.SetCursor(target)
+	}
+}
+
+// restoreSearchStart moves the cursor and the view back to where they were when the
+// search started, and with end forgets it
+func (s *Window) restoreSearchStart(tab *Tab, end bool) {
+	if s.searchStart == nil {
+		return
+	}
+	start := s.searchStart
+	tab.SetCursor(start.pos)
+	tab.topLine, tab.topRow, tab.leftCol = start.topLine, start.topRow, start.leftCol
+	if end {
+		s.searchStart = nil
+		previewPattern = nil
+	}
+}
+
+// search searches pattern from the cursor of tab and moves to the match, an empty
+// pattern searches the last pattern again
+func (s *Window) search(tab *Tab, pattern string, backward bool) {
+	if pattern == "" {
+		pattern = lastSearch.pattern
+	}
+	if pattern == "" {
+		GlobalState.ToastMessage("no previous search pattern")
+		return
+	}
+	re, err := compileSearch(pattern)
+	if err != nil {
+		GlobalState.ToastMessage(err.Error())
+		return
+	}
+	lastSearch.pattern, lastSearch.backward, lastSearch.hidden = pattern, backward, false
+	target, ok := tab.searchFrom(re, tab.GetCursor(), backward, 1)
+	if !ok {
+		GlobalState.ToastMessage("pattern not found: " + pattern)
+		return
+	}
+	tab.SetCursor(target)
+	GlobalState.InfoMessage(tab.searchInfo())
+}
+
+// isSearchMotion returns true for the motions that move to a match of the last search
+func isSearchMotion(keys string) bool {
+	return keys == "n" || keys == "N" || keys == "*" || keys == "#"
+}
+
+// showSearchResult shows the match counter after a search motion, or that there is no
+// match when the motion failed
+func showSearchResult(tab *Tab, moved bool) {
+	switch {
+	case lastSearch.pattern == "":
+		GlobalState.ToastMessage("no previous search pattern")
+	case !moved:
+		GlobalState.ToastMessage("pattern not found: " + lastSearch.pattern)
+	default:
+		GlobalState.InfoMessage(tab.searchInfo())
+	}
+}
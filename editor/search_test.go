@@ -0,0 +1,152 @@
+package editor
+
+import (
+	"github.com/test-go/testify/require"
+	"strings"
+	"testing"
+)
+
+func resetSearch(t *testing.T) {
+	lastSearch.pattern, lastSearch.backward, lastSearch.hidden = "", false, false
+	t.Cleanup(func() {
+		globalOptions.ignoreCase, globalOptions.smartCase = false, false
+		lastSearch.pattern = ""
+	})
+}
+
+func TestSearch(t *testing.T) {
+	resetSearch(t)
+	w, tab := newTestWindow("foo bar\nFoo baz foo\nqux")
+	typeKeys(w, "/foo<CR>")
+	require.Equal(t, Position{Line: 1, Col: 8}, tab.GetCursor())
+	require.Equal(t, "/foo [2/2]", tab.searchInfo())
+	typeKeys(w, "n")
+	require.Equal(t, Position{Line: 0, Col: 0}, tab.GetCursor())
+	typeKeys(w, "N")
+	require.Equal(t, Position{Line: 1, Col: 8}, tab.GetCursor())
+	typeKeys(w, "?ba<CR>")
+	require.Equal(t, Position{Line: 1, Col: 4}, tab.GetCursor())
+	typeKeys(w, "2n")
+	require.Equal(t, Position{Line: 1, Col: 4}, tab.GetCursor())
+	typeKeys(w, "N")
+	require.Equal(t, Position{Line: 0, Col: 4}, tab.GetCursor())
+
+	typeKeys(w, "gg/<CR>")
+	require.Equal(t, Position{Line: 0, Col: 4}, tab.GetCursor())
+
+	typeKeys(w, "ggdn")
+	require.Equal(t, "bar\nFoo baz foo\nqux", tabText(tab))
+}
+
+func TestSearchIgnoreCase(t *testing.T) {
+	resetSearch(t)
+	w, tab := newTestWindow("foo bar\nFoo baz foo")
+	globalOptions.ignoreCase = true
+	typeKeys(w, "/foo<CR>")
+	require.Equal(t, Position{Line: 1, Col: 0}, tab.GetCursor())
+
+	globalOptions.smartCase = true
+	typeKeys(w, "/foo<CR>")
+	require.Equal(t, Position{Line: 1, Col: 8}, tab.GetCursor())
+	typeKeys(w, "/Foo<CR>")
+	require.Equal(t, Position{Line: 1, Col: 0}, tab.GetCursor())
+	// \W is a class, not an uppercase letter
+	typeKeys(w, `/FOO\W<CR>`)
+	require.Equal(t, Position{Line: 1, Col: 0}, tab.GetCursor())
+	typeKeys(w, `gg/o\W<CR>`)
+	require.Equal(t, Position{Line: 0, Col: 2}, tab.GetCursor())
+}
+
+func TestSearchPreview(t *testing.T) {
+	resetSearch(t)
+	w, tab := newTestWindow("foo bar\nbaz")
+	typeKeys(w, "/ba")
+	require.Equal(t, Position{Line: 0, Col: 4}, tab.GetCursor())
+	typeKeys(w, "z")
+	require.Equal(t, Position{Line: 1, Col: 0}, tab.GetCursor())
+	require.Equal(t, "/baz", GlobalState.getInfoLine())
+	typeKeys(w, "<BS>")
+	require.Equal(t, Position{Line: 0, Col: 4}, tab.GetCursor())
+
+	typeKeys(w, "<Esc>")
+	require.Equal(t, Position{Line: 0, Col: 0}, tab.GetCursor())
+	require.True(t, GlobalState.IsMode(ModeView))
+	require.Nil(t, previewPattern)
+	require.Equal(t, "", lastSearch.pattern)
+}
+
+func TestSearchWord(t *testing.T) {
+	resetSearch(t)
+	w, tab := newTestWindow("foo foobar\nbar foo")
+	typeKeys(w, "*")
+	require.Equal(t, Position{Line: 1, Col: 4}, tab.GetCursor())
+	typeKeys(w, "*")
+	require.Equal(t, Position{Line: 0, Col: 0}, tab.GetCursor())
+	typeKeys(w, "#")
+	require.Equal(t, Position{Line: 1, Col: 4}, tab.GetCursor())
+	typeKeys(w, "n")
+	require.Equal(t, Position{Line: 0, Col: 0}, tab.GetCursor())
+
+	// the words are not only made of ASCII letters
+	w, tab = newTestWindow("là xlà\nước là")
+	typeKeys(w, "*")
+	require.Equal(t, Position{Line: 1, Col: 4}, tab.GetCursor())
+	require.Equal(t, `/\<là\> [2/2]`, tab.searchInfo())
+	typeKeys(w, "n")
+	require.Equal(t, Position{Line: 0, Col: 0}, tab.GetCursor())
+	typeKeys(w, "j#")
+	require.Equal(t, Position{Line: 1, Col: 0}, tab.GetCursor())
+}
+
+func TestLineMatches(t *testing.T) {
+	_, tab := newTestWindow("héllo héllo\n")
+	compile := func(pattern string) *searchRegexp {
+		re, err := compileSearch(pattern)
+		require.NoError(t, err)
+		return re
+	}
+	require.Equal(t, [][2]int{{2, 5}, {8, 11}}, tab.lineMatches(compile("llo"), 0))
+	require.Nil(t, tab.lineMatches(compile("x"), 0))
+	require.Nil(t, tab.lineMatches(compile(`\<llo`), 0))
+	require.Equal(t, [][2]int{{0, 5}, {6, 11}}, tab.lineMatches(compile(`\<h\S+\>`), 0))
+	require.Equal(t, [][2]int{{2, 5}, {8, 11}}, tab.lineMatches(compile(`llo\>`), 0))
+	require.Nil(t, tab.lineMatches(compile(`ll\>`), 0))
+	// an escaped backslash before > is not the end of a word
+	require.Nil(t, tab.lineMatches(compile(`o\\>`), 0))
+}
+
+func TestWindowMatches(t *testing.T) {
+	// a line long enough to be indexed, with a match every 10 columns, the matches are
+	// clipped to the window
+	line := strings.Repeat("foo bar x\t", 1000)
+	_, tab := newTestWindow(line + "\nfoo")
+	re, err := compileSearch("bar x")
+	require.NoError(t, err)
+	require.Equal(t, [][2]int{{4005, 4009}, {4014, 4015}}, tab.windowMatches(re, 0, 4005, 4015))
+	require.Equal(t, [][2]int{{4, 5}}, tab.windowMatches(re, 0, 0, 5))
+	require.Equal(t, [][2]int{{9994, 9999}}, tab.windowMatches(re, 0, 9990, 20000))
+	// the last pattern is compiled once
+	again, err := compileSearch("bar x")
+	require.NoError(t, err)
+	require.True(t, re == again)
+
+	// ^ and $ only match at the ends of the line, not at the ends of the window
+	re, err = compileSearch("^foo|\t$")
+	require.NoError(t, err)
+	require.Equal(t, [][2]int{{0, 3}}, tab.windowMatches(re, 0, 0, 5))
+	require.Nil(t, tab.windowMatches(re, 0, 5000, 5100))
+	require.Equal(t, [][2]int{{9999, 10000}}, tab.windowMatches(re, 0, 9990, 10000))
+	require.Equal(t, [][2]int{{0, 3}}, tab.windowMatches(re, 1, 0, 5))
+}
+
+func TestSearchCountLimit(t *testing.T) {
+	resetSearch(t)
+	// the matches after the first maxSearchCountLen bytes are not counted
+	w, tab := newTestWindow("foo\n" + strings.Repeat("x", maxSearchCountLen) + "\nfoo")
+	typeKeys(w, "/foo<CR>")
+	require.Equal(t, Position{Line: 2, Col: 0}, tab.GetCursor())
+	require.Equal(t, "/foo [?/?]", tab.searchInfo())
+	typeKeys(w, "n")
+	require.Equal(t, Position{Line: 0, Col: 0}, tab.GetCursor())
+	require.Equal(t, "/foo [1/?]", tab.searchInfo())
+}
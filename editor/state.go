@@ -14,6 +14,14 @@
 	ModeInsert
 	// ModeCommand is the command mode
 	ModeCommand
+	// ModeOperatorPending is the mode after an operator like d, waiting for a motion or a text object
+	ModeOperatorPending
+	// ModeVisual is the characterwise visual mode, started with v
+	ModeVisual
+	// ModeVisualLine is the linewise visual mode, started with V
+	ModeVisualLine
+	// ModeVisualBlock is the blockwise visual mode, started with ctrl-v
+	ModeVisualBlock
 )
 
 var _ layout.Element = (*State)(nil)
@@ -22,17 +30,57 @@
 // State represents the state of the editor
 type State struct {
 	layout.BaseElement
-	mode           int
-	errorMessage   string
+	mode         int
+	errorMessage string
+	// isInfoMessage is true when errorMessage is an informative message rather than an error
+	isInfoMessage  bool
 	pendingCommand *Line
 	cursorX        int
 	finished       bool
+	// tabInfo is the information about the active tab shown at the right of the status line
+	tabInfo string
+	// pendingKeys holds the start of a multi-key view mode command, e.g. g or f
+	pendingKeys string
+	// count is the count typed before a view mode command and operatorCount the one
+	// typed after an operator, like in 2d3w. 0 means that no count was typed.
+	count         int
+	operatorCount int
+	// register is the register given with "{reg} before a view mode command, 0 for none
+	register rune
+	// recording is the register of the macro being recorded, 0 when not recording
+	recording rune
+	// messageLines is a message of several lines shown above the status line until the
+	// next key
+	messageLines []string
+	// prompt is the character before the command line: ':' for a command, '/' or '?'
+	// for a search
+	prompt rune
+	// confirmPrompt is the question shown in the status line until the next key, which
+	// answers it
+	confirmPrompt string
+	// history holds the submitted command lines. historyIndex is the entry shown while
+	// browsing them with up and down, -1 when not browsing, and historyPrefix the text
+	// typed before browsing, the entries shown start with it.
+	history       *history
+	historyIndex  int
+	historyPrefix string
+	// insertRegister is true after ctrl-r in the command line, the next key names the
+	// register to insert
+	insertRegister bool
+	// completion is the completion shown in the wildmenu, nil when none
+	completion *completion
+	// replaying is the number of macros and :normal commands typing keys, the command
+	// lines they submit are not added to the history
+	replaying int
 }
 
 // NewState creates a new state
 func NewState() *State {
 	s := &State{
-		mode: ModeView,
+		mode:         ModeView,
+		prompt:       ':',
+		history:      newHistory(),
+		historyIndex: -1,
 	}
 	s.initEventListeners()
 	return s
@@ -40,42 +88,276 @@ func NewState() *State {
 
 func (s *State) initEventListeners() {
 	OnEvent(func(e KeyEvent) {
+		s.messageLines = nil
+		if s.confirmPrompt != "" {
+			answer := e.Ev.Rune()
+			if e.Ev.Key() == tcell.KeyEscape {
+				answer = 'q'
+			}
+			if answer != 0 {
+				s.confirmPrompt = ""
+				EmitEvent(ConfirmEvent{Answer: answer})
+			}
+			return
+		}
+		if s.IsMode(ModeCommand) && s.handleCommandLineKey(e.Ev) {
+			return
+		}
 		switch e.Ev.Key() {
 		case tcell.KeyEscape:
+			s.pendingKeys = ""
+			s.takeCount()
 			if !s.IsMode(ModeView) {
 				s.SetMode(ModeView)
+			} else {
+				s.emitCommand("<Esc>")
 			}
 		case tcell.KeyEnter:
 			if s.IsMode(ModeCommand) {
-				cmd := s.GetCommand()
+				cmd, prompt := s.GetCommand(), s.prompt
 				s.SetMode(ModeView)
+				if s.replaying == 0 {
+					if err := s.history.add(prompt, cmd); err != nil {
+						s.ToastMessage(fmt.Sprintf("err: %v", err))
+					}
+				}
+				if isSearchPrompt(prompt) {
+					EmitEvent(SearchEvent{Pattern: cmd, Backward: prompt == '?'})
+					return
+				}
 				EmitEvent(SubmittedCommandEvent{Command: cmd})
 			}
 		case tcell.KeyBackspace, tcell.KeyBackspace2:
 			if s.IsMode(ModeCommand) {
+				// like vim, deleting the empty search pattern cancels the search
+				if isSearchPrompt(s.prompt) && s.pendingCommand.Len() == 0 {
+					s.SetMode(ModeView)
+					return
+				}
 				s.Delete()
+				s.previewSearch()
 				return
 			}
+		case tcell.KeyCtrlR:
+			if s.IsMode(ModeView) {
+				s.pendingKeys = ""
+				s.emitCommand("<C-r>")
+			}
+		case tcell.KeyCtrlN:
+			if s.IsMode(ModeView) {
+				s.pendingKeys = ""
+				s.emitCommand("<C-n>")
+			} else if isVisualMode(s.mode) {
+				s.pendingKeys = ""
+				s.takeCount()
+				EmitEvent(VisualCommandEvent{Keys: "<C-n>"})
+			}
+		case tcell.KeyUp, tcell.KeyDown:
+			// the editor only sends ctrl-up and ctrl-down, to add cursors
+			if s.IsMode(ModeView) {
+				s.pendingKeys = ""
+				s.emitCommand(map[tcell.Key]string{tcell.KeyUp: "<C-Up>", tcell.KeyDown: "<C-Down>"}[e.Ev.Key()])
+			}
+		case tcell.KeyCtrlV:
+			if s.IsMode(ModeView) || isVisualMode(s.mode) {
+				s.toggleVisual(ModeVisualBlock)
+			}
 		default:
 			if e.Ev.Rune() == 0 {
 				return
 			}
 			if s.IsMode(ModeView) {
-				switch e.Ev.Rune() {
-				case 'i':
-					s.SetMode(ModeInsert)
-				case ':':
-					s.SetMode(ModeCommand)
-				}
+				s.handleViewKey(e.Ev.Rune())
+				return
+			}
+			if s.IsMode(ModeOperatorPending) {
+				s.handleOperatorKey(e.Ev.Rune())
+				return
+			}
+			if isVisualMode(s.mode) {
+				s.handleVisualKey(e.Ev.Rune())
 				return
 			}
 			if s.IsMode(ModeCommand) {
 				s.AppendToCommand(e.Ev.Rune())
+				s.previewSearch()
 			}
 		}
 	})
 }
 
+func (s *State) handleViewKey(r rune) {
+	if s.pendingKeys == "" && isCountDigit(r, s.count) {
+		s.count = s.count*10 + int(r-'0')
+		return
+	}
+	keys := s.pendingKeys + string(r)
+	s.pendingKeys = ""
+	switch keys {
+	case ":":
+		s.takeCount()
+		s.SetMode(ModeCommand)
+	case "/", "?":
+		s.takeCount()
+		s.SetMode(ModeCommand)
+		s.prompt = []rune(keys)[0]
+	case "g", "@", `"`, "m":
+		s.pendingKeys = keys
+	case "q":
+		if s.recording != 0 {
+			s.takeCount()
+			EmitEvent(MacroEvent{Keys: keys})
+		} else {
+			s.pendingKeys = keys
+		}
+	case "@@":
+		EmitEvent(MacroEvent{Keys: keys, Count: s.takeCount()})
+	case "d", "c", "y", ">", "<":
+		s.SetMode(ModeOperatorPending)
+		s.pendingKeys = keys
+	case "i", "x", "X", "p", "P", ".", "u", "g-", "g+":
+		s.emitCommand(keys)
+	case "v":
+		s.toggleVisual(ModeVisual)
+	case "V":
+		s.toggleVisual(ModeVisualLine)
+	default:
+		if runes := []rune(keys); len(runes) == 2 && (runes[0] == 'q' || runes[0] == '@') {
+			count := s.takeCount()
+			// keys are recorded into the named and numbered registers, any register is replayed
+			if (runes[0] == 'q' && isMacroRegister(runes[1])) || (runes[0] == '@' && isRegisterName(runes[1])) {
+				EmitEvent(MacroEvent{Keys: keys, Count: count})
+			}
+			return
+		}
+		if runes := []rune(keys); len(runes) == 2 && runes[0] == 'm' {
+			if isMarkName(runes[1]) {
+				s.emitCommand(keys)
+			} else {
+				s.takeCount()
+			}
+			return
+		}
+		s.handleMotionKeys(keys)
+	}
+}
+
+// handleVisualKey handles a key typed in visual mode, the operators act on the selection
+func (s *State) handleVisualKey(r rune) {
+	if s.pendingKeys == "" && isCountDigit(r, s.count) {
+		s.count = s.count*10 + int(r-'0')
+		return
+	}
+	keys := s.pendingKeys + string(r)
+	s.pendingKeys = ""
+	switch keys {
+	case ":":
+		// the command acts on the selected lines
+		s.takeCount()
+		s.SetMode(ModeCommand)
+		s.WriteToCommand("'<,'>")
+	case "g", `"`:
+		s.pendingKeys = keys
+	case "v":
+		s.toggleVisual(ModeVisual)
+	case "V":
+		s.toggleVisual(ModeVisualLine)
+	case "o", "d", "x", "y", "c", ">", "<", "~", "u", "U", "J", "I", "A":
+		register := s.register
+		EmitEvent(VisualCommandEvent{Keys: keys, Count: s.takeCount(), Register: register})
+	default:
+		s.handleMotionKeys(keys)
+	}
+}
+
+// handleMotionKeys handles a register given with "{reg} or a motion
+func (s *State) handleMotionKeys(keys string) {
+	if runes := []rune(keys); len(runes) == 2 && runes[0] == '"' {
+		if isRegisterName(runes[1]) {
+			s.register = runes[1]
+		} else {
+			s.takeCount()
+		}
+		return
+	}
+	switch _, _, status := parseMotion(keys); status {
+	case keysPending:
+		s.pendingKeys = keys
+	case keysComplete:
+		s.emitCommand(keys)
+	default:
+		s.takeCount()
+	}
+}
+
+// toggleVisual starts the visual mode m, or goes back to view mode when it is the current
+// mode
+func (s *State) toggleVisual(m int) {
+	s.takeCount()
+	if s.IsMode(m) {
+		s.SetMode(ModeView)
+		return
+	}
+	s.SetMode(m)
+}
+
+// isCountDigit returns true when r continues the count, a count cannot start with 0
+// because 0 is a motion
+func isCountDigit(r rune, count int) bool {
+	return (r >= '1' && r <= '9') || (r == '0' && count > 0)
+}
+
+// takeCount returns the count of the command and resets it and the register, the
+// counts typed before and after an operator are multiplied
+func (s *State) takeCount() int {
+	count := s.count
+	if s.operatorCount > 0 {
+		count = max(count, 1) * s.operatorCount
+	}
+	s.count, s.operatorCount = 0, 0
+	s.register = 0
+	return count
+}
+
+// emitCommand emits the view mode command keys with its count and register
+func (s *State) emitCommand(keys string) {
+	register := s.register
+	EmitEvent(NormalCommandEvent{Keys: keys, Count: s.takeCount(), Register: register})
+}
+
+// handleOperatorKey handles a key typed after an operator. The operator command is
+// executed once its motion or text object is complete.
+func (s *State) handleOperatorKey(r rune) {
+	if len([]rune(s.pendingKeys)) == 1 && isCountDigit(r, s.operatorCount) {
+		s.operatorCount = s.operatorCount*10 + int(r-'0')
+		return
+	}
+	keys := s.pendingKeys + string(r)
+	switch _, status := parseOperator(keys); status {
+	case keysPending:
+		s.pendingKeys = keys
+	case keysComplete:
+		s.SetMode(ModeView)
+		s.emitCommand(keys)
+	default:
+		s.takeCount()
+		s.SetMode(ModeView)
+	}
+}
+
+// isIdle returns true in view mode when no command is partly typed or waiting for an
+// answer
+func (s *State) isIdle() bool {
+	return s.IsMode(ModeView) && s.pendingKeys == "" && s.count == 0 && s.operatorCount == 0 &&
+		s.register == 0 && s.confirmPrompt == ""
+}
+
+// IsNormalMode returns true in the modes where the keys are commands rather than text:
+// view, operator-pending and visual modes
+func (s *State) IsNormalMode() bool {
+	return s.IsMode(ModeView) || s.IsMode(ModeOperatorPending) || isVisualMode(s.mode)
+}
+
 // IsMode returns true if the mode is m
 func (s *State) IsMode(m int) bool {
 	return s.mode == m
@@ -85,14 +367,29 @@ func (s *State) IsMode(m int) bool {
 func (s *State) SetMode(m int) {
 	s.errorMessage = ""
 	s.mode = m
+	s.prompt = ':'
+	s.pendingKeys = ""
 	s.pendingCommand = NewEmptyLine(64)
 	s.cursorX = 0
+	s.historyIndex = -1
+	s.insertRegister = false
+	s.completion = nil
 	EmitEvent(ModeChangedEvent{Mode: m})
 }
 
-// ToastMessage displays a message for a short amount of time
+// ToastMessage displays an error message for a short amount of time
 func (s *State) ToastMessage(msg string) {
+	s.toast(msg, false)
+}
+
+// InfoMessage displays an informative message for a short amount of time
+func (s *State) InfoMessage(msg string) {
+	s.toast(msg, true)
+}
+
+func (s *State) toast(msg string, isInfo bool) {
 	s.errorMessage = msg
+	s.isInfoMessage = isInfo
 	go func() {
 		time.Sleep(1500 * time.Millisecond)
 		s.errorMessage = ""
@@ -119,14 +416,76 @@ func (s *State) GetCommand() string {
 	return string(s.pendingCommand.Bytes())
 }
 
-// Delete deletes the character before the cursor
+// Delete deletes the character (grapheme cluster) before the cursor
 func (s *State) Delete() {
-	s.pendingCommand.DeleteBeforeCursor()
-	if s.cursorX > 0 {
+	clusters := s.commandClusters()
+	i := clusterIndex(clusters, s.cursorX)
+	if i == 0 {
+		return
+	}
+	for s.cursorX > clusters[i-1].col {
+		s.pendingCommand.DeleteBeforeCursor()
 		s.cursorX--
 	}
 }
 
+// commandClusters returns the clusters of the pending command
+func (s *State) commandClusters() []cluster {
+	runes := make([]rune, 0, s.pendingCommand.Len())
+	for _, r := range s.pendingCommand.Runes() {
+		runes = append(runes, r)
+	}
+	return lineClusters(runes)
+}
+
+// isSearchPrompt returns true when the command line after prompt is a search pattern
+func isSearchPrompt(prompt rune) bool {
+	return prompt == '/' || prompt == '?'
+}
+
+// previewSearch emits the pattern typed after / or ? so that its first match is shown
+func (s *State) previewSearch() {
+	if isSearchPrompt(s.prompt) {
+		EmitEvent(SearchPreviewEvent{Pattern: s.GetCommand(), Backward: s.prompt == '?'})
+	}
+}
+
+// Confirm shows a question in the status line, the next typed key is emitted as its
+// answer
+func (s *State) Confirm(prompt string) {
+	s.confirmPrompt = prompt
+}
+
+// IsConfirming returns true while a confirm prompt waits for its answer
+func (s *State) IsConfirming() bool {
+	return s.confirmPrompt != ""
+}
+
+// SetRecording sets the register of the macro being recorded, 0 when the recording stops
+func (s *State) SetRecording(r rune) {
+	s.recording = r
+}
+
+// BeginReplay marks the start of keys typed by a macro or :normal instead of the user
+func (s *State) BeginReplay() {
+	s.replaying++
+}
+
+// EndReplay marks the end of keys typed since BeginReplay
+func (s *State) EndReplay() {
+	s.replaying--
+}
+
+// ShowLines shows a message of several lines above the status line until the next key
+func (s *State) ShowLines(lines []string) {
+	s.messageLines = lines
+}
+
+// SetTabInfo sets the information about the active tab shown in the status line
+func (s *State) SetTabInfo(info string) {
+	s.tabInfo = info
+}
+
 // IsFinished returns true if the state is finished
 func (s *State) IsFinished() bool {
 	return s.finished
@@ -144,49 +503,84 @@ func (s *State) GetName() string {
 
 // GetPreferredSize returns the preferred size of the state
 func (s *State) GetPreferredSize() layout.Size {
+	height := len(s.messageLines) + 1
+	if s.completion != nil {
+		height++
+	}
 	return layout.Size{
-		Height: 1,
+		Height: height,
 	}
 }
 
 // Render renders the state
 func (s *State) Render(screen tcell.Screen, point layout.Point) layout.Size {
 	renderSize := s.GetRenderSize()
+	lineSize := layout.Size{Width: renderSize.Width}
+	for _, line := range s.messageLines {
+		layout.DrawText(screen, point, point.AddSize(lineSize), line)
+		point.Y++
+	}
+	if s.completion != nil {
+		s.renderWildmenu(screen, point, renderSize.Width)
+		point.Y++
+	}
 	var color tcell.Color
-	if s.errorMessage != "" {
+	if s.errorMessage != "" && !s.isInfoMessage {
 		color = tcell.ColorRed
 	}
-	layout.DrawText(screen, point, point.AddSize(renderSize), s.getInfoLine(), color)
+	layout.DrawText(screen, point, point.AddSize(lineSize), s.getInfoLine(), color)
+	if s.errorMessage == "" && !s.IsMode(ModeCommand) && s.tabInfo != "" {
+		infoPoint := layout.Point{X: point.X + renderSize.Width - layout.StringWidth(s.tabInfo) - 1, Y: point.Y}
+		layout.DrawText(screen, infoPoint, point.AddSize(lineSize), s.tabInfo)
+	}
 	if s.IsMode(ModeCommand) {
-		screen.ShowCursor(point.X+s.cursorX+1, point.Y)
+		screen.ShowCursor(point.X+screenColumn(s.commandClusters(), s.cursorX)+1, point.Y)
 	}
 	return renderSize
 }
 
-// MoveCursor moves the cursor
-func (s *State) MoveCursor(dx int, _ int) {
-	if dx == 0 {
+// MoveCursor moves the cursor by dx characters (grapheme clusters), up and down browse
+// the history
+func (s *State) MoveCursor(dx int, dy int) {
+	if dy != 0 {
+		s.completion = nil
+		s.browseHistory(dy)
 		return
 	}
-	s.cursorX += dx
-	if s.cursorX < 0 {
-		s.cursorX = 0
-	} else if s.cursorX >= s.pendingCommand.Len() {
-		s.cursorX = s.pendingCommand.Len()
+	if dx == 0 {
+		return
 	}
+	s.completion = nil
+	s.historyIndex = -1
+	clusters := s.commandClusters()
+	i := clusterIndex(clusters, s.cursorX) + dx
+	s.cursorX = clusterCol(clusters, min(max(i, 0), len(clusters)))
 	s.pendingCommand.moveCursorTo(s.cursorX)
 }
 
 func (s *State) getInfoLine() string {
+	if s.confirmPrompt != "" {
+		return s.confirmPrompt
+	}
 	if s.errorMessage != "" {
 		return s.errorMessage
 	}
 	if s.IsMode(ModeCommand) {
-		return fmt.Sprintf(":%s", s.GetCommand())
+		return fmt.Sprintf("%c%s", s.prompt, s.GetCommand())
 	}
 	mode := "VIEW"
-	if s.IsMode(ModeInsert) {
+	switch s.mode {
+	case ModeInsert:
 		mode = "INSERT"
+	case ModeVisual:
+		mode = "VISUAL"
+	case ModeVisualLine:
+		mode = "VISUAL LINE"
+	case ModeVisualBlock:
+		mode = "VISUAL BLOCK"
+	}
+	if s.recording != 0 {
+		return fmt.Sprintf("-- %s --recording @%c", mode, s.recording)
 	}
 	return fmt.Sprintf("-- %s --", mode)
 }
@@ -0,0 +1,369 @@
+package editor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// substitution is a :substitute being applied to a tab. With the c flag, it waits for an
+// answer before every replacement.
+type substitution struct {
+	tab      *Tab
+	pattern  string
+	re       *searchRegexp
+	template []byte
+	// global replaces every match of a line rather than the first one, confirm asks
+	// before every replacement and countOnly only counts the matches
+	global, confirm, countOnly bool
+	// line is the line being substituted and last the last line of the range, they move
+	// down when the replacements insert line breaks
+	line, last int
+	// orig is the text of line before the substitution, matches its matches and next
+	// the index of the next match to replace
+	orig    []byte
+	matches [][]int
+	next    int
+	// start is the offset of line, built its new text up to the offset copied of orig
+	// and written the size of its text in the document
+	start   int
+	built   []byte
+	copied  int
+	written int
+	// count is the number of replacements, lines the number of lines with one and
+	// lastLine the last of them
+	count, lines, lastLine int
+	lineCounted            bool
+	// unwritten is true when replacements were made since the line was last written
+	unwritten bool
+}
+
+// parseSubstitute parses the arguments of :s, a delimiter followed by the pattern, the
+// replacement and the flags, like /pat/rep/g. The delimiter is any character but a
+// letter, a digit, a blank, a backslash or a double quote, \ escapes it.
+func parseSubstitute(args string) (pattern, replacement, flags string, err error) {
+	delim, size := utf8.DecodeRuneInString(args)
+	if size == 0 || !isDelimiter(delim) {
+		return "", "", "", errors.New("missing pattern delimiter")
+	}
+	parts := splitEscaped(args[size:], delim, 3)
+	pattern = parts[0]
+	if len(parts) > 1 {
+		replacement = parts[1]
+	}
+	if len(parts) > 2 {
+		flags = strings.TrimSpace(parts[2])
+	}
+	if i := strings.IndexFunc(flags, func(r rune) bool { return !strings.ContainsRune("gcin", r) }); i >= 0 {
+		return "", "", "", fmt.Errorf("invalid flag: %c", []rune(flags[i:])[0])
+	}
+	return pattern, replacement, flags, nil
+}
+
+// isDelimiter returns true when r can delimit the pattern of :s
+func isDelimiter(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsSpace(r) && !strings.ContainsRune(`\"`, r)
+}
+
+// splitEscaped splits s at the unescaped delim into at most n parts. The backslash
+// before an escaped delim is removed, other escapes are kept.
+func splitEscaped(s string, delim rune, n int) []string {
+	var parts []string
+	var b strings.Builder
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			if r != delim {
+				b.WriteRune('\\')
+			}
+			b.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == delim && len(parts) < n-1:
+			parts = append(parts, b.String())
+			b.Reset()
+		default:
+			b.WriteRune(r)
+		}
+	}
+	if escaped {
+		b.WriteRune('\\')
+	}
+	return append(parts, b.String())
+}
+
+// substituteTemplate converts a replacement to a template for regexp.Expand. & and \0
+// are the whole match, \1 to \9 and $1 or ${name} the groups, \n and \t a line break
+// and a tab. \&, \\ and $$ are a literal &, \ and $.
+func substituteTemplate(replacement string) []byte {
+	var b bytes.Buffer
+	runes := []rune(replacement)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '&':
+			b.WriteString("${0}")
+		case r == '\\' && i+1 < len(runes):
+			i++
+			switch next := runes[i]; {
+			case next >= '0' && next <= '9':
+				fmt.Fprintf(&b, "${%c}", next)
+			case next == 'n':
+				b.WriteByte('\n')
+			case next == 't':
+				b.WriteByte('\t')
+			case next == '$':
+				b.WriteString("$$")
+			default:
+				b.WriteRune(next)
+			}
+		case r == '$' && i+1 < len(runes) && runes[i+1] == '$':
+			b.WriteString("$$")
+			i++
+		case r == '$' && i+1 < len(runes) && runes[i+1] == '{':
+			// ${name} is already in the syntax of regexp.Expand
+			b.WriteByte('$')
+		case r == '$' && i+1 < len(runes) && runes[i+1] >= '0' && runes[i+1] <= '9':
+			j := i + 1
+			for j < len(runes) && runes[j] >= '0' && runes[j] <= '9' {
+				j++
+			}
+			fmt.Fprintf(&b, "${%s}", string(runes[i+1:j]))
+			i = j - 1
+		case r == '$':
+			b.WriteString("$$")
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.Bytes()
+}
+
+// newSubstitution prepares :s on the lines from first to last of tab. An empty pattern
+// is the last search pattern, the i flag ignores the case.
+func newSubstitution(tab *Tab, first, last int, pattern, replacement, flags string) (*substitution, error) {
+	if pattern == "" {
+		pattern = lastSearch.pattern
+	}
+	if pattern == "" {
+		return nil, errors.New("no previous search pattern")
+	}
+	var re *searchRegexp
+	var err error
+	if strings.ContainsRune(flags, 'i') {
+		re, err = compilePattern(pattern, true)
+	} else {
+		re, err = compileSearch(pattern)
+	}
+	if err != nil {
+		return nil, err
+	}
+	lastSearch.pattern, lastSearch.hidden = pattern, false
+	return &substitution{
+		tab:       tab,
+		pattern:   pattern,
+		re:        re,
+		template:  substituteTemplate(replacement),
+		global:    strings.ContainsRune(flags, 'g'),
+		confirm:   strings.ContainsRune(flags, 'c'),
+		countOnly: strings.ContainsRune(flags, 'n'),
+		line:      first - 1,
+		last:      last,
+	}, nil
+}
+
+// loadLine finds the matches of line
+func (s *substitution) loadLine() {
+	doc := s.tab.doc
+	s.orig = bytes.Clone(doc.Line(s.line))
+	n := 1
+	if s.global {
+		n = -1
+	}
+	s.matches = s.re.matches(s.orig, n)
+	s.next, s.start, s.built, s.copied, s.written = 0, doc.LineStart(s.line), nil, 0, len(s.orig)
+	s.lineCounted, s.unwritten = false, false
+}
+
+// run replaces the matches until an answer is needed, it returns false once the
+// substitution is done
+func (s *substitution) run() bool {
+	for {
+		if s.next < len(s.matches) {
+			if s.confirm {
+				m := s.matches[s.next]
+				s.tab.SetCursor(s.tab.positionOf(s.start + len(s.built) + m[0] - s.copied))
+				return true
+			}
+			s.apply(true)
+			continue
+		}
+		if s.matches != nil {
+			s.finishLine()
+		}
+		s.line++
+		if s.line > s.last || s.line >= s.tab.doc.LineCount() {
+			return false
+		}
+		s.loadLine()
+	}
+}
+
+// apply replaces the next match of the line, or skips it when replace is false
+func (s *substitution) apply(replace bool) {
+	m := s.matches[s.next]
+	s.next++
+	s.built = append(s.built, s.orig[s.copied:m[0]]...)
+	s.copied = m[1]
+	if !replace || s.countOnly {
+		s.built = append(s.built, s.orig[m[0]:m[1]]...)
+	} else {
+		s.built = s.re.re.Expand(s.built, s.template, s.orig, m)
+	}
+	if !replace {
+		return
+	}
+	s.count++
+	s.lastLine = s.line
+	if !s.lineCounted {
+		s.lines++
+		s.lineCounted = true
+	}
+	if s.countOnly {
+		return
+	}
+	s.unwritten = true
+	// with confirm, the line is written after every replacement so that the answers
+	// to the next ones are given on the new text, otherwise once in finishLine
+	if s.confirm {
+		s.write()
+	}
+}
+
+// write replaces the text of the line in the document with its new text
+func (s *substitution) write() {
+	text := append(bytes.Clone(s.built), s.orig[s.copied:]...)
+	s.tab.deleteText(s.start, s.written)
+	s.tab.insertText(s.start, text)
+	s.written = len(text)
+	s.unwritten = false
+}
+
+// finishLine writes the line and moves line and last down by the line breaks the
+// replacements inserted
+func (s *substitution) finishLine() {
+	if s.unwritten {
+		s.write()
+	}
+	added := bytes.Count(s.built, []byte{'\n'})
+	if s.lineCounted {
+		s.lastLine += added
+	}
+	s.line += added
+	s.last += added
+	s.matches = nil
+}
+
+// answer applies the answer to the confirm prompt: y replaces the match, l replaces it
+// and stops, n skips it, a replaces it and all the next ones, and q stops. It returns
+// false once the substitution is done.
+func (s *substitution) answer(r rune) bool {
+	switch r {
+	case 'y', 'n':
+		s.apply(r == 'y')
+	case 'a':
+		s.confirm = false
+		s.apply(true)
+	case 'l':
+		s.apply(true)
+		return false
+	case 'q':
+		return false
+	default:
+		return true
+	}
+	return s.run()
+}
+
+// result returns the message reported once the substitution is done
+func (s *substitution) result() string {
+	plural := func(n int, word, suffix string) string {
+		if n == 1 {
+			return fmt.Sprintf("%d %s", n, word)
+		}
+		return fmt.Sprintf("%d %s%s", n, word, suffix)
+	}
+	what := plural(s.count, "substitution", "s")
+	if s.countOnly {
+		what = plural(s.count, "match", "es")
+	}
+	return fmt.Sprintf("%s on %s", what, plural(s.lines, "line", "s"))
+}
+
+// prompt returns the question asked before a replacement
+func (s *substitution) prompt() string {
+	m := s.matches[s.next]
+	var b []byte
+	b = s.re.re.Expand(b, s.template, s.orig, m)
+	return fmt.Sprintf("replace with %s (y/n/a/q/l)?", strings.ReplaceAll(string(b), "\n", "^J"))
+}
+
+// substitute executes :[range]s/pat/rep/[flags] on the lines from first to last. The
+// whole substitution is undone as a single step, also when it waits for answers.
+func (s *Editor) substitute(first, last int, args string) error {
+	pattern, replacement, flags, err := parseSubstitute(args)
+	if err != nil {
+		return err
+	}
+	if s.inGlobal && strings.ContainsRune(flags, 'c') {
+		return errors.New("cannot confirm the substitutions of :global")
+	}
+	tab := s.getActiveTab()
+	// the hex view only overwrites bytes, a replacement would change the size of the file
+	if tab.hexMode {
+		return errHexView
+	}
+	sub, err := newSubstitution(tab, first, last, pattern, replacement, flags)
+	if err != nil {
+		return err
+	}
+	s.substitution = sub
+	tab.BeginChange()
+	s.continueSubstitution(sub.run())
+	return nil
+}
+
+// answerSubstitution applies the answer typed at the confirm prompt
+func (s *Editor) answerSubstitution(r rune) {
+	if s.substitution != nil {
+		s.continueSubstitution(s.substitution.answer(r))
+	}
+}
+
+// continueSubstitution asks for the next answer while the substitution is waiting,
+// otherwise it ends it and reports the result
+func (s *Editor) continueSubstitution(waiting bool) {
+	sub := s.substitution
+	if waiting {
+		GlobalState.Confirm(sub.prompt())
+		return
+	}
+	s.substitution = nil
+	if sub.matches != nil {
+		sub.finishLine()
+	}
+	sub.tab.EndChange()
+	if sub.count == 0 {
+		GlobalState.ToastMessage("pattern not found: " + sub.pattern)
+		return
+	}
+	if !sub.countOnly {
+		sub.tab.SetCursor(Position{Line: sub.lastLine, Col: sub.tab.indentCol(sub.lastLine)})
+	}
+	GlobalState.InfoMessage(sub.result())
+}
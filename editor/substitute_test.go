@@ -0,0 +1,109 @@
+package editor
+
+import (
+	"github.com/gdamore/tcell/v2"
+	"github.com/test-go/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSubstitute(t *testing.T) {
+	tests := []struct {
+		name, text, cmd, want, message string
+	}{
+		{"global", "foo\nbar\nboo", ":%s/o/0/g\r", "f00\nbar\nb00", "4 substitutions on 2 lines"},
+		{"first match", "aaa\naaa", ":%s/a/b/\r", "baa\nbaa", "2 substitutions on 2 lines"},
+		{"current line", "aaa\naaa", ":s/a/b\r", "baa\naaa", "1 substitution on 1 line"},
+		{"range", "a\na\na\na", ":2,3s/a/b/\r", "a\nb\nb\na", "2 substitutions on 2 lines"},
+		{"groups", "hello world", `:s/(\w+) (\w+)/\2 $1 &/` + "\r", "world hello hello world", "1 substitution on 1 line"},
+		{"escapes", "a/b", `:s#/#\&\\$$#` + "\r", `a&\$b`, "1 substitution on 1 line"},
+		{"escaped delimiter", "a/b", `:s/\//-/` + "\r", "a-b", "1 substitution on 1 line"},
+		{"line breaks", "a,b\nc,d", `:%s/,/\n/g` + "\r", "a\nb\nc\nd", "2 substitutions on 2 lines"},
+		{"ignore case", "Abc", ":s/a/x/i\r", "xbc", "1 substitution on 1 line"},
+		{"whole words", "Là xlà là", `:s/\<là\>/x/gi` + "\r", "x xlà x", "2 substitutions on 1 line"},
+		{"count only", "aaa\nb\na", ":%s/a//gn\r", "aaa\nb\na", "4 matches on 2 lines"},
+		{"not found", "abc", ":s/x/y/\r", "abc", "pattern not found: x"},
+		{"invalid flag", "abc", ":s/a/b/z\r", "abc", "err: invalid flag: z"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resetSearch(t)
+			e := newTestEditor(t, test.text)
+			tab := e.getActiveTab()
+			typeEditorKeys(e, test.cmd)
+			require.Equal(t, test.want, tabText(tab))
+			require.Equal(t, test.message, GlobalState.getInfoLine())
+			if test.want != test.text && !t.Failed() {
+				require.NoError(t, tab.Undo())
+				require.Equal(t, test.text, tabText(tab))
+			}
+		})
+	}
+}
+
+func TestSubstituteLongLine(t *testing.T) {
+	resetSearch(t)
+	line := strings.Repeat("x ", 200000)
+	e := newTestEditor(t, "a\n"+line)
+	tab := e.getActiveTab()
+	start := time.Now()
+	typeEditorKeys(e, ":%s/x/yz/g\r")
+	require.True(t, time.Since(start) < 2*time.Second)
+	require.Equal(t, "a\n"+strings.Repeat("yz ", 200000), tabText(tab))
+	require.Equal(t, "200000 substitutions on 1 line", GlobalState.getInfoLine())
+	require.NoError(t, tab.Undo())
+	require.Equal(t, "a\n"+line, tabText(tab))
+}
+
+func TestSubstituteConfirm(t *testing.T) {
+	resetSearch(t)
+	e := newTestEditor(t, "a a\na a")
+	tab := e.getActiveTab()
+	typeEditorKeys(e, ":%s/a/bc/gc\r")
+	require.Equal(t, "replace with bc (y/n/a/q/l)?", GlobalState.getInfoLine())
+	require.Equal(t, Position{Line: 0, Col: 0}, tab.GetCursor())
+	typeEditorKeys(e, "yx")
+	require.Equal(t, Position{Line: 0, Col: 3}, tab.GetCursor())
+	typeEditorKeys(e, "n")
+	require.Equal(t, Position{Line: 1, Col: 0}, tab.GetCursor())
+	typeEditorKeys(e, "a")
+	require.Equal(t, "bc a\nbc bc", tabText(tab))
+	require.Equal(t, "3 substitutions on 2 lines", GlobalState.getInfoLine())
+	require.True(t, GlobalState.IsMode(ModeView))
+	require.NoError(t, tab.Undo())
+	require.Equal(t, "a a\na a", tabText(tab))
+
+	typeEditorKeys(e, ":%s/a/b/gc\ry\x1b")
+	require.Equal(t, "b a\na a", tabText(tab))
+	require.Equal(t, "1 substitution on 1 line", GlobalState.getInfoLine())
+	require.True(t, GlobalState.IsMode(ModeView))
+	typeEditorKeys(e, "x")
+	require.Equal(t, " a\na a", tabText(tab))
+
+	// the tab cannot be closed or left while the prompt is shown
+	e.window.AddTab(NewTab("other", nil))
+	e.window.PreviousTab()
+	typeEditorKeys(e, ":%s/a/b/gc\r")
+	for _, key := range []tcell.Key{tcell.KeyCtrlW, tcell.KeyCtrlQ, tcell.KeyCtrlE, tcell.KeyCtrlT} {
+		e.handleTypedKey(tcell.NewEventKey(key, 0, tcell.ModCtrl))
+		require.True(t, tab == e.getActiveTab())
+		require.Len(t, e.window.tabs, 2)
+		require.True(t, GlobalState.IsConfirming())
+	}
+	typeEditorKeys(e, "a")
+	require.Equal(t, " b\nb b", tabText(tab))
+	require.NoError(t, tab.Undo())
+	require.Equal(t, " a\na a", tabText(tab))
+}
+
+func TestSubstituteHexView(t *testing.T) {
+	resetSearch(t)
+	e := newTestEditor(t, "abc\ndef")
+	tab := e.getActiveTab()
+	typeEditorKeys(e, ":hex\r:%s/b/XYZ/g\r")
+	require.True(t, tab.IsHex())
+	require.Equal(t, "err: not available in the hex view", GlobalState.getInfoLine())
+	require.Equal(t, []byte("abc\ndef\n"), tab.doc.Slice(0, tab.doc.Len()))
+	require.False(t, tab.Modified())
+}
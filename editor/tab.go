@@ -2,48 +2,110 @@
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"github.com/dangdungcntt/ndditor/editor/layout"
 	"github.com/gdamore/tcell/v2"
+	"golang.org/x/text/transform"
+	"io"
 	"log"
 	"os"
 	"path"
+	"slices"
+	"time"
 )
 
 var _ layout.Element = (*Tab)(nil)
 
-// Tab represents the content of a Tab. It is a doubly linked list of lines.
+var (
+	errOldestChange = errors.New("already at oldest change")
+	errNewestChange = errors.New("already at newest change")
+)
+
+// invalidByteStyle is the style of bytes that are not valid UTF-8
+var invalidByteStyle = tcell.StyleDefault.Foreground(tcell.ColorRed)
+
+// Tab represents the content of a Tab. The text is stored in a Document.
 type Tab struct {
 	layout.BaseElement
-	name      string
-	path      string
+	name string
+	path string
+	// cursorPos.X is the logical column of the cursor (a rune index into the line),
+	// cursorPos.Y is the screen row of the cursor inside the tab, updated by scroll
 	cursorPos layout.Point
 	lineIndex int
-	lines     []*Line
+	// topLine is the first line shown in the tab, topRow the first of its screen rows
+	// that is shown when the line is wrapped
+	topLine int
+	topRow  int
+	// leftCol is the first screen column shown when lines are not wrapped
+	leftCol int
+	// want is the column the cursor tries to keep when moving between lines or rows
+	want wantColumn
+	doc  Document
+	undo *UndoTree
+	// savedSeq is the undo state of the text last read from or written to the file
+	savedSeq   int
+	fileFormat FileFormat
+	// encoding is the encoding of the file, the document itself is always UTF-8
+	encoding string
+	// lossy is true when the bytes of the file could not all be decoded, writing the text
+	// would change them
+	lossy bool
+	// hexMode is true when the tab shows the hex view, hexCursor is then the offset
+	// of the byte under the cursor and hexNibble the nibble of that byte
+	hexMode   bool
+	hexCursor int
+	hexNibble int
+	hexTopRow int
+	// hexText is the text of a text tab while the hex view edits the bytes of its file
+	// in doc, nil otherwise
+	hexText *hexText
+	// visual is the visual mode of the selection, ModeView when nothing is selected, and
+	// visualAnchor the end of the selection that does not move with the cursor
+	visual       int
+	visualAnchor Position
+	// cursors are the additional cursors, edits and motions apply at every cursor
+	cursors []cursor
+	// match is the text matched to add cursors, nil when none was matched
+	match *matchCursors
+	// marks are the positions set with m{a-z}, and the ends of the last selection
+	marks map[rune]Position
+	// globalLines are the lines marked by :g, a line is -1 once it is deleted
+	globalLines []int
+	// wraps are the indexes of the rows of the long lines, for wrapLayout and wrapTabStop
+	wraps       map[int]*wrapIndex
+	wrapLayout  lineLayout
+	wrapTabStop int
+	// cols are the indexes of the clusters of the long lines, for colTabStop
+	cols       map[int]*colIndex
+	colTabStop int
 }
 
-// NewTab creates a new Tab
-func NewTab(name string, lines ...*Line) *Tab {
-	if len(lines) > 0 {
-		lines[0].moveCursorTo(0)
-	} else {
-		lines = append(lines, NewEmptyLine(64))
+// NewTab creates a new Tab, an empty document is used if doc is nil
+func NewTab(name string, doc Document) *Tab {
+	if doc == nil {
+		doc = NewPieceTable(nil)
 	}
 	return &Tab{
-		name:  name,
-		lines: lines,
+		name:       name,
+		doc:        doc,
+		undo:       NewUndoTree(doc),
+		fileFormat: DefaultFileFormat(),
+		encoding:   EncodingUTF8,
 	}
 }
 
-// NewTabFromPath creates a new Tab from a file
-func NewTabFromPath(filePath string) (*Tab, error) {
+// NewTabFromPath creates a new Tab from a file. The encoding of the file is detected
+// unless it is given.
+func NewTabFromPath(filePath string, encoding ...string) (*Tab, error) {
 	stat, err := os.Stat(filePath)
 	if err != nil {
 		if !errors.Is(err, os.ErrNotExist) {
 			log.Fatalf("error reading file: %s", err)
 		}
-		tab := NewTab(path.Base(filePath), NewEmptyLine(64))
+		tab := NewTab(path.Base(filePath), nil)
 		tab.SetPath(filePath)
 		return tab, nil
 	}
@@ -52,24 +114,41 @@ func NewTabFromPath(filePath string) (*Tab, error) {
 		return nil, fmt.Errorf("%s is not a file", filePath)
 	}
 
-	file, err := os.Open(filePath)
+	content, err := os.ReadFile(filePath)
 	if err != nil {
-		println(err)
+		return nil, err
 	}
-	defer func() {
-		_ = file.Close()
-	}()
-	scanner := bufio.NewScanner(file)
-	var lines []*Line
-	for scanner.Scan() {
-		line := scanner.Text()
-		lines = append(lines, NewLine([]rune(line)))
+
+	var name string
+	var bom bool
+	if len(encoding) > 0 {
+		name, _, err = lookupEncoding(encoding[0])
+		if err != nil {
+			return nil, err
+		}
+		if mark := byteOrderMarks[name]; mark != nil && bytes.HasPrefix(content, mark) {
+			bom = true
+			content = content[len(mark):]
+		}
+	} else {
+		name, bom, content = detectEncoding(content, globalOptions.fallbackEncoding)
+	}
+	if name == EncodingBinary {
+		tab := NewTab("", NewPieceTable(content))
+		tab.encoding = name
+		tab.hexMode = true
+		tab.SetPath(filePath)
+		return tab, nil
 	}
-	if err := scanner.Err(); err != nil {
+	format, content, lossy, err := decodeText(content, name)
+	if err != nil {
 		return nil, err
 	}
-
-	tab := NewTab("", lines...)
+	format.BOM = bom
+	tab := NewTab("", NewPieceTable(content))
+	tab.fileFormat = format
+	tab.encoding = name
+	tab.lossy = lossy
 	tab.SetPath(filePath)
 	return tab, nil
 }
@@ -85,95 +164,263 @@ func (s *Tab) GetPath() string {
 	return s.path
 }
 
+// StatusInfo returns the information about the tab shown in the status line
+func (s *Tab) StatusInfo() string {
+	info := fmt.Sprintf("%s %s", s.encoding, s.fileFormat)
+	if s.encoding == EncodingBinary {
+		info = EncodingBinary
+	}
+	if s.hexMode {
+		info = "hex " + info
+	}
+	if s.lossy {
+		info += " [lossy]"
+	}
+	return info
+}
+
+// wantColumn is the column the cursor tries to keep when it moves to another line or row
+type wantColumn struct {
+	// rowX is the screen column of the cursor in its row, counted from the start of the
+	// row when the line is wrapped, not from the start of the line. gj and gk keep it.
+	rowX int
+	// lineX is the screen column of the cursor in its whole line, j and k keep it
+	lineX int
+	// end is set by $, the cursor then stays at the end of the lines and rows it moves to
+	end bool
+}
+
+// updateWant remembers the column of the cursor for vertical movement
+func (s *Tab) updateWant() {
+	s.want = s.wantAt(s.GetCursor())
+}
+
+// wantAt returns the column a cursor at pos keeps when it moves to another line or row
+func (s *Tab) wantAt(pos Position) wantColumn {
+	_, x := s.placeOf(s.lineLayout(), pos.Line, pos.Col)
+	return wantColumn{rowX: x, lineX: s.screenCol(pos.Line, pos.Col)}
+}
+
+// cursorOffset returns the document offset of the cursor
+func (s *Tab) cursorOffset() int {
+	return s.offsetOf(s.lineIndex, s.cursorPos.X)
+}
+
+// positionOf converts a document offset into a position
+func (s *Tab) positionOf(offset int) Position {
+	line := s.doc.LineAt(offset)
+	rel := offset - s.doc.LineStart(line)
+	col := 0
+	for m, c := range s.lineClustersFrom(line, func(m colMark) bool { return m.offset <= rel }) {
+		col = m.col
+		for _, r := range c.runes {
+			if m.offset >= rel {
+				return Position{Line: line, Col: col}
+			}
+			m.offset += runeLen(r)
+			col++
+		}
+	}
+	return Position{Line: line, Col: col}
+}
+
+// GetCursor returns the position of the cursor
+func (s *Tab) GetCursor() Position {
+	return Position{Line: s.lineIndex, Col: s.cursorPos.X}
+}
+
+// SetCursor moves the cursor to pos, the view is scrolled only if pos is outside of it
+func (s *Tab) SetCursor(pos Position) {
+	pos.Line = min(max(pos.Line, 0), s.doc.LineCount()-1)
+	pos.Col = s.clampCol(pos.Line, pos.Col)
+	s.lineIndex = pos.Line
+	s.cursorPos.X = pos.Col
+	s.updateWant()
+}
+
+// insertText inserts text at offset and records it in the undo tree
+func (s *Tab) insertText(offset int, text []byte) {
+	s.adjustMarks(offset, text, true)
+	s.invalidateIndexes(offset, text, true)
+	s.doc.Insert(offset, text)
+	s.undo.Record(editOp{offset: offset, text: text, insert: true})
+}
+
+// deleteText deletes length bytes at offset and records it in the undo tree
+func (s *Tab) deleteText(offset, length int) {
+	if length <= 0 {
+		return
+	}
+	text := s.doc.Slice(offset, length)
+	s.adjustMarks(offset, text, false)
+	s.invalidateIndexes(offset, text, false)
+	s.doc.Delete(offset, length)
+	s.undo.Record(editOp{offset: offset, text: text})
+}
+
+// BeginChange starts a group of edits that is undone as a single step
+func (s *Tab) BeginChange() {
+	s.undo.Begin()
+}
+
+// EndChange ends a group of edits started by BeginChange
+func (s *Tab) EndChange() {
+	s.undo.End()
+}
+
+// Undo reverts the last change
+func (s *Tab) Undo() error {
+	offset, ok := s.undo.Undo()
+	return s.moveInHistory(offset, ok, errOldestChange)
+}
+
+// Redo applies the last undone change again
+func (s *Tab) Redo() error {
+	offset, ok := s.undo.Redo()
+	return s.moveInHistory(offset, ok, errNewestChange)
+}
+
+// Earlier moves count states back in the undo history, across branches
+func (s *Tab) Earlier(count int) error {
+	offset, ok := s.undo.Earlier(count)
+	return s.moveInHistory(offset, ok, errOldestChange)
+}
+
+// Later moves count states forward in the undo history, across branches
+func (s *Tab) Later(count int) error {
+	offset, ok := s.undo.Later(count)
+	return s.moveInHistory(offset, ok, errNewestChange)
+}
+
+// EarlierTime moves to the text state as it was d ago
+func (s *Tab) EarlierTime(d time.Duration) error {
+	offset, ok := s.undo.EarlierTime(d)
+	return s.moveInHistory(offset, ok, errOldestChange)
+}
+
+// LaterTime moves to the text state as it was d later
+func (s *Tab) LaterTime(d time.Duration) error {
+	offset, ok := s.undo.LaterTime(d)
+	return s.moveInHistory(offset, ok, errNewestChange)
+}
+
+func (s *Tab) moveInHistory(offset int, ok bool, err error) error {
+	if !ok {
+		return err
+	}
+	offset = min(offset, s.doc.Len())
+	// the undo tree edits the document directly, the rows of any line may have changed
+	s.wraps, s.cols = nil, nil
+	// the additional cursors do not follow the text through the history
+	s.ClearCursors()
+	if s.hexMode {
+		s.setHexCursor(offset)
+		return nil
+	}
+	s.SetCursor(s.positionOf(offset))
+	return nil
+}
+
 // InsertNewline inserts a newline at the current cursor position
 func (s *Tab) InsertNewline() {
-	renderSize := s.GetRenderSize()
-	line := s.lines[s.lineIndex]
-	s.lineIndex++
-	s.cursorPos.Y++
-	if s.cursorPos.Y >= renderSize.Height {
-		s.cursorPos.Y = renderSize.Height - 1
-	}
-	newLineContent := line.CutAfterCursor()
-	var newLine *Line
-	if len(newLineContent) == 0 {
-		newLine = NewEmptyLine(64)
-	} else {
-		newLine = NewLine(newLineContent, true)
-	}
-	s.lines = append(s.lines, nil)
-	if s.lineIndex == len(s.lines)-1 {
-		s.lines[s.lineIndex] = newLine
-	} else {
-		copy(s.lines[s.lineIndex+1:], s.lines[s.lineIndex:])
-		s.lines[s.lineIndex] = newLine
+	s.eachCursor(s.insertNewline)
+}
+
+func (s *Tab) insertNewline() {
+	if s.hexMode {
+		return
 	}
+	s.insertText(s.cursorOffset(), []byte{'\n'})
+	s.lineIndex++
 	s.cursorPos.X = 0
+	s.want = wantColumn{}
 }
 
-// InsertRune inserts a rune at the current cursor position
+// InsertRune inserts a rune at the current cursor position.
+// With expandtab, a tab is inserted as spaces up to the next tab stop.
 func (s *Tab) InsertRune(r rune) {
-	s.lines[s.lineIndex].Insert(r)
+	s.eachCursor(func() { s.insertRune(r) })
+}
+
+func (s *Tab) insertRune(r rune) {
+	if s.hexMode {
+		s.hexInsertRune(r)
+		return
+	}
+	if r == '\t' && globalOptions.expandTab {
+		spaces := tabWidth(s.screenCol(s.lineIndex, s.cursorPos.X))
+		s.insertText(s.cursorOffset(), bytes.Repeat([]byte{' '}, spaces))
+		s.cursorPos.X += spaces
+		s.updateWant()
+		return
+	}
+	s.insertText(s.cursorOffset(), appendRune(nil, r))
 	s.cursorPos.X++
+	s.updateWant()
 }
 
 // Backspace deletes the character before the cursor
 func (s *Tab) Backspace() {
+	s.eachCursor(s.backspace)
+}
+
+func (s *Tab) backspace() {
+	if s.hexMode {
+		s.hexBackspace()
+		return
+	}
 	if s.cursorPos.X > 0 {
-		s.lines[s.lineIndex].DeleteBeforeCursor()
-		s.cursorPos.X--
+		col := s.moveCol(s.lineIndex, s.cursorPos.X, -1)
+		start := s.offsetOf(s.lineIndex, col)
+		s.deleteText(start, s.cursorOffset()-start)
+		s.cursorPos.X = col
 	} else if s.lineIndex > 0 {
-		aboveLine := s.lines[s.lineIndex-1]
-		s.cursorPos.X = aboveLine.Len()
-		aboveLine.Append(s.lines[s.lineIndex])
-		aboveLine.moveCursorTo(s.cursorPos.X)
-		copy(s.lines[s.lineIndex:], s.lines[s.lineIndex+1:])
-		s.lines = s.lines[:len(s.lines)-1]
+		s.cursorPos.X = s.lineLen(s.lineIndex - 1)
+		s.deleteText(s.doc.LineStart(s.lineIndex)-1, 1)
 		s.lineIndex--
-		s.cursorPos.Y--
-		if s.cursorPos.Y < 0 {
-			s.cursorPos.Y = 0
-		}
 	}
+	s.updateWant()
 }
 
 // Delete deletes the character after the cursor
 func (s *Tab) Delete() {
-	if s.cursorPos.X < s.lines[s.lineIndex].Len() {
-		s.lines[s.lineIndex].DeleteAfterCursor()
-	} else if s.lineIndex < len(s.lines)-1 {
-		s.lines[s.lineIndex].Append(s.lines[s.lineIndex+1])
-		copy(s.lines[s.lineIndex+1:], s.lines[s.lineIndex+2:])
-		s.lines = s.lines[:len(s.lines)-1]
+	s.eachCursor(s.deleteChar)
+}
+
+func (s *Tab) deleteChar() {
+	if s.hexMode {
+		s.hexDelete()
+		return
+	}
+	start := s.cursorOffset()
+	if start < s.doc.LineEnd(s.lineIndex) {
+		end := s.offsetOf(s.lineIndex, s.moveCol(s.lineIndex, s.cursorPos.X, 1))
+		s.deleteText(start, end-start)
+	} else if s.lineIndex < s.doc.LineCount()-1 {
+		s.deleteText(s.doc.LineEnd(s.lineIndex), 1)
 	}
 }
 
-// MoveCursor moves the cursor in the active tab
+// MoveCursor moves the cursor in the active tab.
+// dx is counted in characters (grapheme clusters), dy in screen rows, so that wrapped
+// lines are walked row by row. Vertical moves keep the screen column.
 func (s *Tab) MoveCursor(dx, dy int) {
-	maxCursorY := min(s.GetRenderSize().Height-1, s.cursorPos.Y+(len(s.lines)-s.lineIndex-1))
-	s.lineIndex += dy
-	if s.lineIndex < 0 {
-		s.lineIndex = 0
-	} else if s.lineIndex >= len(s.lines) {
-		s.lineIndex = len(s.lines) - 1
-	}
-	s.cursorPos.Y += dy
+	s.eachCursor(func() { s.moveCursor(dx, dy) })
+}
 
-	if s.cursorPos.Y < 0 {
-		s.cursorPos.Y = 0
-	} else if s.cursorPos.Y > maxCursorY {
-		s.cursorPos.Y = maxCursorY
+func (s *Tab) moveCursor(dx, dy int) {
+	if s.hexMode {
+		s.hexMoveCursor(dx, dy)
+		return
 	}
-
-	s.cursorPos.X += dx
-	if s.cursorPos.X < 0 {
-		s.cursorPos.X = 0
+	if dy != 0 {
+		pos, _ := s.rowTarget(s.GetCursor(), dy)
+		s.lineIndex, s.cursorPos.X = pos.Line, pos.Col
 	}
-	maxLen := s.lines[s.lineIndex].Len()
-	if s.cursorPos.X > maxLen {
-		s.cursorPos.X = maxLen
+	if dx != 0 {
+		s.cursorPos.X = s.moveCol(s.lineIndex, s.cursorPos.X, dx)
+		s.updateWant()
 	}
-	s.lines[s.lineIndex].moveCursorTo(s.cursorPos.X)
 }
 
 // GetName returns the name of the window
@@ -188,28 +435,98 @@ func (s *Tab) GetPreferredSize() layout.Size {
 
 // Render renders the tab to the screen
 func (s *Tab) Render(screen tcell.Screen, mountPoint layout.Point) layout.Size {
+	if s.hexMode {
+		return s.renderHex(screen, mountPoint)
+	}
 	renderSize := s.GetRenderSize()
-	minLine := s.lineIndex - s.cursorPos.Y
-	maxLine := s.lineIndex + (renderSize.Height - s.cursorPos.Y)
+	cursorX := s.scroll()
+	l := s.lineLayout()
 
 	showCursor := true
-	screenLine := 0
-	for y, line := range s.lines {
-		if y < minLine || y >= maxLine {
-			continue
+	search := highlightPattern()
+	lineRow := -s.topRow
+	for y := s.topLine; y < s.doc.LineCount() && lineRow < renderSize.Height; y++ {
+		// only the visible part of the line is decoded, so very long lines stay cheap
+		rows := 1
+		precedes, extends := false, false
+		selStart, selEnd := s.selectedCols(y)
+		cursorCols := s.cursorCols(y)
+		// the matches are searched around the clusters on screen, from the first one
+		var matches [][2]int
+		searched := search == nil
+		// endX and endRow are the screen cell after the text of the line, lineEnd its
+		// column, complete is false when the end of the line is not shown
+		endX, endRow, lineEnd := -s.leftCol, lineRow, 0
+		complete := true
+		// the rows above the view, or the columns left of it, are skipped from the
+		// closest indexed start
+		from := func(m rowMark) bool { return lineRow+m.row <= 0 }
+		if l.width == 0 {
+			from = func(m rowMark) bool { return m.screenX <= s.leftCol }
 		}
-		for x, r := range line.Runes() {
-			if x == s.cursorPos.X && y == s.lineIndex {
-				showCursor = false
-				screen.SetContent(mountPoint.X+x, mountPoint.Y+screenLine, r, nil, tcell.StyleDefault.Reverse(true))
+		for p := range s.placeLine(l, y, from) {
+			screenRow := lineRow + p.row
+			x := p.x - s.leftCol
+			if screenRow >= renderSize.Height {
+				complete = false
+				break
+			}
+			if l.width == 0 && x+p.width > renderSize.Width {
+				extends, complete = true, false
+				break
+			}
+			endX, endRow, lineEnd = x+p.width, screenRow, p.end()
+			rows = p.row + 1
+			if screenRow < 0 {
+				continue
+			}
+			if x < 0 {
+				precedes = true
 				continue
 			}
-			screen.SetContent(mountPoint.X+x, mountPoint.Y+screenLine, r, nil, tcell.StyleDefault)
+			if !searched {
+				matches = s.windowMatches(search, y, p.col, p.col+renderSize.Width*(renderSize.Height-screenRow))
+				searched = true
+			}
+			if p.row > 0 && p.x == l.rowStart(p.row) {
+				drawCells(screen, mountPoint.X, mountPoint.Y+screenRow, l.showBreak, nonTextStyle)
+			}
+			style := tcell.StyleDefault
+			selected := p.col >= selStart && p.col < selEnd
+			if selected {
+				style = selectionStyle
+			} else if y == s.lineIndex && p.col == s.cursorPos.X {
+				showCursor = false
+				style = style.Reverse(true)
+			} else if slices.Contains(cursorCols, p.col) {
+				style = style.Reverse(true)
+			} else if slices.ContainsFunc(matches, func(m [2]int) bool { return p.col >= m[0] && p.col < m[1] }) {
+				style = searchStyle
+			}
+			p.draw(screen, mountPoint.X+x, mountPoint.Y+screenRow, mountPoint.X+renderSize.Width, style)
+		}
+		// a selected line break, or an additional cursor after the text, is shown as a
+		// reversed cell after the text
+		if (selEnd > lineEnd || slices.Contains(cursorCols, lineEnd)) && complete && endX >= 0 && endX < renderSize.Width && endRow >= 0 && endRow < renderSize.Height {
+			screen.SetContent(mountPoint.X+endX, mountPoint.Y+endRow, ' ', nil, selectionStyle)
+		}
+		// the indicators never hide the cursor
+		isCursor := func(x int) bool {
+			return y == s.lineIndex && x == cursorX-s.leftCol
+		}
+		if precedes && !isCursor(0) {
+			screen.SetContent(mountPoint.X, mountPoint.Y+lineRow, precedesChar, nil, nonTextStyle)
+		}
+		if extends && !isCursor(renderSize.Width-1) {
+			screen.SetContent(mountPoint.X+renderSize.Width-1, mountPoint.Y+lineRow, extendsChar, nil, nonTextStyle)
 		}
-		screenLine++
+		if y == s.lineIndex {
+			rows = max(rows, s.cursorPos.Y-lineRow+1)
+		}
+		lineRow += rows
 	}
 	if showCursor {
-		screen.ShowCursor(mountPoint.X+s.cursorPos.X, mountPoint.Y+s.cursorPos.Y)
+		screen.ShowCursor(mountPoint.X+cursorX-s.leftCol, mountPoint.Y+s.cursorPos.Y)
 	}
 
 	return renderSize
@@ -217,9 +534,18 @@ func (s *Tab) Render(screen tcell.Screen, mountPoint layout.Point) layout.Size {
 
 // Save saves the tab
 func (s *Tab) Save() error {
+	return s.Write(false)
+}
+
+// Write saves the tab. The text of a file whose bytes could not all be decoded is only
+// written when force is true.
+func (s *Tab) Write(force bool) (err error) {
 	if s.path == "" {
 		return errors.New("tab has no path")
 	}
+	if s.lossy && !force && s.hexText == nil {
+		return fmt.Errorf("the file is not valid %s, writing would change its bytes (add ! to override)", s.encoding)
+	}
 	tmpPath := s.path + ".tmp"
 	tmpFile, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
 	if err != nil {
@@ -227,20 +553,74 @@ func (s *Tab) Save() error {
 	}
 	defer func() {
 		_ = tmpFile.Close()
-	}()
-	for i, line := range s.lines {
-		bytes := line.Bytes()
-		if i < len(s.lines)-1 {
-			bytes = append(bytes, '\n')
-		}
-		_, err = tmpFile.Write(bytes)
+		// the file is left as it was when the text cannot be written
 		if err != nil {
-			return err
+			_ = os.Remove(tmpPath)
 		}
+	}()
+	err = s.writeTo(tmpFile)
+	if err != nil {
+		return err
 	}
 	err = tmpFile.Close()
 	if err != nil {
 		return err
 	}
-	return os.Rename(tmpPath, s.path)
+	if err = os.Rename(tmpPath, s.path); err != nil {
+		return err
+	}
+	s.savedSeq = s.undo.Seq()
+	if s.hexText != nil {
+		s.hexText.modified = false
+	} else {
+		s.lossy = false
+	}
+	return nil
+}
+
+// Modified returns true when the text was changed since it was read or written
+func (s *Tab) Modified() bool {
+	return s.undo.Seq() != s.savedSeq || s.undo.Pending() || (s.hexText != nil && s.hexText.modified)
+}
+
+// writeTo writes the content of the tab in its file format and encoding, or the bytes
+// of the hex view of a text tab as they are
+func (s *Tab) writeTo(w io.Writer) error {
+	if s.encoding == EncodingBinary || s.hexText != nil {
+		_, err := s.doc.WriteTo(w)
+		return err
+	}
+	_, enc, err := lookupEncoding(s.encoding)
+	if err != nil {
+		return err
+	}
+	if mark := byteOrderMarks[s.encoding]; s.fileFormat.BOM && mark != nil {
+		if _, err = w.Write(mark); err != nil {
+			return err
+		}
+	}
+	var encoder *transform.Writer
+	if enc != nil {
+		encoder = transform.NewWriter(w, enc.NewEncoder())
+		w = encoder
+	}
+
+	bw := bufio.NewWriter(w)
+	lineCount := s.doc.LineCount()
+	if s.doc.Len() > 0 || lineCount > 1 || s.fileFormat.EmptyLine {
+		lineEnding := s.fileFormat.lineEnding()
+		for i, line := range s.doc.Lines(0, lineCount) {
+			_, _ = bw.Write(line)
+			if i < lineCount-1 || s.fileFormat.EOL {
+				_, _ = bw.Write(lineEnding)
+			}
+		}
+	}
+	if err = bw.Flush(); err != nil {
+		return fmt.Errorf("cannot write as %s: %w", s.encoding, err)
+	}
+	if encoder != nil {
+		return encoder.Close()
+	}
+	return nil
 }
@@ -0,0 +1,284 @@
+package editor
+
+import "iter"
+
+// The helpers below measure a line by decoding it lazily from the document. They only
+// read the line up to the column they are looking for, from the closest indexed cluster
+// start on long lines, so working anywhere in a line of many megabytes does not decode
+// the whole line.
+
+// lineRunes iterates over the runes of line n
+func (s *Tab) lineRunes(n int) iter.Seq[rune] {
+	return func(yield func(rune) bool) {
+		for _, r := range docRunes(s.doc, s.doc.LineStart(n), s.doc.LineEnd(n)) {
+			if !yield(r) {
+				return
+			}
+		}
+	}
+}
+
+// lineClusters iterates over the clusters of line n
+func (s *Tab) lineClusters(n int) iter.Seq[cluster] {
+	return streamClusters(s.lineRunes(n))
+}
+
+// colIndexMinLen is the length in bytes from which the cluster starts of a line are
+// indexed, shorter lines are measured from their start every time
+const colIndexMinLen = 4096
+
+// colMarkClusters is the number of clusters between two indexed cluster starts
+const colMarkClusters = 256
+
+// colMark is the start of a cluster: its column, its offset from the start of the line
+// and its screen column in the unwrapped line, which the width of the next tabs depends on
+type colMark struct {
+	col, offset, screenX int
+}
+
+// colIndex keeps the start of every colMarkClusters-th cluster of a long line, so that
+// the line is measured from the closest start instead of from the start of the line.
+// The marks are added as the line is walked, runes is the number of runes of the line, -1
+// until they are counted.
+type colIndex struct {
+	marks []colMark
+	runes int
+}
+
+// truncate drops what depends on the text from offset, relative to the start of the
+// line. The starts before offset are kept, whether a cluster starts before a character
+// only depends on the characters up to it.
+func (c *colIndex) truncate(offset int) {
+	i := 0
+	for i < len(c.marks) && c.marks[i].offset < offset {
+		i++
+	}
+	c.marks = c.marks[:max(i, 1)]
+	c.runes = -1
+}
+
+// colIndex returns the index of the clusters of line n, nil when the line is short
+// enough to be walked from its start every time
+func (s *Tab) colIndex(n int) *colIndex {
+	if s.doc.LineEnd(n)-s.doc.LineStart(n) < colIndexMinLen {
+		return nil
+	}
+	// the screen columns hold for a tabstop
+	if globalOptions.tabStop != s.colTabStop {
+		s.cols = nil
+		s.colTabStop = globalOptions.tabStop
+	}
+	if s.cols == nil {
+		s.cols = map[int]*colIndex{}
+	}
+	c := s.cols[n]
+	if c == nil {
+		c = &colIndex{marks: []colMark{{}}, runes: -1}
+		s.cols[n] = c
+	}
+	return c
+}
+
+// lineClustersFrom iterates over the clusters of line n and their starts, from the last
+// indexed start for which from is true. A short line, or a line where from is never
+// true, is walked from its start.
+func (s *Tab) lineClustersFrom(n int, from func(colMark) bool) iter.Seq2[colMark, cluster] {
+	return func(yield func(colMark, cluster) bool) {
+		idx := s.colIndex(n)
+		m := colMark{}
+		if idx != nil {
+			i := 0
+			for i+1 < len(idx.marks) && from(idx.marks[i+1]) {
+				i++
+			}
+			m = idx.marks[i]
+			// the row starts of the wrap index are cluster starts too
+			if w := s.wraps[n]; w != nil && s.wrapTabStop == globalOptions.tabStop {
+				for _, r := range w.marks[1:] {
+					if !from(r.colMark) {
+						break
+					}
+					if r.col > m.col {
+						m = r.colMark
+					}
+				}
+			}
+		}
+		start, end := s.doc.LineStart(n), s.doc.LineEnd(n)
+		runes := func(yield func(rune) bool) {
+			for _, r := range docRunes(s.doc, start+m.offset, end) {
+				if !yield(r) {
+					return
+				}
+			}
+		}
+		// counted is the number of clusters walked from the last mark, the marks are
+		// only added past it
+		counted := 0
+		for c := range streamClustersAt(runes, m.col, m.screenX) {
+			if idx != nil && m.col >= idx.marks[len(idx.marks)-1].col {
+				if counted == colMarkClusters {
+					idx.marks = append(idx.marks, m)
+					counted = 0
+				}
+				counted++
+			}
+			if !yield(m, c) {
+				return
+			}
+			for _, r := range c.runes {
+				m.offset += runeLen(r)
+			}
+			m.col = c.end()
+			m.screenX += c.width
+		}
+		if idx != nil {
+			idx.runes = m.col
+		}
+	}
+}
+
+// lineLen returns the number of runes in line n, counting every invalid byte as one rune.
+// The runes are counted from the bytes of the line, without finding its clusters.
+func (s *Tab) lineLen(n int) int {
+	idx := s.colIndex(n)
+	if idx != nil && idx.runes >= 0 {
+		return idx.runes
+	}
+	count := countRunes(s.doc, s.doc.LineStart(n), s.doc.LineEnd(n))
+	if idx != nil {
+		idx.runes = count
+	}
+	return count
+}
+
+// clampCol returns col limited to the columns of line n
+func (s *Tab) clampCol(n, col int) int {
+	return min(max(col, 0), s.lineLen(n))
+}
+
+// lastCol returns the column of the last cluster of line n, 0 for an empty line. The
+// clusters are found from the last point of the line where a cluster always ends, so a
+// long line is not walked from its start.
+func (s *Tab) lastCol(n int) int {
+	start, end := s.doc.LineStart(n), s.doc.LineEnd(n)
+	from := max(end-colIndexMinLen, start)
+	tail := s.doc.Slice(from, end-from)
+	// a cluster always ends between two printable ASCII characters
+	i := len(tail) - 1
+	for i > 0 && !(isPrintableASCII(rune(tail[i-1])) && isPrintableASCII(rune(tail[i]))) {
+		i--
+	}
+	if i <= 0 && from > start {
+		return s.moveCol(n, s.lineLen(n), -1)
+	}
+	if i < 0 {
+		return 0
+	}
+	col := s.lineLen(n) - countRunes(s.doc, from+i, end)
+	runes := func(yield func(rune) bool) {
+		for _, r := range docRunes(s.doc, from+i, end) {
+			if !yield(r) {
+				return
+			}
+		}
+	}
+	for c := range streamClustersAt(runes, col, 0) {
+		col = c.col
+	}
+	return col
+}
+
+// offsetOf returns the document offset of the rune at column col of line n
+func (s *Tab) offsetOf(n, col int) int {
+	for m, c := range s.lineClustersFrom(n, func(m colMark) bool { return m.col <= col }) {
+		if col < c.end() {
+			offset := s.doc.LineStart(n) + m.offset
+			for _, r := range c.runes[:col-c.col] {
+				offset += runeLen(r)
+			}
+			return offset
+		}
+	}
+	return s.doc.LineEnd(n)
+}
+
+// moveCol returns the column of the cluster dx clusters away from the cluster
+// containing col on line n, limited to the start and the end of the line
+func (s *Tab) moveCol(n, col, dx int) int {
+	// a column in the last cluster of a long line that was not walked up to it, like the
+	// one of $, is found without walking the line
+	if idx := s.colIndex(n); dx >= 0 && idx != nil && col > idx.marks[len(idx.marks)-1].col {
+		if last := s.lastCol(n); col >= last {
+			if dx == 0 && col < s.lineLen(n) {
+				return last
+			}
+			return s.lineLen(n)
+		}
+	}
+	if dx >= 0 {
+		end := 0
+		for _, c := range s.lineClustersFrom(n, func(m colMark) bool { return m.col <= col }) {
+			end = c.end()
+			if end <= col {
+				continue
+			}
+			if dx == 0 {
+				return c.col
+			}
+			dx--
+		}
+		return end
+	}
+
+	// keep the starts of the last -dx clusters before the one containing col, from
+	// earlier marks while there are not enough of them
+	for bound := col + 1; ; {
+		starts := make([]int, 0, -dx+1)
+		first, count := -1, 0
+		for m, c := range s.lineClustersFrom(n, func(m colMark) bool { return m.col < bound }) {
+			if first < 0 {
+				first = m.col
+			}
+			if c.end() > col {
+				break
+			}
+			starts = append(starts, c.col)
+			count++
+			if len(starts) > -dx {
+				starts = starts[1:]
+			}
+		}
+		if count >= -dx || first <= 0 {
+			if len(starts) == 0 {
+				return 0
+			}
+			return starts[0]
+		}
+		bound = first
+	}
+}
+
+// screenCol converts a logical column of line n into a screen column
+func (s *Tab) screenCol(n, col int) int {
+	screenX := 0
+	for m, c := range s.lineClustersFrom(n, func(m colMark) bool { return m.col <= col }) {
+		if c.col >= col {
+			return m.screenX
+		}
+		screenX = m.screenX + c.width
+	}
+	return screenX
+}
+
+// colAtScreen converts a screen column of line n into the logical column of the cluster drawn there
+func (s *Tab) colAtScreen(n, screenX int) int {
+	end := 0
+	for m, c := range s.lineClustersFrom(n, func(m colMark) bool { return m.screenX <= screenX }) {
+		if screenX < m.screenX+c.width {
+			return c.col
+		}
+		end = c.end()
+	}
+	return end
+}
@@ -0,0 +1,511 @@
+package editor
+
+import (
+	"regexp"
+	"strings"
+)
+
+// textObjectFunc returns the range of a text object around pos, count extends it to
+// the following objects or to the enclosing ones
+type textObjectFunc func(s *Tab, pos Position, count int) (textRange, bool)
+
+// textObjects maps the keys typed after an operator to the text objects. Objects
+// starting with i select the inner text, the ones starting with a also select the
+// surrounding white space, quotes, brackets or tags.
+var textObjects = newTextObjects()
+
+func newTextObjects() map[string]textObjectFunc {
+	objects := map[string]textObjectFunc{}
+	for _, around := range []bool{false, true} {
+		prefix := "i"
+		if around {
+			prefix = "a"
+		}
+		objects[prefix+"w"] = wordObject(false, around)
+		objects[prefix+"W"] = wordObject(true, around)
+		objects[prefix+"s"] = sentenceObject(around)
+		objects[prefix+"p"] = paragraphObject(around)
+		objects[prefix+"t"] = tagObject(around)
+		for _, quote := range `"'` + "`" {
+			objects[prefix+string(quote)] = quoteObject(quote, around)
+		}
+		for _, pair := range []string{"()b", "[]", "{}B", "<>"} {
+			brackets := []rune(pair)
+			object := bracketObject(brackets[0], brackets[1], around)
+			for _, key := range brackets {
+				objects[prefix+string(key)] = object
+			}
+		}
+	}
+	return objects
+}
+
+func isBlank(r rune) bool {
+	return r == ' ' || r == '\t'
+}
+
+// wordObject selects the word under the cursor, or the blanks under it. With around,
+// the blanks after the word are selected too, or the word after the blanks.
+func wordObject(bigWord, around bool) textObjectFunc {
+	return func(s *Tab, pos Position, count int) (textRange, bool) {
+		if s.isEmptyLine(pos.Line) {
+			return textRange{}, false
+		}
+		end := s.newWalker(pos)
+		if end.char() == '\n' {
+			end.prev()
+		}
+		class := charClass(end.char(), bigWord)
+		start := *end
+		// back moves start to the previous character of the line when match accepts it
+		back := func(match func(rune) bool) bool {
+			if start.pos.Col == 0 {
+				return false
+			}
+			start.prev()
+			if !match(start.char()) {
+				start.next()
+				return false
+			}
+			return true
+		}
+		for back(func(r rune) bool { return charClass(r, bigWord) == class }) {
+		}
+		// skipRun moves end past the run of characters of the same class
+		skipRun := func() {
+			c := charClass(end.char(), bigWord)
+			for end.char() != '\n' && charClass(end.char(), bigWord) == c {
+				end.next()
+			}
+		}
+		skipRun()
+		for i := 1; i < count && end.char() != '\n'; i++ {
+			skipRun()
+		}
+		if around {
+			switch {
+			case class == 0:
+				if end.char() != '\n' {
+					skipRun()
+				}
+			case isBlank(end.char()):
+				for isBlank(end.char()) {
+					end.next()
+				}
+			default:
+				for back(isBlank) {
+				}
+			}
+		}
+		return textRange{start: start.pos, end: end.pos}, true
+	}
+}
+
+// isSentenceBlank returns true for the characters that separate sentences, the line
+// breaks inside a paragraph count as blanks
+func isSentenceBlank(r rune) bool {
+	return r == '\n' || isBlank(r)
+}
+
+// isParagraphStart returns true when w is at the start of a paragraph
+func (s *Tab) isParagraphStart(w *textWalker) bool {
+	return w.pos.Col == 0 && (w.pos.Line == 0 || s.isEmptyLine(w.pos.Line-1))
+}
+
+// isParagraphEnd returns true when w is at the end of the last line of a paragraph
+func (s *Tab) isParagraphEnd(w *textWalker) bool {
+	return w.char() == '\n' && (w.pos.Line == s.doc.LineCount()-1 || s.isEmptyLine(w.pos.Line+1))
+}
+
+// isSentenceStart returns true when a sentence starts at w: at the start of the paragraph,
+// or on the first character after the blanks that follow a '.', '!' or '?' and its
+// optional closing characters
+func (s *Tab) isSentenceStart(w textWalker) bool {
+	if s.isParagraphStart(&w) {
+		return true
+	}
+	if isSentenceBlank(w.char()) || !w.prev() || !isSentenceBlank(w.char()) {
+		return false
+	}
+	for isSentenceBlank(w.char()) {
+		if s.isParagraphStart(&w) {
+			return false
+		}
+		w.prev()
+	}
+	for strings.ContainsRune(`)]"'`, w.char()) && !s.isParagraphStart(&w) {
+		w.prev()
+	}
+	return strings.ContainsRune(".!?", w.char())
+}
+
+// sentenceObject selects the sentence under the cursor inside its paragraph, walking from
+// the cursor to the starts of the sentence and of the next ones. With around, the blanks
+// after the sentence are selected too, or the ones before it.
+func sentenceObject(around bool) textObjectFunc {
+	return func(s *Tab, pos Position, count int) (textRange, bool) {
+		if s.isEmptyLine(pos.Line) {
+			return textRange{}, false
+		}
+		cursor := s.newWalker(pos)
+		if cursor.char() == '\n' {
+			cursor.prev()
+		}
+		start := *cursor
+		for !s.isSentenceStart(start) {
+			start.prev()
+		}
+		end := *cursor
+		for n := 0; n < max(count, 1) && !s.isParagraphEnd(&end); {
+			end.next()
+			if s.isSentenceStart(end) {
+				n++
+			}
+		}
+
+		inner := end
+		for positionLess(start.pos, inner.pos) {
+			w := inner
+			w.prev()
+			if !isSentenceBlank(w.char()) {
+				break
+			}
+			inner = w
+		}
+		if !around {
+			end = inner
+		} else if inner.pos == end.pos {
+			for !s.isParagraphStart(&start) {
+				w := start
+				w.prev()
+				if !isSentenceBlank(w.char()) {
+					break
+				}
+				start = w
+			}
+		}
+		return textRange{start: start.pos, end: end.pos}, true
+	}
+}
+
+// paragraphObject selects the lines of the paragraph under the cursor, or the empty lines
+// under it. With around, the empty lines after the paragraph are selected too, or the
+// paragraph after the empty lines.
+func paragraphObject(around bool) textObjectFunc {
+	return func(s *Tab, pos Position, count int) (textRange, bool) {
+		last := s.doc.LineCount() - 1
+		empty := s.isEmptyLine(pos.Line)
+		// blockEnd returns the last line of the block of lines starting at n
+		blockEnd := func(n int) int {
+			e := s.isEmptyLine(n)
+			for n < last && s.isEmptyLine(n+1) == e {
+				n++
+			}
+			return n
+		}
+		start := pos.Line
+		for start > 0 && s.isEmptyLine(start-1) == empty {
+			start--
+		}
+		end := blockEnd(pos.Line)
+		for i := 1; i < count; i++ {
+			if end == last {
+				return textRange{}, false
+			}
+			end = blockEnd(end + 1)
+		}
+		if around {
+			if end < last {
+				end = blockEnd(end + 1)
+			} else if !empty {
+				for start > 0 && s.isEmptyLine(start-1) {
+					start--
+				}
+			}
+		}
+		return textRange{start: Position{Line: start}, end: Position{Line: end}, linewise: true}, true
+	}
+}
+
+// quoteObject selects the text between the quotes around the cursor in its line. Quotes
+// escaped with a backslash are skipped. The quotes are found by walking from the cursor,
+// the quotes before it are only counted when it is on a quote, to know whether that
+// quote opens or closes a string. With around, the quotes and the blanks after them are
+// selected too, or the blanks before them.
+func quoteObject(quote rune, around bool) textObjectFunc {
+	// isQuote returns true when w is on a quote that is not escaped by an odd number of
+	// backslashes
+	isQuote := func(w textWalker) bool {
+		if w.char() != quote {
+			return false
+		}
+		escaped := false
+		for w.pos.Col > 0 {
+			w.prev()
+			if w.char() != '\\' {
+				break
+			}
+			escaped = !escaped
+		}
+		return !escaped
+	}
+	// nextQuote and prevQuote move w to the next or the previous quote of its line
+	nextQuote := func(w *textWalker) bool {
+		for w.char() != '\n' {
+			w.next()
+			if isQuote(*w) {
+				return true
+			}
+		}
+		return false
+	}
+	prevQuote := func(w *textWalker) bool {
+		for w.pos.Col > 0 {
+			w.prev()
+			if isQuote(*w) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(s *Tab, pos Position, _ int) (textRange, bool) {
+		cursor := s.newWalker(pos)
+		open, closing := *cursor, *cursor
+		var ok bool
+		switch {
+		case isQuote(*cursor):
+			// a quote under the cursor opens or closes a string depending on the quotes before it
+			before := 0
+			for w := *cursor; prevQuote(&w); {
+				before++
+			}
+			if before%2 == 0 {
+				ok = nextQuote(&closing)
+			} else {
+				ok = prevQuote(&open)
+			}
+		case prevQuote(&open):
+			ok = nextQuote(&closing)
+		default:
+			open = *cursor
+			ok = nextQuote(&open)
+			closing = open
+			ok = ok && nextQuote(&closing)
+		}
+		if !ok {
+			return textRange{}, false
+		}
+
+		start, end := open, closing
+		start.next()
+		if around {
+			start = open
+			end.next()
+			blanks := end
+			for isBlank(blanks.char()) {
+				blanks.next()
+			}
+			if blanks.pos != end.pos {
+				end = blanks
+			} else {
+				for start.pos.Col > 0 {
+					w := start
+					w.prev()
+					if !isBlank(w.char()) {
+						break
+					}
+					start = w
+				}
+			}
+		}
+		return textRange{start: start.pos, end: end.pos}, true
+	}
+}
+
+// findEnclosing returns the positions of the brackets around pos, a bracket under the
+// cursor counts as one of them
+func (s *Tab) findEnclosing(pos Position, open, closing rune) (Position, Position, bool) {
+	w := s.newWalker(pos)
+	depth := 0
+	for {
+		if c := w.char(); c == closing && w.pos != pos {
+			depth++
+		} else if c == open {
+			if depth == 0 {
+				break
+			}
+			depth--
+		}
+		if !w.prev() {
+			return Position{}, Position{}, false
+		}
+	}
+	openPos := w.pos
+	depth = 0
+	for {
+		if !w.next() {
+			return Position{}, Position{}, false
+		}
+		if c := w.char(); c == open {
+			depth++
+		} else if c == closing {
+			if depth == 0 {
+				return openPos, w.pos, true
+			}
+			depth--
+		}
+	}
+}
+
+// bracketObject selects the text between the brackets around the cursor, count selects
+// the enclosing pairs. When the brackets are on lines of their own, the inner text is the
+// lines between them. With around, the brackets are selected too.
+func bracketObject(open, closing rune, around bool) textObjectFunc {
+	return func(s *Tab, pos Position, count int) (textRange, bool) {
+		openPos, closePos, ok := s.findEnclosing(pos, open, closing)
+		for i := 1; ok && i < count; i++ {
+			w := s.newWalker(openPos)
+			if !w.prev() {
+				return textRange{}, false
+			}
+			openPos, closePos, ok = s.findEnclosing(w.pos, open, closing)
+		}
+		if !ok {
+			return textRange{}, false
+		}
+		if around {
+			return textRange{start: openPos, end: Position{Line: closePos.Line, Col: closePos.Col + 1}}, true
+		}
+		r := textRange{start: Position{Line: openPos.Line, Col: openPos.Col + 1}, end: closePos}
+		if r.start.Col == s.lineLen(openPos.Line) && closePos.Col <= s.indentCol(closePos.Line) && closePos.Line-openPos.Line > 1 {
+			return textRange{start: Position{Line: openPos.Line + 1}, end: Position{Line: closePos.Line - 1}, linewise: true}, true
+		}
+		return r, true
+	}
+}
+
+// tagPattern matches an opening, closing or self-closing tag
+var tagPattern = regexp.MustCompile(`<(/?)([A-Za-z][^\s/>]*)[^>]*?(/?)>`)
+
+// tag is a tag of the text, end is the position after its '>'
+type tag struct {
+	start, end  Position
+	name        string
+	closing     bool
+	selfClosing bool
+}
+
+// readTag reads the tag starting at w, it returns the tag and a walker after it. A tag
+// ends at the first '>' and does not contain '<'.
+func readTag(w textWalker) (tag, textWalker, bool) {
+	if w.char() != '<' {
+		return tag{}, w, false
+	}
+	start := w.pos
+	var b strings.Builder
+	b.WriteRune('<')
+	for w.next() {
+		r := w.char()
+		if r == '<' {
+			break
+		}
+		b.WriteRune(r)
+		if r != '>' {
+			continue
+		}
+		w.next()
+		text := b.String()
+		m := tagPattern.FindStringSubmatchIndex(text)
+		if m == nil {
+			break
+		}
+		return tag{start: start, end: w.pos, name: text[m[4]:m[5]], closing: m[3] > m[2], selfClosing: m[7] > m[6]}, w, true
+	}
+	return tag{}, w, false
+}
+
+// lastIndexFold returns the index of the last of names equal to name ignoring case, -1
+// when there is none
+func lastIndexFold(names []string, name string) int {
+	for i := len(names) - 1; i >= 0; i-- {
+		if strings.EqualFold(names[i], name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// closingTag returns the tag closing open, reading the text from w after open. Unclosed
+// tags inside it are skipped, open is unclosed when a tag around it is closed first.
+func closingTag(open tag, w textWalker) (tag, bool) {
+	names := []string{open.name}
+	for {
+		t, after, ok := readTag(w)
+		if !ok || t.selfClosing {
+			if !w.next() {
+				return tag{}, false
+			}
+			continue
+		}
+		w = after
+		if !t.closing {
+			names = append(names, t.name)
+			continue
+		}
+		i := lastIndexFold(names, t.name)
+		switch {
+		case i == 0:
+			return t, true
+		case i < 0:
+			return tag{}, false
+		}
+		names = names[:i]
+	}
+}
+
+// tagObject selects the text between the tags around the cursor, count selects the
+// enclosing tags. The opening tags are searched backward from the cursor, skipping the
+// ones closed before it, and each is matched forward with its closing tag. With around,
+// the tags are selected too.
+func tagObject(around bool) textObjectFunc {
+	return func(s *Tab, pos Position, count int) (textRange, bool) {
+		w := s.newWalker(pos)
+		cursor := w.pos
+		// closed are the names of the tags closed between the walker and the cursor, the
+		// closest to the walker last
+		var closed []string
+		found := 0
+		for {
+			if t, after, ok := readTag(*w); ok && !t.selfClosing {
+				switch {
+				case t.closing:
+					// a closing tag under the cursor is matched from its opening tag
+					if !positionLess(cursor, t.end) {
+						closed = append(closed, t.name)
+					}
+				default:
+					if i := lastIndexFold(closed, t.name); i >= 0 {
+						closed = closed[:i]
+						break
+					}
+					closing, ok := closingTag(t, after)
+					if !ok || !positionLess(cursor, closing.end) {
+						break
+					}
+					found++
+					if found < max(count, 1) {
+						break
+					}
+					if around {
+						return textRange{start: t.start, end: closing.end}, true
+					}
+					return textRange{start: t.end, end: closing.start}, true
+				}
+			}
+			if !w.prev() {
+				return textRange{}, false
+			}
+		}
+	}
+}
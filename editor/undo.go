@@ -0,0 +1,241 @@
+package editor
+
+import "time"
+
+// editOp is a single insertion or deletion of text in a Document
+type editOp struct {
+	offset int
+	text   []byte
+	insert bool
+}
+
+func (o editOp) apply(d Document) {
+	if o.insert {
+		d.Insert(o.offset, o.text)
+	} else {
+		d.Delete(o.offset, len(o.text))
+	}
+}
+
+func (o editOp) revert(d Document) {
+	if o.insert {
+		d.Delete(o.offset, len(o.text))
+	} else {
+		d.Insert(o.offset, o.text)
+	}
+}
+
+// undoState is a node of the undo tree. It holds the change that leads from its parent to it.
+type undoState struct {
+	seq      int
+	time     time.Time
+	parent   *undoState
+	children []*undoState
+	// redoChild is the index of the child that redo follows, the most recently used branch
+	redoChild int
+	ops       []editOp
+}
+
+// UndoTree records every change of a Document. Undoing and then making a new change
+// starts a new branch instead of dropping the undone changes, all states stay reachable
+// in chronological order with Earlier and Later.
+type UndoTree struct {
+	doc     Document
+	current *undoState
+	// states holds every state by sequence number, states[0] is the original document
+	states  []*undoState
+	pending []editOp
+	depth   int
+}
+
+// NewUndoTree creates an empty undo tree for doc
+func NewUndoTree(doc Document) *UndoTree {
+	root := &undoState{time: time.Now()}
+	return &UndoTree{
+		doc:     doc,
+		current: root,
+		states:  []*undoState{root},
+	}
+}
+
+// Begin starts a group of edits that is undone as a single change. Groups can be nested,
+// the change is committed when the outermost group ends.
+func (u *UndoTree) Begin() {
+	u.depth++
+}
+
+// End ends a group of edits started by Begin
+func (u *UndoTree) End() {
+	if u.depth == 0 {
+		return
+	}
+	u.depth--
+	if u.depth == 0 {
+		u.commit()
+	}
+}
+
+// Record records an edit that has already been applied to the document
+func (u *UndoTree) Record(op editOp) {
+	if n := len(u.pending); n > 0 && u.merge(&u.pending[n-1], op) {
+		return
+	}
+	u.pending = append(u.pending, op)
+	if u.depth == 0 {
+		u.commit()
+	}
+}
+
+// merge folds op into last when both are consecutive insertions or deletions
+func (u *UndoTree) merge(last *editOp, op editOp) bool {
+	if last.insert != op.insert {
+		return false
+	}
+	if op.insert && op.offset == last.offset+len(last.text) {
+		last.text = append(last.text, op.text...)
+		return true
+	}
+	if !op.insert && op.offset == last.offset {
+		last.text = append(last.text, op.text...)
+		return true
+	}
+	if !op.insert && op.offset+len(op.text) == last.offset {
+		last.text = append(op.text, last.text...)
+		last.offset = op.offset
+		return true
+	}
+	return false
+}
+
+func (u *UndoTree) commit() {
+	if len(u.pending) == 0 {
+		return
+	}
+	state := &undoState{
+		seq:    len(u.states),
+		time:   time.Now(),
+		parent: u.current,
+		ops:    u.pending,
+	}
+	u.pending = nil
+	u.current.children = append(u.current.children, state)
+	u.current.redoChild = len(u.current.children) - 1
+	u.states = append(u.states, state)
+	u.current = state
+}
+
+// Seq returns the sequence number of the current state, 0 is the original document
+func (u *UndoTree) Seq() int {
+	return u.current.seq
+}
+
+// Pending returns true when edits of a group that has not ended yet are not committed
+func (u *UndoTree) Pending() bool {
+	return len(u.pending) > 0
+}
+
+// LastSeq returns the sequence number of the newest state
+func (u *UndoTree) LastSeq() int {
+	return len(u.states) - 1
+}
+
+// Undo reverts the current change and returns the offset where it happened
+func (u *UndoTree) Undo() (offset int, ok bool) {
+	if u.current.parent == nil {
+		return 0, false
+	}
+	return u.undoState(), true
+}
+
+// Redo applies the most recently used child change and returns the offset where it happened
+func (u *UndoTree) Redo() (offset int, ok bool) {
+	if len(u.current.children) == 0 {
+		return 0, false
+	}
+	return u.redoState(u.current.children[u.current.redoChild]), true
+}
+
+func (u *UndoTree) undoState() int {
+	state := u.current
+	for i := len(state.ops) - 1; i >= 0; i-- {
+		state.ops[i].revert(u.doc)
+	}
+	u.current = state.parent
+	return state.ops[0].offset
+}
+
+func (u *UndoTree) redoState(child *undoState) int {
+	for i, c := range u.current.children {
+		if c == child {
+			u.current.redoChild = i
+		}
+	}
+	for _, op := range child.ops {
+		op.apply(u.doc)
+	}
+	u.current = child
+	return child.ops[0].offset
+}
+
+// Goto moves the document to the state with sequence number seq by undoing up to the
+// common ancestor and redoing down to the target. It returns the offset of the last change.
+func (u *UndoTree) Goto(seq int) (offset int, ok bool) {
+	seq = min(max(seq, 0), u.LastSeq())
+	target := u.states[seq]
+	if target == u.current {
+		return 0, false
+	}
+
+	ancestors := map[*undoState]bool{}
+	for s := u.current; s != nil; s = s.parent {
+		ancestors[s] = true
+	}
+	var down []*undoState
+	common := target
+	for !ancestors[common] {
+		down = append(down, common)
+		common = common.parent
+	}
+
+	for u.current != common {
+		offset = u.undoState()
+	}
+	for i := len(down) - 1; i >= 0; i-- {
+		offset = u.redoState(down[i])
+	}
+	return offset, true
+}
+
+// Earlier moves count states back in time, regardless of branches
+func (u *UndoTree) Earlier(count int) (offset int, ok bool) {
+	return u.Goto(u.current.seq - count)
+}
+
+// Later moves count states forward in time, regardless of branches
+func (u *UndoTree) Later(count int) (offset int, ok bool) {
+	return u.Goto(u.current.seq + count)
+}
+
+// EarlierTime moves to the newest state made at least d before the current one
+func (u *UndoTree) EarlierTime(d time.Duration) (offset int, ok bool) {
+	limit := u.current.time.Add(-d)
+	seq := 0
+	for _, s := range u.states {
+		if !s.time.After(limit) {
+			seq = s.seq
+		}
+	}
+	return u.Goto(seq)
+}
+
+// LaterTime moves to the newest state made at most d after the current one
+func (u *UndoTree) LaterTime(d time.Duration) (offset int, ok bool) {
+	limit := u.current.time.Add(d)
+	seq := u.current.seq
+	for _, s := range u.states[u.current.seq:] {
+		if !s.time.After(limit) {
+			seq = s.seq
+		}
+	}
+	return u.Goto(seq)
+}
@@ -0,0 +1,58 @@
+package editor
+
+import (
+	"github.com/test-go/testify/require"
+	"testing"
+)
+
+func docText(d Document) string {
+	return string(d.Slice(0, d.Len()))
+}
+
+func TestUndoTreeBranches(t *testing.T) {
+	doc := NewPieceTable([]byte("abc"))
+	u := NewUndoTree(doc)
+	edit := func(op editOp) {
+		op.apply(doc)
+		u.Record(op)
+	}
+
+	u.Begin()
+	edit(editOp{offset: 3, text: []byte("d"), insert: true})
+	edit(editOp{offset: 4, text: []byte("e"), insert: true})
+	u.End()
+	require.Equal(t, "abcde", docText(doc))
+	edit(editOp{offset: 0, text: []byte("a")})
+	require.Equal(t, "bcde", docText(doc))
+	require.Equal(t, 2, u.Seq())
+
+	_, ok := u.Undo()
+	require.True(t, ok)
+	require.Equal(t, "abcde", docText(doc))
+	offset, _ := u.Undo()
+	require.Equal(t, 3, offset)
+	require.Equal(t, "abc", docText(doc))
+	_, ok = u.Undo()
+	require.False(t, ok)
+
+	// a new change after undo starts a branch
+	edit(editOp{offset: 0, text: []byte("x"), insert: true})
+	require.Equal(t, "xabc", docText(doc))
+	require.Equal(t, 3, u.Seq())
+
+	_, ok = u.Earlier(1)
+	require.True(t, ok)
+	require.Equal(t, "bcde", docText(doc))
+	_, ok = u.Earlier(1)
+	require.True(t, ok)
+	require.Equal(t, "abcde", docText(doc))
+	_, ok = u.Later(2)
+	require.True(t, ok)
+	require.Equal(t, "xabc", docText(doc))
+	_, ok = u.Redo()
+	require.False(t, ok)
+
+	u.Undo()
+	u.Redo()
+	require.Equal(t, "xabc", docText(doc))
+}
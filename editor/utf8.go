@@ -0,0 +1,132 @@
+package editor
+
+import (
+	"fmt"
+	"iter"
+	"unicode/utf8"
+)
+
+// invalidByteBase is used to keep bytes that are not valid UTF-8 while editing.
+// Like Python's surrogateescape, an invalid byte b (always >= 0x80) is decoded to the
+// lone surrogate invalidByteBase+b. Such a rune can never come out of valid UTF-8,
+// so appendRune can turn it back into the exact original byte.
+const invalidByteBase = 0xDC00
+
+// decodeRune decodes the first rune of b, mapping an invalid byte to its escape rune
+func decodeRune(b []byte) (rune, int) {
+	r, size := utf8.DecodeRune(b)
+	if r == utf8.RuneError && size == 1 {
+		return invalidByteBase + rune(b[0]), 1
+	}
+	return r, size
+}
+
+// decodeLastRune decodes the last rune of b, mapping an invalid byte to its escape rune
+func decodeLastRune(b []byte) (rune, int) {
+	r, size := utf8.DecodeLastRune(b)
+	if r == utf8.RuneError && size == 1 {
+		return invalidByteBase + rune(b[len(b)-1]), 1
+	}
+	return r, size
+}
+
+// decodeRunes decodes b into runes, mapping every invalid byte to its escape rune
+func decodeRunes(b []byte) []rune {
+	res := make([]rune, 0, len(b))
+	for len(b) > 0 {
+		r, size := decodeRune(b)
+		res = append(res, r)
+		b = b[size:]
+	}
+	return res
+}
+
+// docRunes iterates over the runes of d in [from, to), yielding the offset of every rune.
+// The bytes are decoded as they are read, nothing is copied beyond a small window.
+func docRunes(d Document, from, to int) iter.Seq2[int, rune] {
+	return func(yield func(int, rune) bool) {
+		offset := from
+		buf := make([]byte, 0, 4096+utf8.UTFMax)
+		// decode decodes the runes of buf. Unless final, it stops when the rest of
+		// buf may be the start of a rune continued in the next chunk.
+		decode := func(final bool) bool {
+			i := 0
+			for i < len(buf) && (final || len(buf)-i >= utf8.UTFMax) {
+				r, size := decodeRune(buf[i:])
+				if !yield(offset, r) {
+					return false
+				}
+				offset += size
+				i += size
+			}
+			buf = append(buf[:0], buf[i:]...)
+			return true
+		}
+		for chunk := range d.Chunks(from, to) {
+			for len(chunk) > 0 {
+				n := min(len(chunk), cap(buf)-len(buf))
+				buf = append(buf, chunk[:n]...)
+				chunk = chunk[n:]
+				if !decode(false) {
+					return
+				}
+			}
+		}
+		decode(true)
+	}
+}
+
+// countRunes returns the number of runes of d in [from, to), the runes that docRunes
+// yields. utf8.RuneCount counts every invalid byte as one rune too.
+func countRunes(d Document, from, to int) int {
+	count := 0
+	buf := make([]byte, 0, 4096+utf8.UTFMax)
+	for chunk := range d.Chunks(from, to) {
+		for len(chunk) > 0 {
+			n := min(len(chunk), cap(buf)-len(buf))
+			buf = append(buf, chunk[:n]...)
+			chunk = chunk[n:]
+			// a byte that is not a continuation byte always starts a rune, a rune starting
+			// in the last bytes may continue in the next chunk
+			cut := len(buf)
+			for i := len(buf) - 1; i >= len(buf)-utf8.UTFMax && i >= 0; i-- {
+				if utf8.RuneStart(buf[i]) {
+					cut = i
+					break
+				}
+			}
+			count += utf8.RuneCount(buf[:cut])
+			buf = append(buf[:0], buf[cut:]...)
+		}
+	}
+	return count + utf8.RuneCount(buf)
+}
+
+// appendRune appends the UTF-8 encoding of r to dst, escape runes are written as the original byte
+func appendRune(dst []byte, r rune) []byte {
+	if b, ok := invalidByte(r); ok {
+		return append(dst, b)
+	}
+	return utf8.AppendRune(dst, r)
+}
+
+// runeLen returns the number of bytes of r in the document, an escape rune is one byte
+func runeLen(r rune) int {
+	if _, ok := invalidByte(r); ok {
+		return 1
+	}
+	return utf8.RuneLen(r)
+}
+
+// invalidByte returns the original byte if r is the escape rune of an invalid byte
+func invalidByte(r rune) (byte, bool) {
+	if r >= invalidByteBase+0x80 && r <= invalidByteBase+0xFF {
+		return byte(r - invalidByteBase), true
+	}
+	return 0, false
+}
+
+// invalidByteText returns how an invalid byte is displayed, e.g. <ff>
+func invalidByteText(b byte) string {
+	return fmt.Sprintf("<%02x>", b)
+}
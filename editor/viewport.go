@@ -0,0 +1,75 @@
+package editor
+
+const (
+	// precedesChar is drawn in the first column of a line that continues left of the view
+	precedesChar = '<'
+	// extendsChar is drawn in the last column of a line that continues right of the view
+	extendsChar = '>'
+)
+
+// scroll scrolls the view as little as possible to show the cursor, updates cursorPos.Y
+// and returns the screen column of the cursor in its row, before horizontal scrolling
+func (s *Tab) scroll() int {
+	l := s.lineLayout()
+	renderSize := s.GetRenderSize()
+	height := max(renderSize.Height, 1)
+	cursorRow, cursorX := s.placeOf(l, s.lineIndex, s.cursorPos.X)
+	if s.topLine >= s.doc.LineCount() {
+		s.topLine, s.topRow = s.doc.LineCount()-1, 0
+	}
+
+	if s.lineIndex < s.topLine || (s.lineIndex == s.topLine && cursorRow < s.topRow) {
+		s.topLine, s.topRow = s.lineIndex, cursorRow
+	} else if s.lineIndex-s.topLine >= height || s.rowsBefore(l, s.topLine, s.lineIndex)-s.topRow+cursorRow >= height {
+		// the cursor goes to the last row, the rows above it are filled from the lines before
+		need := height - 1 - cursorRow
+		s.topLine, s.topRow = s.lineIndex, max(-need, 0)
+		for n := s.lineIndex - 1; need > 0 && n >= 0; n-- {
+			rows := s.rowCount(l, n)
+			s.topLine, s.topRow = n, max(rows-need, 0)
+			need -= rows
+		}
+	}
+	s.cursorPos.Y = s.rowsBefore(l, s.topLine, s.lineIndex) - s.topRow + cursorRow
+
+	if l.width > 0 {
+		s.leftCol = 0
+	} else {
+		s.scrollSideways(cursorX, max(renderSize.Width, 1))
+	}
+	return cursorX
+}
+
+// scrollSideways scrolls the view horizontally to keep sidescrolloff columns around the
+// screen column x visible. The view moves by at least sidescroll columns, a cursor that
+// is far away or a sidescroll of 0 puts the cursor in the middle of the view.
+func (s *Tab) scrollSideways(x, width int) {
+	off := min(globalOptions.sideScrollOff, (width-1)/2)
+	step := globalOptions.sideScroll
+	switch {
+	case x-off < s.leftCol:
+		diff := s.leftCol - (x - off)
+		if step == 0 || diff >= width/2 {
+			s.leftCol = x - width/2
+		} else {
+			s.leftCol -= max(diff, step)
+		}
+	case x+off >= s.leftCol+width:
+		diff := x + off - (s.leftCol + width - 1)
+		if step == 0 || diff >= width/2 {
+			s.leftCol = x - width/2
+		} else {
+			s.leftCol += max(diff, step)
+		}
+	}
+	s.leftCol = max(s.leftCol, 0)
+}
+
+// rowsBefore returns the number of screen rows of the lines from to to, to excluded
+func (s *Tab) rowsBefore(l lineLayout, from, to int) int {
+	rows := 0
+	for n := from; n < to; n++ {
+		rows += s.rowCount(l, n)
+	}
+	return rows
+}
@@ -0,0 +1,118 @@
+package editor
+
+import (
+	"github.com/dangdungcntt/ndditor/editor/layout"
+	"github.com/gdamore/tcell/v2"
+	"github.com/test-go/testify/require"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// screenText returns the text of the first width cells of row y
+func screenText(screen tcell.SimulationScreen, y, width int) string {
+	cells, w, _ := screen.GetContents()
+	text := ""
+	for x := range width {
+		runes := cells[y*w+x].Runes
+		if len(runes) == 0 {
+			text += " "
+			continue
+		}
+		text += string(runes)
+	}
+	return text
+}
+
+func TestSideScroll(t *testing.T) {
+	defer func() {
+		globalOptions.wrap = true
+		globalOptions.sideScroll, globalOptions.sideScrollOff = 0, 0
+	}()
+	_, err := setOptions(nil, "nowrap ss=1 siso=2")
+	require.NoError(t, err)
+
+	screen := tcell.NewSimulationScreen("")
+	require.NoError(t, screen.Init())
+	screen.SetSize(6, 2)
+	tab := NewTab("", NewPieceTable([]byte("0123456789\nab")))
+	tab.SetRenderSize(layout.Size{Width: 6, Height: 2})
+	render := func() {
+		screen.Clear()
+		tab.Render(screen, layout.Point{})
+		screen.Show()
+	}
+
+	render()
+	require.Equal(t, "01234>", screenText(screen, 0, 6))
+	tab.SetCursor(Position{Col: 4})
+	render()
+	require.Equal(t, 1, tab.leftCol)
+	require.Equal(t, "<2345>", screenText(screen, 0, 6))
+	require.Equal(t, "<     ", screenText(screen, 1, 6))
+
+	tab.SetCursor(Position{Col: 10})
+	render()
+	require.Equal(t, 7, tab.leftCol)
+	require.Equal(t, "<89   ", screenText(screen, 0, 6))
+	x, y, _ := screen.GetCursor()
+	require.Equal(t, []int{3, 0}, []int{x, y})
+
+	tab.SetCursor(Position{Col: 0})
+	render()
+	require.Equal(t, 0, tab.leftCol)
+}
+
+func TestNowrapLongLine(t *testing.T) {
+	defer func() { globalOptions.wrap = true }()
+	globalOptions.wrap = false
+	line := strings.Repeat("ab\tcde\u0301 世界 x", 50000)
+	tab := NewTab("", NewPieceTable([]byte("first\n"+line+"\nlast")))
+	tab.SetRenderSize(layout.Size{Width: 80, Height: 24})
+	screen := tcell.NewSimulationScreen("")
+	require.NoError(t, screen.Init())
+	screen.SetSize(80, 24)
+
+	// the columns measured from the indexed cluster starts are the ones of the whole line
+	same := func() {
+		runes := slices.Collect(tab.lineRunes(1))
+		clusters := lineClusters(runes)
+		require.Equal(t, len(runes), tab.lineLen(1))
+		for _, col := range []int{0, 7, 5000, 123457, len(runes) - 3, len(runes)} {
+			offset := tab.doc.LineStart(1) + len(string(runes[:col]))
+			require.Equal(t, offset, tab.offsetOf(1, col))
+			require.Equal(t, Position{Line: 1, Col: col}, tab.positionOf(offset))
+			require.Equal(t, screenColumn(clusters, col), tab.screenCol(1, col))
+			i := clusterIndex(clusters, col)
+			require.Equal(t, clusterCol(clusters, i), tab.colAtScreen(1, screenColumn(clusters, clusterCol(clusters, i))))
+			require.Equal(t, clusterCol(clusters, i+1), tab.moveCol(1, col, 1))
+			require.Equal(t, clusterCol(clusters, max(i-300, 0)), tab.moveCol(1, col, -300))
+		}
+	}
+	same()
+
+	// typing at the end of the line measures it from the closest cluster start
+	tab.SetCursor(Position{Line: 1, Col: tab.lineLen(1)})
+	tab.Render(screen, layout.Point{})
+	start := time.Now()
+	tab.BeginChange()
+	for range 100 {
+		tab.InsertRune('y')
+		tab.InsertRune('\t')
+		tab.Render(screen, layout.Point{})
+	}
+	tab.EndChange()
+	require.True(t, time.Since(start) < time.Second)
+	same()
+
+	// a combining mark joins the cluster before it
+	tab.SetCursor(Position{Line: 1, Col: 5})
+	tab.BeginChange()
+	tab.InsertRune('\u0301')
+	tab.EndChange()
+	same()
+	require.NoError(t, tab.Undo())
+	require.Equal(t, "first\n"+line+strings.Repeat("y\t", 100)+"\nlast", tabText(tab))
+	same()
+}
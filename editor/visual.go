@@ -0,0 +1,337 @@
+package editor
+
+import (
+	"bytes"
+	"github.com/gdamore/tcell/v2"
+	"slices"
+	"unicode"
+)
+
+// selectionStyle is the style of the text selected in visual mode
+var selectionStyle = tcell.StyleDefault.Reverse(true)
+
+// isVisualMode returns true for the characterwise, linewise and blockwise visual modes
+func isVisualMode(m int) bool {
+	return m == ModeVisual || m == ModeVisualLine || m == ModeVisualBlock
+}
+
+// blockInsert is the block of lines where the text typed after I, A or c in visual block
+// mode is inserted when insert mode ends
+type blockInsert struct {
+	first, last int
+	// x is the screen column of the insertion
+	x int
+	// pad is true when the lines shorter than x are padded with spaces, they are skipped
+	// otherwise
+	pad bool
+}
+
+// StartVisual starts selecting text from the cursor in the visual mode m, the anchor is
+// kept when switching between visual modes. The additional cursors are removed.
+func (s *Tab) StartVisual(m int) {
+	if !isVisualMode(s.visual) {
+		s.visualAnchor = s.GetCursor()
+		s.ClearCursors()
+	}
+	s.visual = m
+}
+
+// EndVisual ends the selection, its first and last lines are kept in the marks < and >
+func (s *Tab) EndVisual() {
+	if isVisualMode(s.visual) {
+		first, last := s.selectedLines()
+		s.setMark('<', Position{Line: first})
+		s.setMark('>', Position{Line: last})
+	}
+	s.visual = ModeView
+}
+
+// swapAnchor moves the cursor to the other end of the selection
+func (s *Tab) swapAnchor() {
+	pos := s.GetCursor()
+	s.SetCursor(s.visualAnchor)
+	s.visualAnchor = pos
+}
+
+// selectedLines returns the first and the last line of the selection
+func (s *Tab) selectedLines() (int, int) {
+	return min(s.visualAnchor.Line, s.lineIndex), max(s.visualAnchor.Line, s.lineIndex)
+}
+
+// selection returns the text selected in characterwise or linewise visual mode. The
+// character at the end is selected, and the line break when the end is past the text
+// of its line.
+func (s *Tab) selection() textRange {
+	start, end := s.visualAnchor, s.GetCursor()
+	if positionLess(end, start) {
+		start, end = end, start
+	}
+	if s.visual == ModeVisualLine {
+		return textRange{start: Position{Line: start.Line}, end: Position{Line: end.Line}, linewise: true}
+	}
+	if n := s.lineLen(end.Line); end.Col >= n {
+		if end.Line < s.doc.LineCount()-1 {
+			return textRange{start: start, end: Position{Line: end.Line + 1}}
+		}
+		return textRange{start: start, end: Position{Line: end.Line, Col: n}}
+	}
+	end.Col = s.moveCol(end.Line, end.Col, 1)
+	return textRange{start: start, end: end}
+}
+
+// blockColumns returns the screen columns [left, right) of the visual block, from the
+// anchor to the cluster under the cursor
+func (s *Tab) blockColumns() (int, int) {
+	left, right := -1, 0
+	for _, pos := range []Position{s.visualAnchor, s.GetCursor()} {
+		start := s.screenCol(pos.Line, pos.Col)
+		end := max(s.screenCol(pos.Line, s.moveCol(pos.Line, pos.Col, 1)), start+1)
+		if left < 0 || start < left {
+			left = start
+		}
+		right = max(right, end)
+	}
+	return left, right
+}
+
+// blockCols returns the columns [start, end) of the clusters of line n drawn between
+// the screen columns left and right
+func (s *Tab) blockCols(n, left, right int) (int, int) {
+	start, end := -1, 0
+	for m, c := range s.lineClustersFrom(n, func(m colMark) bool { return m.screenX <= left }) {
+		x := m.screenX
+		if x >= right {
+			break
+		}
+		if x+c.width > left && start < 0 {
+			start = c.col
+		}
+		end = c.end()
+	}
+	if start < 0 {
+		return end, end
+	}
+	return start, end
+}
+
+// selectedCols returns the columns [start, end) of line n covered by the selection, end
+// is past the text of the line when its line break is selected
+func (s *Tab) selectedCols(n int) (int, int) {
+	first, last := s.selectedLines()
+	if !isVisualMode(s.visual) || n < first || n > last {
+		return 0, 0
+	}
+	switch s.visual {
+	case ModeVisualLine:
+		return 0, s.lineLen(n) + 1
+	case ModeVisualBlock:
+		left, right := s.blockColumns()
+		return s.blockCols(n, left, right)
+	}
+	r := s.selection()
+	start, end := 0, s.lineLen(n)+1
+	if n == r.start.Line {
+		start = r.start.Col
+	}
+	if n == r.end.Line {
+		end = r.end.Col
+	}
+	return start, end
+}
+
+// applyVisual applies the operator op to the selection as a single change. The deleted
+// or yanked text is stored into register, count repeats the shifts of > and <.
+func (s *Tab) applyVisual(op, register rune, count int) {
+	if s.hexMode {
+		return
+	}
+	first, last := s.selectedLines()
+	s.BeginChange()
+	defer s.EndChange()
+	switch {
+	case op == '>' || op == '<':
+		count = max(count, 1)
+		if op == '<' {
+			count = -count
+		}
+		s.SetCursor(Position{Line: first})
+		s.ShiftLines(first, last, count)
+	case op == '~' || op == 'u' || op == 'U':
+		cols := make([][2]int, 0, last-first+1)
+		for n := first; n <= last; n++ {
+			start, end := s.selectedCols(n)
+			cols = append(cols, [2]int{start, min(end, s.lineLen(n))})
+		}
+		for i, c := range cols {
+			s.changeCase(first+i, c[0], c[1], op)
+		}
+		s.SetCursor(Position{Line: first, Col: cols[0][0]})
+	case op == 'J':
+		s.JoinLines(first, max(last, first+1))
+	case s.visual == ModeVisualBlock:
+		s.applyBlock(op, register, first, last)
+	default:
+		if op == 'x' {
+			op = 'd'
+		}
+		s.applyRange(op, register, s.selection())
+	}
+}
+
+// applyBlock deletes or yanks the text of the visual block on the lines from first
+// to last, the text is stored into the register name
+func (s *Tab) applyBlock(op, name rune, first, last int) {
+	left, right := s.blockColumns()
+	cols := make([][2]int, 0, last-first+1)
+	var text []byte
+	for n := first; n <= last; n++ {
+		start, end := s.blockCols(n, left, right)
+		cols = append(cols, [2]int{start, end})
+		if n > first {
+			text = append(text, '\n')
+		}
+		from := s.offsetOf(n, start)
+		text = append(text, s.doc.Slice(from, s.offsetOf(n, end)-from)...)
+	}
+	storeRegister(name, op, register{text: text, blockwise: true})
+	if op != 'y' {
+		for i, c := range cols {
+			from := s.offsetOf(first+i, c[0])
+			s.deleteText(from, s.offsetOf(first+i, c[1])-from)
+		}
+	}
+	s.SetCursor(Position{Line: first, Col: cols[0][0]})
+}
+
+// changeCase toggles (~), lowers (u) or uppers (U) the case of the columns [start, end)
+// of line n
+func (s *Tab) changeCase(n, start, end int, op rune) {
+	from := s.offsetOf(n, start)
+	text := s.doc.Slice(from, s.offsetOf(n, end)-from)
+	var changed []byte
+	for _, r := range decodeRunes(text) {
+		switch {
+		case op == 'u', op == '~' && unicode.IsUpper(r):
+			r = unicode.ToLower(r)
+		default:
+			r = unicode.ToUpper(r)
+		}
+		changed = appendRune(changed, r)
+	}
+	if bytes.Equal(changed, text) {
+		return
+	}
+	s.deleteText(from, len(text))
+	s.insertText(from, changed)
+}
+
+// JoinLines joins the lines from first to last into one line as a single change. The
+// indent of the joined lines is replaced with a space, no space is put after an empty
+// line or a blank, nor before an empty line or a ')'.
+func (s *Tab) JoinLines(first, last int) {
+	last = min(last, s.doc.LineCount()-1)
+	if s.hexMode || first >= last {
+		return
+	}
+	s.BeginChange()
+	defer s.EndChange()
+	col := 0
+	for range last - first {
+		end := s.doc.LineEnd(first)
+		line := s.doc.Line(first)
+		next := s.doc.Line(first + 1)
+		indent, _ := indentWidth(next)
+		var sep []byte
+		if len(line) > 0 && !isBlank(rune(line[len(line)-1])) && len(next) > indent && next[indent] != ')' {
+			sep = []byte{' '}
+		}
+		s.deleteText(end, 1+indent)
+		if len(sep) > 0 {
+			s.insertText(end, sep)
+		}
+		col = s.positionOf(end).Col
+	}
+	s.SetCursor(Position{Line: first, Col: col})
+}
+
+// padLine appends spaces to line n up to the screen column x
+func (s *Tab) padLine(n, x int) {
+	if width := s.screenCol(n, s.lineLen(n)); width < x {
+		s.insertText(s.doc.LineEnd(n), bytes.Repeat([]byte{' '}, x-width))
+	}
+}
+
+// executeVisual executes the visual mode command keys on the selection of tab
+func (s *Window) executeVisual(tab *Tab, keys string, count int, register rune) {
+	if keys == "<C-n>" {
+		if !tab.selectionToCursors() {
+			GlobalState.ToastMessage("no match")
+		}
+		GlobalState.SetMode(ModeView)
+		return
+	}
+	op := []rune(keys)[0]
+	if op == 'o' {
+		tab.swapAnchor()
+		return
+	}
+	block := tab.visual == ModeVisualBlock
+	if (op == 'I' || op == 'A') && !block {
+		return
+	}
+	first, last := tab.selectedLines()
+	left, right := tab.blockColumns()
+
+	// after c, the deleted text and the typed text are undone as a single step
+	tab.BeginChange()
+	defer tab.EndChange()
+	switch op {
+	case 'I':
+		s.insertBlock(tab, blockInsert{first: first, last: last, x: left})
+	case 'A':
+		s.insertBlock(tab, blockInsert{first: first, last: last, x: right, pad: true})
+	case 'c':
+		tab.applyVisual(op, register, count)
+		if block {
+			s.insertBlock(tab, blockInsert{first: first, last: last, x: left})
+		} else {
+			GlobalState.SetMode(ModeInsert)
+		}
+	default:
+		tab.applyVisual(op, register, count)
+		GlobalState.SetMode(ModeView)
+	}
+}
+
+// insertBlock starts insert mode at the start of a block insert, the typed text is
+// inserted on the other lines of the block when insert mode ends
+func (s *Window) insertBlock(tab *Tab, b blockInsert) {
+	if b.pad {
+		tab.padLine(b.first, b.x)
+	}
+	tab.SetCursor(Position{Line: b.first, Col: tab.colAtScreen(b.first, b.x)})
+	GlobalState.SetMode(ModeInsert)
+	s.insertChange = &change{block: &b}
+}
+
+// finishBlockInsert inserts the text typed on the first line of a block insert on the
+// other lines. Like vim, nothing is inserted when a line break was typed.
+func finishBlockInsert(tab *Tab, c *change) {
+	b := c.block
+	if slices.ContainsFunc(c.insert, func(k insertKey) bool { return k.key == tcell.KeyEnter }) {
+		return
+	}
+	for n := b.first + 1; n <= b.last; n++ {
+		if tab.screenCol(n, tab.lineLen(n)) < b.x {
+			if !b.pad {
+				continue
+			}
+			tab.padLine(n, b.x)
+		}
+		tab.SetCursor(Position{Line: n, Col: tab.colAtScreen(n, b.x)})
+		for _, k := range c.insert {
+			applyInsertKey(tab, k)
+		}
+	}
+	tab.SetCursor(Position{Line: b.first, Col: tab.colAtScreen(b.first, b.x)})
+}
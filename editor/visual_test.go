@@ -0,0 +1,71 @@
+package editor
+
+import (
+	"github.com/test-go/testify/require"
+	"testing"
+)
+
+func TestVisualOperators(t *testing.T) {
+	tests := []struct {
+		name, text, keys, want string
+		cursor                 Position
+	}{
+		{name: "delete chars", text: "one two three", keys: "wvlld", want: "one  three", cursor: Position{Col: 4}},
+		{name: "delete backwards", text: "one two three", keys: "wvhhx", want: "onwo three", cursor: Position{Col: 2}},
+		{name: "line break", text: "ab\ncd", keys: "lvlld", want: "acd", cursor: Position{Col: 1}},
+		{name: "delete lines", text: "a\nb\nc\nd", keys: "jVjd", want: "a\nd", cursor: Position{Line: 1}},
+		{name: "change", text: "one two", keys: "vecX<Esc>", want: "X two", cursor: Position{Col: 1}},
+		{name: "shift", text: "a\nb\nc", keys: "Vj2>", want: "\t\ta\n\t\tb\nc", cursor: Position{Col: 2}},
+		{name: "toggle case", text: "Hello World", keys: "v$~", want: "hELLO wORLD"},
+		{name: "lower", text: "ONE\nTWO", keys: "lVju", want: "one\ntwo"},
+		{name: "upper", text: "one two", keys: "wveU", want: "one TWO", cursor: Position{Col: 4}},
+		{name: "join", text: "a\n  b\n\nc)", keys: "VjjjJ", want: "a b c)", cursor: Position{Col: 3}},
+		{name: "join two lines", text: "a \nb\nc", keys: "vJ", want: "a b\nc", cursor: Position{Col: 2}},
+		{name: "swap anchor", text: "abcdef", keys: "lvlohx", want: "def"},
+		{name: "block delete", text: "abcd\nefgh\nij", keys: "l<C-v>jjld", want: "ad\neh\ni", cursor: Position{Col: 1}},
+		{name: "block insert", text: "abc\ndef\n\nghi", keys: "l<C-v>3jIX<Esc>", want: "aXbc\ndXef\n\ngXhi", cursor: Position{Col: 1}},
+		{name: "block append", text: "abc\nd\nefg", keys: "l<C-v>jjlAX<Esc>", want: "abcX\nd  X\nefgX", cursor: Position{Col: 3}},
+		{name: "block change", text: "abc\ndef", keys: "<C-v>jlcX<Esc>", want: "Xc\nXf"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, tab := newTestWindow(tt.text)
+			typeKeys(w, tt.keys)
+			require.Equal(t, tt.want, tabText(tab))
+			require.Equal(t, tt.cursor, tab.GetCursor())
+			require.True(t, GlobalState.IsMode(ModeView))
+			require.Equal(t, ModeView, tab.visual)
+		})
+	}
+}
+
+func TestVisualYankAndUndo(t *testing.T) {
+	registers = map[rune]register{}
+	w, tab := newTestWindow("abc\ndef\nghi")
+	typeKeys(w, "l<C-v>jly")
+	require.Equal(t, register{text: []byte("bc\nef"), blockwise: true}, registers['"'])
+	typeKeys(w, "jjP")
+	require.Equal(t, "abc\ndef\ngbchi\n ef", tabText(tab))
+
+	w, tab = newTestWindow("abc\ndef\nghi")
+	typeKeys(w, "<C-v>jjIXY<Esc>")
+	require.Equal(t, "XYabc\nXYdef\nXYghi", tabText(tab))
+	require.NoError(t, tab.Undo())
+	require.Equal(t, "abc\ndef\nghi", tabText(tab))
+}
+
+func TestVisualSelectionRender(t *testing.T) {
+	_, tab := newTestWindow("abcd\nefgh")
+	GlobalState.SetMode(ModeVisual)
+	tab.SetCursor(Position{Line: 1, Col: 1})
+	require.Equal(t, [][2]int{{0, 5}, {0, 2}}, [][2]int{selected(tab, 0), selected(tab, 1)})
+	GlobalState.SetMode(ModeVisualBlock)
+	require.Equal(t, [][2]int{{0, 2}, {0, 2}}, [][2]int{selected(tab, 0), selected(tab, 1)})
+	GlobalState.SetMode(ModeView)
+	require.Equal(t, [2]int{0, 0}, selected(tab, 0))
+}
+
+func selected(tab *Tab, n int) [2]int {
+	start, end := tab.selectedCols(n)
+	return [2]int{start, end}
+}
@@ -14,6 +14,16 @@ type Window struct {
 	layout.BaseElement
 	tabs      []*Tab
 	activeTab int
+	// insertTab is the tab that receives the edits of the current insert mode session
+	insertTab *Tab
+	// lastChange is the last command that changed the text, repeated by .
+	lastChange *change
+	// insertChange is the change that started the current insert mode session, the
+	// typed keys are recorded in it
+	insertChange *change
+	// searchStart is where the cursor was when the search being typed started, nil when
+	// not searching
+	searchStart *searchStart
 }
 
 // NewWindow creates a new window with an empty tab and registers event listeners
@@ -39,6 +49,11 @@ func (s *Window) AddTab(tab *Tab) {
 	s.SetActiveTab(len(s.tabs) - 1)
 }
 
+// ReplaceActiveTab replaces the active tab with tab
+func (s *Window) ReplaceActiveTab(tab *Tab) {
+	s.tabs[s.activeTab] = tab
+}
+
 // PreviousTab moves to the previous tab
 func (s *Window) PreviousTab() {
 	if s.activeTab > 0 {
@@ -53,8 +68,12 @@ func (s *Window) NextTab() {
 	}
 }
 
-// CloseTab closes the active tab
-func (s *Window) CloseTab() {
+// CloseTab closes the active tab, a tab with changes that were not written is only closed
+// when force is set
+func (s *Window) CloseTab(force bool) error {
+	if !force && s.GetActiveTab().Modified() {
+		return errNotWritten
+	}
 	if len(s.tabs) > 1 {
 		s.tabs = append(s.tabs[:s.activeTab], s.tabs[s.activeTab+1:]...)
 		if s.activeTab >= len(s.tabs) {
@@ -62,8 +81,9 @@ func (s *Window) CloseTab() {
 		}
 	} else {
 		s.tabs = []*Tab{}
-		s.AddTab(NewTab("new tab", NewEmptyLine(64)))
+		s.AddTab(NewTab("new tab", nil))
 	}
+	return nil
 }
 
 // GetPreferredSize returns the preferred size of the window
@@ -121,7 +141,7 @@ func (s *Window) getTitleComponent() layout.Element {
 				BottomLeftTee:  lo.Ternary(isFirst, tcell.RuneLTee, 0),
 			},
 			TextColor: color,
-			Size:      layout.Size{Width: len(content) + lo.Ternary(isFirst, 2, 1), Height: 3},
+			Size:      layout.Size{Width: layout.StringWidth(content) + lo.Ternary(isFirst, 2, 1), Height: 3},
 			Content:   content,
 		})
 	}
@@ -144,17 +164,66 @@ func (s *Window) MoveCursor(dx, dy int) {
 }
 
 func (s *Window) initEventListeners() {
+	OnEvent(func(e ModeChangedEvent) {
+		// all edits of an insert mode session are undone as a single step
+		if s.insertTab != nil {
+			s.finishInsert()
+			s.insertTab.EndChange()
+			s.insertTab = nil
+		}
+		if e.Mode == ModeInsert {
+			s.insertTab = s.GetActiveTab()
+			s.insertTab.BeginChange()
+		}
+		if isVisualMode(e.Mode) {
+			s.GetActiveTab().StartVisual(e.Mode)
+		} else {
+			s.GetActiveTab().EndVisual()
+		}
+		// a submitted search starts again from where the cursor was, a cancelled one
+		// stays there
+		s.restoreSearchStart(s.GetActiveTab(), true)
+	})
+	OnEvent(func(e SearchPreviewEvent) {
+		s.previewSearch(s.GetActiveTab(), e.Pattern, e.Backward)
+	})
+	OnEvent(func(e SearchEvent) {
+		s.search(s.GetActiveTab(), e.Pattern, e.Backward)
+	})
+	OnEvent(func(e VisualCommandEvent) {
+		s.executeVisual(s.GetActiveTab(), e.Keys, e.Count, e.Register)
+	})
+	OnEvent(func(e NormalCommandEvent) {
+		activeTab := s.GetActiveTab()
+		if e.Keys == "." {
+			s.repeatChange(activeTab, e.Count)
+			return
+		}
+		if c := s.executeNormal(activeTab, e.Keys, e.Count, e.Register); c != nil {
+			s.lastChange = c
+		}
+	})
 	OnEvent(func(e KeyEvent) {
+		// the keys answer the confirm prompt, the tab it is about stays active
+		if GlobalState.IsConfirming() {
+			return
+		}
 		if e.Target != s {
+			// the ctrl keys edit the command line when it has the focus
+			if e.Target == GlobalState {
+				return
+			}
 			switch e.Ev.Key() {
 			case tcell.KeyCtrlQ:
 				s.PreviousTab()
 			case tcell.KeyCtrlW:
-				s.CloseTab()
+				if err := s.CloseTab(false); err != nil {
+					GlobalState.ToastMessage(fmt.Sprintf("err: %v", err))
+				}
 			case tcell.KeyCtrlE:
 				s.NextTab()
 			case tcell.KeyCtrlT:
-				s.AddTab(NewTab("new tab", NewEmptyLine(64)))
+				s.AddTab(NewTab("new tab", nil))
 			default:
 				return
 			}
@@ -174,24 +243,16 @@ func (s *Window) initEventListeners() {
 			if err != nil {
 				GlobalState.ToastMessage(fmt.Sprintf("err: %v", err))
 			}
-		case tcell.KeyEnter:
-			if GlobalState.IsMode(ModeInsert) {
-				activeTab.InsertNewline()
-			}
-		case tcell.KeyBackspace, tcell.KeyBackspace2:
-			if GlobalState.IsMode(ModeInsert) {
-				activeTab.Backspace()
-			}
-		case tcell.KeyDelete:
+		case tcell.KeyEnter, tcell.KeyBackspace, tcell.KeyBackspace2, tcell.KeyDelete:
 			if GlobalState.IsMode(ModeInsert) {
-				activeTab.Delete()
+				s.typeKey(activeTab, insertKey{key: e.Ev.Key()})
 			}
 		default:
 			if e.Ev.Rune() == 0 {
 				return
 			}
 			if GlobalState.IsMode(ModeInsert) && s.IsFocused() {
-				activeTab.InsertRune(e.Ev.Rune())
+				s.typeKey(activeTab, insertKey{key: tcell.KeyRune, r: e.Ev.Rune()})
 				return
 			}
 		}
@@ -0,0 +1,399 @@
+package editor
+
+import (
+	"bytes"
+	"github.com/dangdungcntt/ndditor/editor/layout"
+	"github.com/gdamore/tcell/v2"
+	"iter"
+	"math"
+	"slices"
+)
+
+// nonTextStyle is the style of the markers that are not part of the text, like showbreak
+var nonTextStyle = tcell.StyleDefault.Foreground(tcell.ColorBlue)
+
+// placedCluster is a cluster with the place where it is drawn. row is the screen row
+// counted from the first row of the line, x the screen column on that row.
+type placedCluster struct {
+	cluster
+	row int
+	x   int
+}
+
+// lineLayout places the clusters of a line on screen rows
+type lineLayout struct {
+	// width is the width of a row, 0 means lines are not wrapped
+	width int
+	// lineBreak breaks rows after a blank instead of at the last character that fits
+	lineBreak bool
+	// showBreak is drawn at the start of every row but the first one of a line
+	showBreak      string
+	showBreakWidth int
+}
+
+// lineLayout returns the layout of the lines of the tab for the current options
+func (s *Tab) lineLayout() lineLayout {
+	if !globalOptions.wrap {
+		return lineLayout{}
+	}
+	l := lineLayout{
+		width:     s.GetRenderSize().Width,
+		lineBreak: globalOptions.lineBreak,
+	}
+	// showbreak is left out when it would not leave any room for the text
+	if width := layout.StringWidth(globalOptions.showBreak); width < l.width {
+		l.showBreak = globalOptions.showBreak
+		l.showBreakWidth = width
+	}
+	return l
+}
+
+// rowStart returns the screen column of the first cluster of a row
+func (l lineLayout) rowStart(row int) int {
+	if row == 0 {
+		return 0
+	}
+	return l.showBreakWidth
+}
+
+// place places clusters on screen rows. A cluster that does not fit on a row moves to
+// the next one, with lineBreak the clusters after the last blank of the row move with it.
+func (l lineLayout) place(clusters iter.Seq[cluster]) iter.Seq[placedCluster] {
+	return l.placeAt(clusters, 0)
+}
+
+// placeAt places clusters that start at the beginning of the given row
+func (l lineLayout) placeAt(clusters iter.Seq[cluster], row int) iter.Seq[placedCluster] {
+	return func(yield func(placedCluster) bool) {
+		if l.width <= 0 {
+			x := 0
+			for c := range clusters {
+				if !yield(placedCluster{cluster: c, x: x}) {
+					return
+				}
+				x += c.width
+			}
+			return
+		}
+
+		x := l.rowStart(row)
+		// with lineBreak the clusters of the current row are kept until the row is
+		// complete, because the last word may still move to the next row
+		var pending []placedCluster
+		var add func(c cluster) bool
+		add = func(c cluster) bool {
+			if x+c.width > l.width && x > l.rowStart(row) {
+				var carry []placedCluster
+				if i := lastBlank(pending); i >= 0 {
+					carry = slices.Clone(pending[i+1:])
+					pending = pending[:i+1]
+				}
+				for _, p := range pending {
+					if !yield(p) {
+						return false
+					}
+				}
+				pending = pending[:0]
+				row++
+				x = l.rowStart(row)
+				for _, p := range carry {
+					if !add(p.cluster) {
+						return false
+					}
+				}
+			}
+			p := placedCluster{cluster: c, row: row, x: x}
+			x += c.width
+			if !l.lineBreak {
+				return yield(p)
+			}
+			pending = append(pending, p)
+			return true
+		}
+		for c := range clusters {
+			if !add(c) {
+				return
+			}
+		}
+		for _, p := range pending {
+			if !yield(p) {
+				return
+			}
+		}
+	}
+}
+
+// lastBlank returns the index of the last space or tab in clusters, or -1
+func lastBlank(clusters []placedCluster) int {
+	for i := len(clusters) - 1; i >= 0; i-- {
+		if r := clusters[i].runes[0]; r == ' ' || r == '\t' {
+			return i
+		}
+	}
+	return -1
+}
+
+// wrapIndexMinLen is the length in bytes from which the row starts of a line are indexed
+const wrapIndexMinLen = 4096
+
+// wrapMarkRows is the number of rows between two indexed row starts
+const wrapMarkRows = 64
+
+// rowMark is the start of a row of a wrapped line, the start of its first cluster
+type rowMark struct {
+	row int
+	colMark
+}
+
+// wrapIndex keeps the start of every wrapMarkRows-th row of a long line, so that its
+// rows are placed from the closest mark instead of from the start of the line.
+// The marks are added as the line is placed, rows is 0 until the whole line was placed.
+type wrapIndex struct {
+	marks []rowMark
+	rows  int
+	// full is true when the last row is filled up to the width
+	full bool
+}
+
+// truncate drops what depends on the text from offset, relative to the start of the line.
+// The rows just before offset may change too, a combining mark joins the previous
+// cluster and with lineBreak the last word of a row moves to the next one.
+func (w *wrapIndex) truncate(offset int) {
+	i := 0
+	for i < len(w.marks) && w.marks[i].offset < offset {
+		i++
+	}
+	w.marks = w.marks[:max(i-1, 1)]
+	w.rows = 0
+}
+
+// wrapIndex returns the index of the rows of line n, nil when the line is short enough
+// to be placed from its start every time
+func (s *Tab) wrapIndex(l lineLayout, n int) *wrapIndex {
+	if l.width <= 0 || s.doc.LineEnd(n)-s.doc.LineStart(n) < wrapIndexMinLen {
+		return nil
+	}
+	// the indexes hold for a layout and a tabstop
+	if l != s.wrapLayout || globalOptions.tabStop != s.wrapTabStop {
+		s.wraps = nil
+		s.wrapLayout, s.wrapTabStop = l, globalOptions.tabStop
+	}
+	if s.wraps == nil {
+		s.wraps = map[int]*wrapIndex{}
+	}
+	w := s.wraps[n]
+	if w == nil {
+		w = &wrapIndex{marks: []rowMark{{}}}
+		s.wraps[n] = w
+	}
+	return w
+}
+
+// invalidateIndexes updates the wrap and the cluster indexes before text is inserted or
+// deleted at offset. The indexes of the edited line are truncated, the indexes of the
+// lines below move with their lines.
+func (s *Tab) invalidateIndexes(offset int, text []byte, insert bool) {
+	if len(s.wraps) == 0 && len(s.cols) == 0 {
+		return
+	}
+	line := s.doc.LineAt(offset)
+	rel := offset - s.doc.LineStart(line)
+	if w := s.wraps[line]; w != nil {
+		w.truncate(rel)
+	}
+	if c := s.cols[line]; c != nil {
+		c.truncate(rel)
+	}
+	lines := bytes.Count(text, []byte{'\n'})
+	s.wraps = moveIndexes(s.wraps, line, lines, insert)
+	s.cols = moveIndexes(s.cols, line, lines, insert)
+}
+
+// moveIndexes moves the values of the lines after line by the lines inserted or deleted
+// after it, the values of the deleted lines are dropped
+func moveIndexes[T any](m map[int]T, line, lines int, insert bool) map[int]T {
+	if lines == 0 || len(m) == 0 {
+		return m
+	}
+	res := make(map[int]T, len(m))
+	for n, v := range m {
+		switch {
+		case n <= line:
+			res[n] = v
+		case insert:
+			res[n+lines] = v
+		case n > line+lines:
+			res[n-lines] = v
+		}
+	}
+	return res
+}
+
+// placeLine places the clusters of line n starting at the row of the last mark for which
+// from is true, the clusters of the rows before it are left out. Without an index, or
+// when from is never true, the line is placed from its start.
+func (s *Tab) placeLine(l lineLayout, n int, from func(rowMark) bool) iter.Seq[placedCluster] {
+	if l.width <= 0 {
+		return func(yield func(placedCluster) bool) {
+			for m, c := range s.lineClustersFrom(n, func(m colMark) bool { return from(rowMark{colMark: m}) }) {
+				if !yield(placedCluster{cluster: c, x: m.screenX}) {
+					return
+				}
+			}
+		}
+	}
+	w := s.wrapIndex(l, n)
+	if w == nil {
+		return l.place(s.lineClusters(n))
+	}
+	i := 0
+	for i+1 < len(w.marks) && from(w.marks[i+1]) {
+		i++
+	}
+	m := w.marks[i]
+	return func(yield func(placedCluster) bool) {
+		start := s.doc.LineStart(n)
+		runes := func(yield func(rune) bool) {
+			for _, r := range docRunes(s.doc, start+m.offset, s.doc.LineEnd(n)) {
+				if !yield(r) {
+					return
+				}
+			}
+		}
+		offset, screenX := m.offset, m.screenX
+		row := m.row
+		for p := range l.placeAt(streamClustersAt(runes, m.col, m.screenX), m.row) {
+			// the marks are only added past the last one, the line is placed in order
+			if last := w.marks[len(w.marks)-1]; p.row != row && p.row == last.row+wrapMarkRows {
+				w.marks = append(w.marks, rowMark{row: p.row, colMark: colMark{col: p.col, offset: offset, screenX: screenX}})
+			}
+			row = p.row
+			if !yield(p) {
+				return
+			}
+			for _, r := range p.runes {
+				offset += runeLen(r)
+			}
+			screenX += p.width
+		}
+	}
+}
+
+// lineRows returns the number of screen rows of line n and whether its last row is full
+func (s *Tab) lineRows(l lineLayout, n int) (rows int, full bool) {
+	w := s.wrapIndex(l, n)
+	if w != nil && w.rows > 0 {
+		return w.rows, w.full
+	}
+	rows, x := 1, 0
+	for p := range s.placeLine(l, n, func(rowMark) bool { return true }) {
+		rows, x = p.row+1, p.x+p.width
+	}
+	full = l.width > 0 && x >= l.width
+	if w != nil {
+		w.rows, w.full = rows, full
+	}
+	return rows, full
+}
+
+// placeOf returns the row and the screen column of column col of line n.
+// The end of a line that fills its last row is placed at the start of the next row.
+func (s *Tab) placeOf(l lineLayout, n, col int) (row, x int) {
+	for p := range s.placeLine(l, n, func(m rowMark) bool { return m.col <= col }) {
+		if col < p.end() {
+			return p.row, p.x
+		}
+		row, x = p.row, p.x+p.width
+	}
+	if l.width > 0 && x >= l.width {
+		return row + 1, l.rowStart(row + 1)
+	}
+	return row, x
+}
+
+// rowCount returns the number of screen rows of line n, including the row of the
+// cursor when it is at the end of a line that fills its last row
+func (s *Tab) rowCount(l lineLayout, n int) int {
+	if l.width <= 0 {
+		return 1
+	}
+	rows, full := s.lineRows(l, n)
+	if full && n == s.lineIndex {
+		return rows + 1
+	}
+	return rows
+}
+
+// hasRow returns true when row is one of the rows counted by rowCount, the line is only
+// placed up to that row when its rows are not known yet
+func (s *Tab) hasRow(l lineLayout, n, row int) bool {
+	if w := s.wrapIndex(l, n); w != nil && w.rows == 0 {
+		for p := range s.placeLine(l, n, func(m rowMark) bool { return m.row <= row }) {
+			if p.row >= row {
+				return true
+			}
+		}
+	}
+	return row < s.rowCount(l, n)
+}
+
+// colAtRow returns the column of the cluster drawn at screen column x of the given row
+// of line n. Past the end of a row it is the last cluster of the row, or the end of the
+// line on its last row.
+func (s *Tab) colAtRow(l lineLayout, n, row, x int) int {
+	last := -1
+	end := 0
+	for p := range s.placeLine(l, n, func(m rowMark) bool { return m.row <= row && (l.width > 0 || m.screenX <= x) }) {
+		if p.row > row {
+			if last >= 0 {
+				return last
+			}
+			return p.col
+		}
+		if p.row == row {
+			if x < p.x+p.width {
+				return p.col
+			}
+			last = p.col
+		}
+		end = p.end()
+	}
+	return end
+}
+
+// rowTarget returns the position dy screen rows below pos, or above when dy is negative.
+// Rows of wrapped lines are counted separately, the wanted screen column is kept, or the
+// end of the rows after $. ok is false when the cursor cannot move at all.
+func (s *Tab) rowTarget(pos Position, dy int) (Position, bool) {
+	l := s.lineLayout()
+	n := pos.Line
+	row, _ := s.placeOf(l, n, pos.Col)
+	moved := false
+	for ; dy > 0; dy-- {
+		if s.hasRow(l, n, row+1) {
+			row++
+		} else if n+1 < s.doc.LineCount() {
+			n++
+			row = 0
+		} else {
+			break
+		}
+		moved = true
+	}
+	for ; dy < 0; dy++ {
+		if row > 0 {
+			row--
+		} else if n > 0 {
+			n--
+			row = s.rowCount(l, n) - 1
+		} else {
+			break
+		}
+		moved = true
+	}
+	x := s.want.rowX
+	if s.want.end {
+		x = math.MaxInt
+	}
+	return Position{Line: n, Col: s.colAtRow(l, n, row, x)}, moved
+}
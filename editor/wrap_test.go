@@ -0,0 +1,165 @@
+package editor
+
+import (
+	"github.com/dangdungcntt/ndditor/editor/layout"
+	"github.com/gdamore/tcell/v2"
+	"github.com/test-go/testify/require"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func placedRows(l lineLayout, line string) [][]int {
+	var rows [][]int
+	for p := range l.place(slices.Values(lineClusters([]rune(line)))) {
+		if p.row == len(rows) {
+			rows = append(rows, nil)
+		}
+		rows[p.row] = append(rows[p.row], p.x)
+	}
+	return rows
+}
+
+func TestPlaceWrapped(t *testing.T) {
+	l := lineLayout{width: 4}
+	require.Equal(t, [][]int{{0, 1, 2, 3}, {0, 1}}, placedRows(l, "abcdef"))
+	require.Equal(t, [][]int{{0, 1}, {0}}, placedRows(l, "a世世"))
+
+	l = lineLayout{width: 5, showBreak: ">", showBreakWidth: 1}
+	require.Equal(t, [][]int{{0, 1, 2, 3, 4}, {1, 2, 3, 4}, {1}}, placedRows(l, "abcdefghij"))
+
+	l = lineLayout{width: 6, lineBreak: true}
+	require.Equal(t, [][]int{{0, 1, 2}, {0, 1, 2, 3, 4, 5}, {0, 1}}, placedRows(l, "ab cdefghij"))
+}
+
+func TestMoveRowsAndScroll(t *testing.T) {
+	tab := NewTab("", NewPieceTable([]byte("abcdefghij\nxy\n0123456789ab")))
+	tab.SetRenderSize(layout.Size{Width: 4, Height: 3})
+
+	tab.SetCursor(Position{Col: 5})
+	require.Equal(t, 1, tab.want.rowX)
+	tab.MoveCursor(0, 1)
+	require.Equal(t, Position{Line: 0, Col: 9}, tab.GetCursor())
+	tab.MoveCursor(0, 1)
+	require.Equal(t, Position{Line: 1, Col: 1}, tab.GetCursor())
+	tab.MoveCursor(0, 2)
+	require.Equal(t, Position{Line: 2, Col: 5}, tab.GetCursor())
+
+	tab.scroll()
+	require.Equal(t, 1, tab.topLine)
+	require.Equal(t, 0, tab.topRow)
+	require.Equal(t, 2, tab.cursorPos.Y)
+
+	tab.MoveCursor(0, -3)
+	require.Equal(t, Position{Line: 0, Col: 9}, tab.GetCursor())
+	tab.scroll()
+	require.Equal(t, 0, tab.topLine)
+	require.Equal(t, 2, tab.topRow)
+	require.Equal(t, 0, tab.cursorPos.Y)
+
+	// the end of a line that fills its last row is on a row of its own
+	tab.SetCursor(Position{Line: 2, Col: 12})
+	require.Equal(t, 4, tab.rowCount(tab.lineLayout(), 2))
+	require.Equal(t, 0, tab.want.rowX)
+}
+
+func TestWrapLongLine(t *testing.T) {
+	line := strings.Repeat("abc def\tg ", 1<<20)
+	tab := NewTab("", NewPieceTable([]byte("first\n"+line+"\nlast")))
+	tab.SetRenderSize(layout.Size{Width: 80, Height: 24})
+	screen := tcell.NewSimulationScreen("")
+	require.NoError(t, screen.Init())
+	screen.SetSize(80, 24)
+	render := func() { tab.Render(screen, layout.Point{}) }
+
+	// only the visible rows are placed, the rows of the long line are counted once
+	fast := func(name string, f func()) {
+		start := time.Now()
+		f()
+		require.True(t, time.Since(start) < 200*time.Millisecond, name)
+	}
+	fast("render", render)
+	fast("down", func() { tab.MoveCursor(0, 2) })
+	require.Equal(t, Position{Line: 1, Col: 56}, tab.GetCursor())
+	fast("render", render)
+	tab.SetCursor(Position{Line: 2})
+	render()
+	require.Equal(t, 1, tab.topLine)
+	fast("up", func() { tab.MoveCursor(0, -1) })
+	fast("render", render)
+	fast("up", func() { tab.MoveCursor(0, -1) })
+	fast("render", render)
+	fast("insert", func() { tab.InsertRune('x') })
+	fast("render", render)
+
+	// the index of the edited line is truncated, the rows are counted again after the edit
+	rows := tab.rowCount(tab.lineLayout(), 1)
+	fresh := NewTab("", NewPieceTable([]byte(tabText(tab))))
+	fresh.SetRenderSize(layout.Size{Width: 80, Height: 24})
+	require.Equal(t, fresh.rowCount(fresh.lineLayout(), 1), rows)
+	pos := tab.GetCursor()
+	require.Equal(t, fresh.colAtRow(fresh.lineLayout(), 1, 5000, 7), tab.colAtRow(tab.lineLayout(), 1, 5000, 7))
+	require.Equal(t, pos, tab.GetCursor())
+}
+
+func TestWrapIndex(t *testing.T) {
+	defer func(lineBreak bool) { globalOptions.lineBreak = lineBreak }(globalOptions.lineBreak)
+	globalOptions.lineBreak = true
+	line := strings.Repeat("abc défg\thi 世界 ", 5000)
+	tab := NewTab("", NewPieceTable([]byte("first\n"+line+"\n"+line)))
+	tab.SetRenderSize(layout.Size{Width: 30, Height: 10})
+	l := tab.lineLayout()
+	same := func() {
+		fresh := NewTab("", NewPieceTable([]byte(tabText(tab))))
+		fresh.SetRenderSize(layout.Size{Width: 30, Height: 10})
+		for n := 1; n < tab.doc.LineCount(); n++ {
+			rows := fresh.rowCount(l, n)
+			require.Equal(t, rows, tab.rowCount(l, n))
+			for _, row := range []int{0, 100, 1000, rows - 1} {
+				require.Equal(t, fresh.colAtRow(l, n, row, 5), tab.colAtRow(l, n, row, 5))
+			}
+		}
+	}
+	tab.rowCount(l, 1)
+	tab.rowCount(l, 2)
+	require.True(t, len(tab.wraps[1].marks) > 10)
+	same()
+
+	// a combining mark joins the previous cluster, a longer word moves to the next row
+	offset := tab.doc.LineStart(1) + len(line)/2
+	tab.insertText(offset, []byte("́xxxxxxxx"))
+	same()
+	tab.deleteText(offset, 5)
+	same()
+	// the index of the line below moves with it
+	tab.insertText(offset, []byte("\n"))
+	require.NotNil(t, tab.wraps[3])
+	same()
+	require.NoError(t, tab.Undo())
+	same()
+}
+
+func TestStayAtLineEnd(t *testing.T) {
+	long := strings.Repeat("x", 300)
+	tab := NewTab("", NewPieceTable([]byte(long+"\n"+strings.Repeat("y", 50)+"\n"+long)))
+	tab.SetRenderSize(layout.Size{Width: 20, Height: 10})
+	move := func(keys string) Position {
+		m, arg, status := parseMotion(keys)
+		require.Equal(t, keysComplete, status, keys)
+		require.True(t, tab.applyMotion(m, 0, arg), keys)
+		return tab.GetCursor()
+	}
+
+	// after $ the cursor stays at the end of the lines, and of the rows with gj and gk
+	require.Equal(t, Position{Col: 299}, move("$"))
+	require.Equal(t, Position{Line: 1, Col: 49}, move("j"))
+	require.Equal(t, Position{Line: 0, Col: 299}, move("k"))
+	require.Equal(t, Position{Line: 1, Col: 49}, move("j"))
+	require.Equal(t, Position{Line: 1, Col: 39}, move("gk"))
+	require.Equal(t, Position{Line: 1, Col: 19}, move("gk"))
+
+	// another motion forgets it
+	move("h")
+	require.Equal(t, Position{Line: 2, Col: 18}, move("j"))
+}